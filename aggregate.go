@@ -71,10 +71,40 @@ func (a *AggregateBase) IncrementVersion() {
 // TrackChange stores the EventMessage in the changes collection.
 //
 // Changes are new, unpersisted events that have been applied to the aggregate.
+// The event is given an event id and occurred at time if it does not have
+// them.
 func (a *AggregateBase) TrackChange(event EventMessage) {
+	stampEvent(event)
 	a.changes = append(a.changes, event)
 }
 
+// RaiseEvent wraps an event payload in an EventMessage for the aggregate,
+// tracks it as a change, applies it to the aggregate and returns it.
+//
+// The message is given the aggregate id, the next version of the aggregate,
+// an event id and the time at which it occurred. Go has no virtual methods,
+// so the aggregate that embeds the AggregateBase is passed in to have its
+// Apply method called. Since RaiseEvent tracks the event itself, Apply is
+// called with isNew false, as it is for events loaded from the store.
+//
+// 	func (a *InventoryItem) Deactivate() error {
+// 		a.RaiseEvent(a, &InventoryItemDeactivated{ID: a.AggregateID()})
+// 		return nil
+// 	}
+func (a *AggregateBase) RaiseEvent(aggregate AggregateRoot, event interface{}) EventMessage {
+	em := a.newEvent(event)
+	a.TrackChange(em)
+	aggregate.Apply(em, false)
+	return em
+}
+
+// newEvent returns a new event message of the aggregate at the next version.
+func (a *AggregateBase) newEvent(event interface{}) EventMessage {
+	em := NewEventMessage(a.id, event, Int(a.CurrentVersion()+1))
+	stampEvent(em)
+	return em
+}
+
 // GetChanges returns the collection of new unpersisted events that have
 // been applied to the aggregate.
 func (a *AggregateBase) GetChanges() []EventMessage {
@@ -102,3 +102,97 @@ func (t *SomeOtherAggregate) Handle(command CommandMessage) error {
 
 type EmptyAggregate struct {
 }
+
+func (s *AggregateBaseSuite) TestTrackChangeStampsTheEvent(c *C) {
+	agg := NewAggregateBase(NewUUID())
+	ev := NewTestEventMessage(agg.AggregateID())
+
+	agg.TrackChange(ev)
+
+	c.Assert(ev.EventID(), Not(Equals), "")
+	c.Assert(ev.OccurredAt().IsZero(), Equals, false)
+}
+
+// SomeTrackingAggregate is an aggregate that tracks new events as changes in
+// the conventional way.
+type SomeTrackingAggregate struct {
+	*AggregateBase
+	applied []EventMessage
+}
+
+func (t *SomeTrackingAggregate) Apply(event EventMessage, isNew bool) {
+	if isNew {
+		t.TrackChange(event)
+	}
+	t.applied = append(t.applied, event)
+}
+
+func (s *AggregateBaseSuite) TestRaiseEventAppliesAndTracksTheEvent(c *C) {
+	id := NewUUID()
+	agg := &SomeTrackingAggregate{AggregateBase: NewAggregateBase(id)}
+
+	em := agg.RaiseEvent(agg, &SomeEvent{"Some data", 4})
+
+	c.Assert(agg.applied, DeepEquals, []EventMessage{em})
+	c.Assert(agg.GetChanges(), DeepEquals, []EventMessage{em})
+	c.Assert(em.AggregateID(), Equals, id)
+	c.Assert(em.Event(), DeepEquals, &SomeEvent{"Some data", 4})
+	c.Assert(EventIDOf(em), Not(Equals), "")
+	c.Assert(OccurredAtOf(em).IsZero(), Equals, false)
+}
+
+// SomeForgetfulAggregate is an aggregate whose Apply does not track new
+// events as changes.
+type SomeForgetfulAggregate struct {
+	*AggregateBase
+	applied []bool
+}
+
+func (t *SomeForgetfulAggregate) Apply(event EventMessage, isNew bool) {
+	t.applied = append(t.applied, isNew)
+}
+
+func (s *AggregateBaseSuite) TestRaiseEventTracksTheEventItself(c *C) {
+	agg := &SomeForgetfulAggregate{AggregateBase: NewAggregateBase(NewUUID())}
+
+	first := agg.RaiseEvent(agg, &SomeEvent{"Some data", 4})
+	second := agg.RaiseEvent(agg, &SomeEvent{"Some data", 5})
+
+	c.Assert(agg.GetChanges(), DeepEquals, []EventMessage{first, second})
+	c.Assert(agg.applied, DeepEquals, []bool{false, false})
+	c.Assert(*second.Version(), Equals, 1)
+}
+
+func (s *AggregateBaseSuite) TestRaiseEventAssignsTheVersionTheEventIsStoredAt(c *C) {
+	agg := &SomeTrackingAggregate{AggregateBase: NewAggregateBase(NewUUID())}
+	agg.IncrementVersion()
+	agg.IncrementVersion()
+
+	first := agg.RaiseEvent(agg, &SomeEvent{"Some data", 4})
+	second := agg.RaiseEvent(agg, &SomeOtherEvent{"Some order"})
+
+	c.Assert(*first.Version(), Equals, 2)
+	c.Assert(*second.Version(), Equals, 3)
+	c.Assert(agg.CurrentVersion(), Equals, 3)
+}
+
+func (s *AggregateBaseSuite) TestRaisedEventVersionsMatchTheStore(c *C) {
+	repo, _ := NewInMemoryRepository(NewInternalEventBus())
+	factory := NewDelegateAggregateFactory()
+	factory.RegisterConstructors(func(id string) *SomeTrackingAggregate {
+		return &SomeTrackingAggregate{AggregateBase: NewAggregateBase(id)}
+	})
+	repo.SetAggregateFactory(factory)
+	id := NewUUID()
+	agg := &SomeTrackingAggregate{AggregateBase: NewAggregateBase(id)}
+	agg.RaiseEvent(agg, &SomeEvent{"Some data", 4})
+	agg.RaiseEvent(agg, &SomeEvent{"Some data", 5})
+	raised := agg.GetChanges()
+
+	c.Assert(repo.Save(agg, nil), IsNil)
+
+	got, err := repo.Load(typeOf(agg), id)
+	c.Assert(err, IsNil)
+	c.Assert(*got.(*SomeTrackingAggregate).applied[1].Version(), Equals, *raised[1].Version())
+	c.Assert(got.OriginalVersion(), Equals, *raised[1].Version())
+}
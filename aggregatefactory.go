@@ -7,6 +7,7 @@ package ycq
 
 import (
 	"fmt"
+	"reflect"
 )
 
 // AggregateFactory returns aggregate instances of a specified type with the
@@ -37,7 +38,10 @@ func NewDelegateAggregateFactory() *DelegateAggregateFactory {
 // 	func(id string) AggregateRoot {return NewMyAggregateType(id)}
 // 	func(id string) AggregateRoot { return &MyAggregateType{AggregateBase:NewAggregateBase(id)} }
 func (t *DelegateAggregateFactory) RegisterDelegate(aggregate AggregateRoot, delegate func(string) AggregateRoot) error {
-	typeName := typeOf(aggregate)
+	typeName, err := TypeNameOf(aggregate)
+	if err != nil {
+		return err
+	}
 	if _, ok := t.delegates[typeName]; ok {
 		return fmt.Errorf("Factory delegate already registered for type: \"%s\"", typeName)
 	}
@@ -45,9 +49,63 @@ func (t *DelegateAggregateFactory) RegisterDelegate(aggregate AggregateRoot, del
 	return nil
 }
 
+// RegisterConstructors registers aggregate constructors that follow the
+// convention of a function taking the aggregate id and returning the new
+// aggregate, so no delegate is needed.
+//
+// 	factory.RegisterConstructors(NewMyAggregateType, NewMyOtherAggregateType)
+//
+// Each constructor is called once with an empty id when it is registered to
+// determine the type of the aggregate. All of the constructors that can be
+// registered are, and every mistake, such as a function of the wrong
+// signature or a type that is already registered, is returned together in
+// an *ErrRegistration.
+func (t *DelegateAggregateFactory) RegisterConstructors(constructors ...interface{}) error {
+	var errs []error
+	for _, constructor := range constructors {
+		delegate, err := newAggregateDelegate(constructor)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		aggregate := delegate("")
+		if aggregate == nil {
+			errs = append(errs, fmt.Errorf("Aggregate constructor of type %T returned nil.", constructor))
+			continue
+		}
+		if err := t.RegisterDelegate(aggregate, delegate); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ErrRegistration{Errors: errs}
+	}
+	return nil
+}
+
+var aggregateRootType = reflect.TypeOf((*AggregateRoot)(nil)).Elem()
+
+// newAggregateDelegate returns a delegate that calls an aggregate constructor
+// of the form func(string) T where T implements AggregateRoot.
+func newAggregateDelegate(constructor interface{}) (func(string) AggregateRoot, error) {
+	v := reflect.ValueOf(constructor)
+	if !v.IsValid() || v.Kind() != reflect.Func || v.IsNil() ||
+		v.Type().NumIn() != 1 || v.Type().In(0).Kind() != reflect.String ||
+		v.Type().NumOut() != 1 || !v.Type().Out(0).Implements(aggregateRootType) {
+		return nil, fmt.Errorf("Aggregate constructor of type %T must be a func(string) returning an AggregateRoot.", constructor)
+	}
+
+	return func(id string) AggregateRoot {
+		aggregate, _ := v.Call([]reflect.Value{reflect.ValueOf(id).Convert(v.Type().In(0))})[0].Interface().(AggregateRoot)
+		return aggregate
+	}, nil
+}
+
 // GetAggregate calls the delegate for the type specified and returns the result.
+// The type may be given by a registered alias.
 func (t *DelegateAggregateFactory) GetAggregate(typeName string, id string) AggregateRoot {
-	if f, ok := t.delegates[typeName]; ok {
+	if f, ok := t.delegates[ResolveTypeName(typeName)]; ok {
 		return f(id)
 	}
 	return nil
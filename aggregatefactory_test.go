@@ -62,3 +62,35 @@ func (s *DelegateAggregateFactorySuite) TestCanGetAggregateInstanceFromString(c
 	ev := s.factory.GetAggregate(typeOf(&SomeAggregate{}), id)
 	c.Assert(ev, DeepEquals, NewSomeAggregate(id))
 }
+
+func (s *DelegateAggregateFactorySuite) TestRegisterConstructors(c *C) {
+	err := s.factory.RegisterConstructors(NewSomeAggregate, NewSomeOtherAggregate)
+
+	c.Assert(err, IsNil)
+	id := NewUUID()
+	agg := s.factory.GetAggregate(typeOf(&SomeAggregate{}), id)
+	c.Assert(agg, FitsTypeOf, &SomeAggregate{})
+	c.Assert(agg.AggregateID(), Equals, id)
+	c.Assert(s.factory.GetAggregate(typeOf(&SomeOtherAggregate{}), id), FitsTypeOf, &SomeOtherAggregate{})
+}
+
+func (s *DelegateAggregateFactorySuite) TestRegisterConstructorsAcceptsConcreteReturnTypes(c *C) {
+	err := s.factory.RegisterConstructors(func(id string) *SomeAggregate {
+		return &SomeAggregate{AggregateBase: NewAggregateBase(id)}
+	})
+
+	c.Assert(err, IsNil)
+	c.Assert(s.factory.GetAggregate(typeOf(&SomeAggregate{}), "1").AggregateID(), Equals, "1")
+}
+
+func (s *DelegateAggregateFactorySuite) TestRegisterConstructorsReportsAllMistakes(c *C) {
+	err := s.factory.RegisterConstructors(NewSomeAggregate, NewSomeAggregate, func() AggregateRoot { return nil },
+		func(id string) AggregateRoot { return nil })
+
+	c.Assert(err, FitsTypeOf, &ErrRegistration{})
+	errs := err.(*ErrRegistration).Errors
+	c.Assert(errs, HasLen, 3)
+	c.Assert(errs[0], ErrorMatches, "Factory delegate already registered for type: \"SomeAggregate\"")
+	c.Assert(errs[1], ErrorMatches, "Aggregate constructor of type func\\(\\) ycq.AggregateRoot must be a func\\(string\\) returning an AggregateRoot.")
+	c.Assert(errs[2], ErrorMatches, "Aggregate constructor of type func\\(string\\) ycq.AggregateRoot returned nil.")
+}
@@ -0,0 +1,162 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ArchivedEvent is an event of a deleted stream as it is held in an archive.
+type ArchivedEvent struct {
+	StreamName  string
+	EventID     string
+	EventType   string
+	EventNumber int
+	Data        json.RawMessage
+	MetaData    json.RawMessage `json:",omitempty"`
+}
+
+// Archive is the interface that an archive of deleted streams must implement.
+//
+// When an archive is set on a repository, the events of a stream are written
+// to the archive before the stream is deleted.
+type Archive interface {
+	Archive(streamName string, events []*ArchivedEvent) error
+}
+
+// FileArchive is an Archive that writes each deleted stream to a gzip
+// compressed file of JSON encoded events, one per line.
+type FileArchive struct {
+	dir string
+	now func() time.Time
+}
+
+// NewFileArchive constructs a new FileArchive that writes archives to the
+// directory specified. The directory is created if it does not exist.
+func NewFileArchive(dir string) (*FileArchive, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &FileArchive{
+		dir: dir,
+		now: time.Now,
+	}, nil
+}
+
+// Archive writes the events of a stream to a new archive file.
+//
+// Files are named with the hex encoded stream name and the time of
+// archival, so that a stream that is recreated and deleted again is archived
+// to a separate file.
+func (a *FileArchive) Archive(streamName string, events []*ArchivedEvent) error {
+	tmp, err := ioutil.TempFile(a.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+
+	if err := writeArchive(tmp, events); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%020d.jsonl.gz", hex.EncodeToString([]byte(streamName)), a.now().UnixNano())
+	if err := os.Rename(tmp.Name(), filepath.Join(a.dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Files returns the paths of the archive files of a stream, oldest first.
+func (a *FileArchive) Files(streamName string) ([]string, error) {
+	return filepath.Glob(filepath.Join(a.dir, hex.EncodeToString([]byte(streamName))+"-*.jsonl.gz"))
+}
+
+// ReferencedBlobs returns the ids of the blobs that the claim checked events
+// in the archive files of a stream refer to.
+func (a *FileArchive) ReferencedBlobs(streamName string) ([]string, error) {
+	files, err := a.Files(streamName)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, file := range files {
+		events, err := ReadArchive(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, ev := range events {
+			if len(ev.MetaData) == 0 {
+				continue
+			}
+			meta := make(map[string]interface{})
+			if err := json.Unmarshal(ev.MetaData, &meta); err != nil {
+				return nil, err
+			}
+			if id := stringHeader(meta, ClaimCheckHeader); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// ReadArchive reads the events from an archive file written by a
+// FileArchive.
+func ReadArchive(path string) ([]*ArchivedEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var events []*ArchivedEvent
+	dec := json.NewDecoder(r)
+	for dec.More() {
+		ev := &ArchivedEvent{}
+		if err := dec.Decode(ev); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+func writeArchive(f *os.File, events []*ArchivedEvent) error {
+	buf := bufio.NewWriter(f)
+	w := gzip.NewWriter(buf)
+	enc := json.NewEncoder(w)
+	for _, v := range events {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
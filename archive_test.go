@@ -0,0 +1,61 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"encoding/json"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&FileArchiveSuite{})
+
+type FileArchiveSuite struct {
+	archive *FileArchive
+}
+
+func (s *FileArchiveSuite) SetUpTest(c *C) {
+	var err error
+	s.archive, err = NewFileArchive(c.MkDir())
+	c.Assert(err, IsNil)
+}
+
+func (s *FileArchiveSuite) TestArchiveAndRead(c *C) {
+	events := []*ArchivedEvent{
+		{StreamName: "order-1", EventType: "SomeEvent", EventNumber: 0, Data: json.RawMessage(`{"Item":"a"}`)},
+		{StreamName: "order-1", EventType: "SomeEvent", EventNumber: 1, Data: json.RawMessage(`{"Item":"b"}`),
+			MetaData: json.RawMessage(`{"AggregateID":"1"}`)},
+	}
+
+	c.Assert(s.archive.Archive("order-1", events), IsNil)
+
+	files, err := s.archive.Files("order-1")
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 1)
+
+	got, err := ReadArchive(files[0])
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, events)
+}
+
+func (s *FileArchiveSuite) TestEachDeletionIsArchivedToANewFile(c *C) {
+	now := time.Unix(0, 0)
+	s.archive.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	s.archive.Archive("order-1", nil)
+	s.archive.Archive("order-1", nil)
+	s.archive.Archive("order-2", nil)
+
+	files, _ := s.archive.Files("order-1")
+	c.Assert(files, HasLen, 2)
+	got, err := ReadArchive(files[0])
+	c.Assert(err, IsNil)
+	c.Assert(got, HasLen, 0)
+}
@@ -0,0 +1,247 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BlobReference identifies a payload held in a BlobStore.
+//
+// SHA256 is the hex encoded hash of the payload and is used to detect a
+// payload that has been changed in the blob store.
+type BlobReference struct {
+	ID         string
+	StreamName string
+	SHA256     string
+	Size       int
+}
+
+// BlobStore is the interface that a store of oversized event payloads must
+// implement.
+//
+// Blobs are grouped by the stream of the event they belong to so that the
+// blobs of a stream can be checked against the events that refer to them.
+type BlobStore interface {
+
+	// Put stores a payload for an event of the stream specified and returns
+	// a reference to it.
+	Put(streamName string, data []byte) (*BlobReference, error)
+
+	// Get returns the payload referenced.
+	Get(*BlobReference) ([]byte, error)
+
+	// Streams returns the names of the streams that have blobs.
+	Streams() ([]string, error)
+
+	// Blobs returns the blobs of the stream specified.
+	Blobs(streamName string) ([]*StoredBlob, error)
+
+	// DeleteBlob deletes the blob of the stream specified with the id
+	// specified.
+	DeleteBlob(streamName, id string) error
+}
+
+// StoredBlob describes a blob held in a BlobStore.
+type StoredBlob struct {
+	ID       string
+	StoredAt time.Time
+}
+
+// BlobReferrer is implemented by stores of events, such as a repository or
+// an Archive, that can list the blobs that the events of a stream refer to.
+type BlobReferrer interface {
+	ReferencedBlobs(streamName string) ([]string, error)
+}
+
+// FileBlobStore is a BlobStore that holds each blob in a file in a directory
+// per stream.
+type FileBlobStore struct {
+	dir string
+}
+
+// NewFileBlobStore constructs a new FileBlobStore that holds blobs in the
+// directory specified. The directory is created if it does not exist.
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &FileBlobStore{
+		dir: dir,
+	}, nil
+}
+
+// Put writes a payload to a new file in the directory of the stream.
+func (s *FileBlobStore) Put(streamName string, data []byte) (*BlobReference, error) {
+	dir := s.streamDir(streamName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	ref := &BlobReference{
+		ID:         NewUUID(),
+		StreamName: streamName,
+		SHA256:     blobHash(data),
+		Size:       len(data),
+	}
+
+	tmp, err := ioutil.TempFile(dir, "tmp-")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, ref.ID)); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return ref, nil
+}
+
+// Get reads the payload referenced.
+func (s *FileBlobStore) Get(ref *BlobReference) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(s.streamDir(ref.StreamName), filepath.Base(ref.ID)))
+}
+
+// Streams returns the names of the streams that have blobs.
+func (s *FileBlobStore) Streams() ([]string, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var streams []string
+	for _, v := range infos {
+		if !v.IsDir() {
+			continue
+		}
+		name, err := hex.DecodeString(v.Name())
+		if err != nil {
+			continue
+		}
+		streams = append(streams, string(name))
+	}
+	return streams, nil
+}
+
+// Blobs returns the blobs of a stream. The time at which a blob was stored is
+// the time its file was written.
+func (s *FileBlobStore) Blobs(streamName string) ([]*StoredBlob, error) {
+	infos, err := ioutil.ReadDir(s.streamDir(streamName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var blobs []*StoredBlob
+	for _, v := range infos {
+		if v.IsDir() || strings.HasPrefix(v.Name(), "tmp-") {
+			continue
+		}
+		blobs = append(blobs, &StoredBlob{ID: v.Name(), StoredAt: v.ModTime()})
+	}
+	return blobs, nil
+}
+
+// DeleteBlob removes the file of a blob. The directory of the stream is
+// removed once it holds no blobs.
+func (s *FileBlobStore) DeleteBlob(streamName, id string) error {
+	dir := s.streamDir(streamName)
+	if err := os.Remove(filepath.Join(dir, filepath.Base(id))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Removing the directory fails while it holds other blobs.
+	os.Remove(dir)
+	return nil
+}
+
+// DeleteStream removes the directory of the stream.
+func (s *FileBlobStore) DeleteStream(streamName string) error {
+	return os.RemoveAll(s.streamDir(streamName))
+}
+
+// streamDir returns the directory holding the blobs of a stream. Stream names
+// are hex encoded so that any name is a valid directory name.
+func (s *FileBlobStore) streamDir(streamName string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(streamName)))
+}
+
+// CollectBlobs deletes the blobs that no event refers to and returns the
+// number of blobs deleted.
+//
+// The referrers are asked for the blobs that the events of each stream with
+// blobs refer to. They should include the repository, so that the blobs of
+// live streams are kept, and the Archive, if one is set, so that the blobs of
+// archived events are kept once their stream is deleted. The blobs of
+// streams that have been deleted without being archived, and the blobs of
+// events whose append failed, are deleted.
+//
+// Blobs are written to the blob store before their events are appended to
+// the stream, so blobs stored within the grace period are never deleted. The
+// grace period should be longer than a Save can take, so that a collection
+// running at the same time as a Save does not delete the blobs of events that
+// are still being appended.
+func CollectBlobs(store BlobStore, grace time.Duration, referrers ...BlobReferrer) (int, error) {
+	streams, err := store.Streams()
+	if err != nil {
+		return 0, err
+	}
+
+	collected := 0
+	for _, stream := range streams {
+		// The blobs are listed before the references are read, so a listed
+		// blob whose event is not yet appended was stored within the grace
+		// period.
+		blobs, err := store.Blobs(stream)
+		if err != nil {
+			return collected, err
+		}
+		cutoff := time.Now().Add(-grace)
+
+		live := make(map[string]bool)
+		for _, referrer := range referrers {
+			ids, err := referrer.ReferencedBlobs(stream)
+			if err != nil {
+				return collected, err
+			}
+			for _, id := range ids {
+				live[id] = true
+			}
+		}
+
+		for _, blob := range blobs {
+			if live[blob.ID] || blob.StoredAt.After(cutoff) {
+				continue
+			}
+			if err := store.DeleteBlob(stream, blob.ID); err != nil {
+				return collected, err
+			}
+			collected++
+		}
+	}
+	return collected, nil
+}
+
+func blobHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,179 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&FileBlobStoreSuite{})
+
+type FileBlobStoreSuite struct {
+	store *FileBlobStore
+}
+
+func (s *FileBlobStoreSuite) SetUpTest(c *C) {
+	var err error
+	s.store, err = NewFileBlobStore(c.MkDir())
+	c.Assert(err, IsNil)
+}
+
+func (s *FileBlobStoreSuite) TestPutAndGet(c *C) {
+	ref, err := s.store.Put("order-1", []byte("some data"))
+	c.Assert(err, IsNil)
+	c.Assert(ref.StreamName, Equals, "order-1")
+	c.Assert(ref.Size, Equals, 9)
+	c.Assert(ref.SHA256, Equals, blobHash([]byte("some data")))
+
+	got, err := s.store.Get(ref)
+
+	c.Assert(err, IsNil)
+	c.Assert(string(got), Equals, "some data")
+}
+
+func (s *FileBlobStoreSuite) TestStreamsAndDeleteStream(c *C) {
+	ref, _ := s.store.Put("order-1", []byte("a"))
+	s.store.Put("order/2", []byte("b"))
+
+	streams, err := s.store.Streams()
+	c.Assert(err, IsNil)
+	c.Assert(streams, HasLen, 2)
+
+	c.Assert(s.store.DeleteStream("order-1"), IsNil)
+
+	streams, _ = s.store.Streams()
+	c.Assert(streams, DeepEquals, []string{"order/2"})
+	_, err = s.store.Get(ref)
+	c.Assert(err, NotNil)
+}
+
+func (s *FileBlobStoreSuite) TestBlobsAndDeleteBlob(c *C) {
+	first, _ := s.store.Put("order-1", []byte("a"))
+	second, _ := s.store.Put("order-1", []byte("b"))
+
+	blobs, err := s.store.Blobs("order-1")
+	c.Assert(err, IsNil)
+	c.Assert(blobs, HasLen, 2)
+
+	c.Assert(s.store.DeleteBlob("order-1", first.ID), IsNil)
+	blobs, _ = s.store.Blobs("order-1")
+	c.Assert(blobs, HasLen, 1)
+	c.Assert(blobs[0].ID, Equals, second.ID)
+
+	c.Assert(s.store.DeleteBlob("order-1", second.ID), IsNil)
+	streams, _ := s.store.Streams()
+	c.Assert(streams, HasLen, 0)
+}
+
+func (s *FileBlobStoreSuite) TestCollectBlobsDeletesUnreferencedBlobs(c *C) {
+	live, _ := s.store.Put("order-1", []byte("a"))
+	s.store.Put("order-1", []byte("failed append"))
+	s.store.Put("order-2", []byte("deleted stream"))
+	referrer := NewFakeBlobReferrer()
+	referrer.refer(live)
+
+	n, err := CollectBlobs(s.store, 0, referrer)
+
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+	streams, _ := s.store.Streams()
+	c.Assert(streams, DeepEquals, []string{"order-1"})
+	_, err = s.store.Get(live)
+	c.Assert(err, IsNil)
+}
+
+func (s *FileBlobStoreSuite) TestCollectBlobsKeepsBlobsWithinTheGracePeriod(c *C) {
+	ref, _ := s.store.Put("order-1", []byte("a"))
+
+	n, err := CollectBlobs(s.store, time.Minute, NewFakeBlobReferrer())
+
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+	_, err = s.store.Get(ref)
+	c.Assert(err, IsNil)
+}
+
+func (s *FileBlobStoreSuite) TestCollectBlobsKeepsBlobsOfArchivedEvents(c *C) {
+	ref, _ := s.store.Put("order-1", []byte("a"))
+	archive, _ := NewFileArchive(c.MkDir())
+	c.Assert(archive.Archive("order-1", []*ArchivedEvent{{
+		StreamName: "order-1",
+		EventType:  "SomeEvent",
+		Data:       []byte(`{}`),
+		MetaData:   []byte(`{"ClaimCheck":"` + ref.ID + `"}`),
+	}}), IsNil)
+
+	n, err := CollectBlobs(s.store, 0, NewFakeBlobReferrer(), archive)
+
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+	_, err = s.store.Get(ref)
+	c.Assert(err, IsNil)
+}
+
+func (s *FileBlobStoreSuite) TestCollectBlobsDuringASaveKeepsItsBlobs(c *C) {
+	claimCheck, _ := NewClaimCheck(s.store, 0)
+	referrer := NewFakeBlobReferrer()
+
+	// Each save stores the blob of its event and appends the event to the
+	// stream a little later, while blobs are collected.
+	var refs []*BlobReference
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			ref, err := claimCheck.Check(fmt.Sprintf("order-%d", i), []byte("some data"))
+			if err != nil {
+				return
+			}
+			time.Sleep(time.Millisecond)
+			referrer.refer(ref)
+			refs = append(refs, ref)
+		}
+	}()
+
+	for collecting := true; collecting; {
+		select {
+		case <-done:
+			collecting = false
+		default:
+		}
+		_, err := CollectBlobs(s.store, time.Minute, referrer)
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(refs, HasLen, 20)
+	for _, ref := range refs {
+		_, err := claimCheck.Claim(ref)
+		c.Assert(err, IsNil)
+	}
+}
+
+// FakeBlobReferrer refers to the blobs it has been given.
+type FakeBlobReferrer struct {
+	mu   sync.Mutex
+	refs map[string][]string
+}
+
+func NewFakeBlobReferrer() *FakeBlobReferrer {
+	return &FakeBlobReferrer{refs: make(map[string][]string)}
+}
+
+func (r *FakeBlobReferrer) refer(ref *BlobReference) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refs[ref.StreamName] = append(r.refs[ref.StreamName], ref.ID)
+}
+
+func (r *FakeBlobReferrer) ReferencedBlobs(streamName string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.refs[streamName], nil
+}
@@ -0,0 +1,280 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CatchUpLoader is implemented by repositories that can apply to an existing
+// aggregate instance the events appended to its stream since it was loaded.
+type CatchUpLoader interface {
+	CatchUp(AggregateRoot) error
+}
+
+// CacheStats holds statistics for a CachingRepository.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
+}
+
+// CachingRepository is a DomainRepository that keeps recently used aggregates
+// in memory in front of another repository.
+//
+// When a cached aggregate is loaded and the underlying repository implements
+// CatchUpLoader, only the events appended since the aggregate was cached are
+// applied to it. Otherwise the cached aggregate is returned as it is and a
+// stale aggregate is detected by the expected version when it is saved.
+//
+// An aggregate is removed from the cache while it is loaded by a command and
+// is returned to the cache when it is saved. An aggregate that is loaded but
+// not saved is simply not returned to the cache.
+//
+// A successful Save passes the aggregate instance to the cache, which hands it
+// to the next command that loads the aggregate. The instance must not be used
+// after it has been saved; a command that needs the aggregate again should
+// load it. Provided this is so, concurrent commands never share an aggregate
+// instance.
+//
+// Save does not change the version of the aggregate, as with the underlying
+// repository. The version of a cached aggregate is advanced by the number of
+// events saved when it is next loaded. An aggregate saved without an expected
+// version is not cached, since events appended by other writers at the same
+// time would make the advanced version wrong.
+//
+// The cache holds at most size aggregates and evicts the least recently used
+// aggregate when full. Aggregates older than the ttl are evicted when they
+// are next loaded. A ttl of zero means that aggregates do not expire.
+type CachingRepository struct {
+	mu      sync.Mutex
+	repo    DomainRepository
+	size    int
+	ttl     time.Duration
+	now     func() time.Time
+	lru     *list.List
+	entries map[string]*list.Element
+	stats   CacheStats
+}
+
+type cacheEntry struct {
+	key       string
+	aggregate AggregateRoot
+	saved     int
+	cached    time.Time
+}
+
+// NewCachingRepository constructs a new CachingRepository in front of the
+// repository specified.
+func NewCachingRepository(repo DomainRepository, size int, ttl time.Duration) (*CachingRepository, error) {
+	if repo == nil {
+		return nil, fmt.Errorf("Nil DomainRepository injected into caching repository.")
+	}
+
+	if size <= 0 {
+		return nil, fmt.Errorf("The size of the aggregate cache must be greater than zero.")
+	}
+
+	return &CachingRepository{
+		repo:    repo,
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}, nil
+}
+
+// Load returns the aggregate from the cache, if present, brought up to date
+// with its stream. Otherwise the aggregate is loaded from the underlying
+// repository.
+func (r *CachingRepository) Load(aggregateType, id string) (AggregateRoot, error) {
+	aggregate := r.take(cacheKey(aggregateType, id))
+	if aggregate == nil {
+		return r.repo.Load(aggregateType, id)
+	}
+
+	loader, ok := r.repo.(CatchUpLoader)
+	if !ok {
+		return aggregate, nil
+	}
+
+	switch err := loader.CatchUp(aggregate).(type) {
+	case nil:
+		return aggregate, nil
+	case *ErrAggregateNotFound:
+		return nil, err
+	default:
+		return r.repo.Load(aggregateType, id)
+	}
+}
+
+// Save saves the aggregate with the underlying repository and, if the save
+// succeeds, adds the aggregate to the cache.
+//
+// If the save fails, or the aggregate is saved without an expected version,
+// the aggregate is not cached. In particular an aggregate that fails with an
+// *ErrConcurrencyViolation is evicted so that it is loaded again from the
+// store.
+func (r *CachingRepository) Save(aggregate AggregateRoot, expectedVersion *int) error {
+	changes := len(aggregate.GetChanges())
+
+	if err := r.repo.Save(aggregate, expectedVersion); err != nil {
+		r.Evict(typeOf(aggregate), aggregate.AggregateID())
+		return err
+	}
+
+	r.put(aggregate, expectedVersion, changes)
+	return nil
+}
+
+// SaveAll saves several aggregates with the underlying repository and, if the
+// save succeeds, adds those saved with an expected version to the cache.
+func (r *CachingRepository) SaveAll(aggregates []AggregateRoot, expectedVersions []*int) error {
+	changes := make([]int, len(aggregates))
+	for k, v := range aggregates {
+		changes[k] = len(v.GetChanges())
+	}
+
+	if err := saveAll(r.repo, aggregates, expectedVersions); err != nil {
+		for _, v := range aggregates {
+			r.Evict(typeOf(v), v.AggregateID())
+		}
+		return err
+	}
+
+	for k, v := range aggregates {
+		r.put(v, expectedVersions[k], changes[k])
+	}
+	return nil
+}
+
+// Delete evicts an aggregate from the cache and soft deletes its stream with
+// the underlying repository.
+func (r *CachingRepository) Delete(aggregateType, id string) error {
+	deleter, err := r.deleter()
+	if err != nil {
+		return err
+	}
+	r.Evict(aggregateType, id)
+	return deleter.Delete(aggregateType, id)
+}
+
+// Tombstone evicts an aggregate from the cache and hard deletes its stream
+// with the underlying repository.
+func (r *CachingRepository) Tombstone(aggregateType, id string) error {
+	deleter, err := r.deleter()
+	if err != nil {
+		return err
+	}
+	r.Evict(aggregateType, id)
+	return deleter.Tombstone(aggregateType, id)
+}
+
+func (r *CachingRepository) deleter() (AggregateDeleter, error) {
+	deleter, ok := r.repo.(AggregateDeleter)
+	if !ok {
+		return nil, fmt.Errorf("The underlying repository does not support deleting aggregates.")
+	}
+	return deleter, nil
+}
+
+// Evict removes an aggregate from the cache.
+func (r *CachingRepository) Evict(aggregateType, id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.entries[cacheKey(aggregateType, id)]; ok {
+		r.remove(e)
+		r.stats.Evictions++
+	}
+}
+
+// Stats returns the hit, miss and eviction counts of the cache and the number
+// of aggregates it currently holds.
+func (r *CachingRepository) Stats() CacheStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.stats
+	stats.Size = r.lru.Len()
+	return stats
+}
+
+// take removes an aggregate from the cache and returns it, or returns nil if
+// the aggregate is not cached or has expired.
+func (r *CachingRepository) take(key string) AggregateRoot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[key]
+	if !ok {
+		r.stats.Misses++
+		return nil
+	}
+
+	r.remove(e)
+	entry := e.Value.(*cacheEntry)
+	if r.ttl > 0 && r.now().Sub(entry.cached) > r.ttl {
+		r.stats.Evictions++
+		r.stats.Misses++
+		return nil
+	}
+
+	// Saving clears the changes of an aggregate without changing its
+	// version so the version is advanced by the number of changes that were
+	// saved.
+	for i := 0; i < entry.saved; i++ {
+		entry.aggregate.IncrementVersion()
+	}
+
+	r.stats.Hits++
+	return entry.aggregate
+}
+
+// put adds a saved aggregate to the cache with the number of changes that
+// were saved. An aggregate saved without an expected version is evicted
+// instead, since its version in the store is not known.
+func (r *CachingRepository) put(aggregate AggregateRoot, expectedVersion *int, changes int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := cacheKey(typeOf(aggregate), aggregate.AggregateID())
+	if e, ok := r.entries[key]; ok {
+		r.remove(e)
+	}
+	if expectedVersion == nil || aggregate.OriginalVersion()+changes < 0 {
+		return
+	}
+
+	r.entries[key] = r.lru.PushFront(&cacheEntry{
+		key:       key,
+		aggregate: aggregate,
+		saved:     changes,
+		cached:    r.now(),
+	})
+
+	for r.lru.Len() > r.size {
+		r.remove(r.lru.Back())
+		r.stats.Evictions++
+	}
+}
+
+// cacheKey returns the key of an aggregate in the cache. Former names of the
+// aggregate type are resolved so that an aggregate loaded by any of its names
+// is found.
+func cacheKey(aggregateType, id string) string {
+	return streamKey(ResolveTypeName(aggregateType), id)
+}
+
+func (r *CachingRepository) remove(e *list.Element) {
+	r.lru.Remove(e)
+	delete(r.entries, e.Value.(*cacheEntry).key)
+}
@@ -0,0 +1,221 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&CachingRepositorySuite{})
+
+type CachingRepositorySuite struct {
+	inner *CountingRepository
+	repo  *CachingRepository
+}
+
+func (s *CachingRepositorySuite) SetUpTest(c *C) {
+	memoryRepo, _ := NewInMemoryRepository(NewInternalEventBus())
+	aggregateFactory := NewDelegateAggregateFactory()
+	aggregateFactory.RegisterDelegate(&SomeAggregate{},
+		func(id string) AggregateRoot { return NewSomeAggregate(id) })
+	memoryRepo.SetAggregateFactory(aggregateFactory)
+
+	s.inner = &CountingRepository{InMemoryRepository: memoryRepo}
+	s.repo, _ = NewCachingRepository(s.inner, 2, time.Minute)
+}
+
+func (s *CachingRepositorySuite) create(c *C) AggregateRoot {
+	agg := NewSomeAggregate(NewUUID())
+	agg.TrackChange(NewTestEventMessage(agg.AggregateID()))
+	c.Assert(s.repo.Save(agg, Int(agg.OriginalVersion())), IsNil)
+	return agg
+}
+
+func (s *CachingRepositorySuite) TestNewCachingRepositoryValidatesArguments(c *C) {
+	_, err := NewCachingRepository(nil, 1, 0)
+	c.Assert(err, ErrorMatches, "Nil DomainRepository injected into caching repository.")
+
+	_, err = NewCachingRepository(s.inner, 0, 0)
+	c.Assert(err, ErrorMatches, "The size of the aggregate cache must be greater than zero.")
+}
+
+func (s *CachingRepositorySuite) TestSavedAggregateIsServedFromCache(c *C) {
+	agg := s.create(c)
+
+	got, err := s.repo.Load(typeOf(agg), agg.AggregateID())
+
+	c.Assert(err, IsNil)
+	c.Assert(got, Equals, agg)
+	c.Assert(got.OriginalVersion(), Equals, 0)
+	c.Assert(s.inner.loads, Equals, 0)
+	c.Assert(s.repo.Stats(), DeepEquals, CacheStats{Hits: 1, Size: 0})
+}
+
+func (s *CachingRepositorySuite) TestAggregateSavedWithoutExpectedVersionIsNotCached(c *C) {
+	agg := s.create(c)
+	got, _ := s.repo.Load(typeOf(agg), agg.AggregateID())
+	got.TrackChange(NewTestEventMessage(agg.AggregateID()))
+
+	c.Assert(s.repo.Save(got, nil), IsNil)
+
+	c.Assert(s.repo.Stats().Size, Equals, 0)
+	loaded, err := s.repo.Load(typeOf(agg), agg.AggregateID())
+	c.Assert(err, IsNil)
+	c.Assert(loaded, Not(Equals), got)
+	c.Assert(s.inner.loads, Equals, 1)
+}
+
+func (s *CachingRepositorySuite) TestAggregateLoadedByAFormerTypeNameIsServedFromCache(c *C) {
+	c.Assert(RegisterTypeAlias("SomeOldAggregate", "SomeAggregate"), IsNil)
+	defer func() {
+		typeNames.Lock()
+		delete(typeNames.aliases, "SomeOldAggregate")
+		typeNames.Unlock()
+	}()
+	agg := s.create(c)
+
+	got, err := s.repo.Load("SomeOldAggregate", agg.AggregateID())
+
+	c.Assert(err, IsNil)
+	c.Assert(got, Equals, agg)
+	c.Assert(s.inner.loads, Equals, 0)
+}
+
+func (s *CachingRepositorySuite) TestSaveDoesNotChangeTheVersion(c *C) {
+	agg := NewSomeAggregate(NewUUID())
+	agg.TrackChange(NewTestEventMessage(agg.AggregateID()))
+	agg.TrackChange(NewTestEventMessage(agg.AggregateID()))
+
+	c.Assert(s.repo.Save(agg, Int(agg.OriginalVersion())), IsNil)
+
+	c.Assert(agg.OriginalVersion(), Equals, -1)
+	got, err := s.repo.Load(typeOf(agg), agg.AggregateID())
+	c.Assert(err, IsNil)
+	c.Assert(got.OriginalVersion(), Equals, 1)
+}
+
+func (s *CachingRepositorySuite) TestCachedAggregateCatchesUpWithNewEvents(c *C) {
+	agg := s.create(c)
+
+	// Another process appends to the stream.
+	other, _ := s.inner.InMemoryRepository.Load(typeOf(agg), agg.AggregateID())
+	other.TrackChange(NewTestEventMessage(agg.AggregateID()))
+	c.Assert(s.inner.InMemoryRepository.Save(other, Int(0)), IsNil)
+
+	got, err := s.repo.Load(typeOf(agg), agg.AggregateID())
+
+	c.Assert(err, IsNil)
+	c.Assert(got, Equals, agg)
+	c.Assert(got.OriginalVersion(), Equals, 1)
+	c.Assert(got.(*SomeAggregate).events, HasLen, 1)
+	c.Assert(s.inner.loads, Equals, 0)
+}
+
+func (s *CachingRepositorySuite) TestLoadedAggregateIsNotSharedUntilSaved(c *C) {
+	agg := s.create(c)
+
+	first, _ := s.repo.Load(typeOf(agg), agg.AggregateID())
+	second, _ := s.repo.Load(typeOf(agg), agg.AggregateID())
+
+	c.Assert(first, Equals, agg)
+	c.Assert(second, Not(Equals), agg)
+	c.Assert(s.repo.Stats().Hits, Equals, uint64(1))
+	c.Assert(s.repo.Stats().Misses, Equals, uint64(1))
+}
+
+func (s *CachingRepositorySuite) TestConcurrencyViolationEvictsAggregate(c *C) {
+	agg := s.create(c)
+	stale, _ := s.repo.Load(typeOf(agg), agg.AggregateID())
+	c.Assert(s.repo.Save(stale, Int(stale.OriginalVersion())), IsNil)
+
+	stale.TrackChange(NewTestEventMessage(agg.AggregateID()))
+	err := s.repo.Save(stale, Int(-1))
+
+	c.Assert(err, FitsTypeOf, &ErrConcurrencyViolation{})
+	c.Assert(s.repo.Stats().Size, Equals, 0)
+	c.Assert(s.repo.Stats().Evictions, Equals, uint64(1))
+}
+
+func (s *CachingRepositorySuite) TestLeastRecentlyUsedAggregateIsEvicted(c *C) {
+	first := s.create(c)
+	s.create(c)
+	s.create(c)
+
+	c.Assert(s.repo.Stats().Size, Equals, 2)
+	c.Assert(s.repo.Stats().Evictions, Equals, uint64(1))
+
+	_, err := s.repo.Load(typeOf(first), first.AggregateID())
+	c.Assert(err, IsNil)
+	c.Assert(s.inner.loads, Equals, 1)
+}
+
+func (s *CachingRepositorySuite) TestExpiredAggregateIsLoadedFromRepository(c *C) {
+	now := time.Now()
+	s.repo.now = func() time.Time { return now }
+	agg := s.create(c)
+
+	now = now.Add(2 * time.Minute)
+	got, err := s.repo.Load(typeOf(agg), agg.AggregateID())
+
+	c.Assert(err, IsNil)
+	c.Assert(got, Not(Equals), agg)
+	c.Assert(s.inner.loads, Equals, 1)
+	c.Assert(s.repo.Stats(), DeepEquals, CacheStats{Misses: 1, Evictions: 1})
+}
+
+func (s *CachingRepositorySuite) TestConcurrentCommandsAreSafe(c *C) {
+	agg := s.create(c)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := s.repo.Load(typeOf(agg), agg.AggregateID())
+			if err != nil {
+				return
+			}
+			got.TrackChange(NewTestEventMessage(agg.AggregateID()))
+			s.repo.Save(got, Int(got.OriginalVersion()))
+		}()
+	}
+	wg.Wait()
+
+	got, err := s.repo.Load(typeOf(agg), agg.AggregateID())
+	c.Assert(err, IsNil)
+	c.Assert(got.GetChanges(), HasLen, 0)
+	stats := s.repo.Stats()
+	c.Assert(stats.Hits+stats.Misses, Equals, uint64(21))
+}
+
+// Fakes
+
+type CountingRepository struct {
+	*InMemoryRepository
+	mu    sync.Mutex
+	loads int
+}
+
+func (r *CountingRepository) Load(aggregateType, id string) (AggregateRoot, error) {
+	r.mu.Lock()
+	r.loads++
+	r.mu.Unlock()
+	return r.InMemoryRepository.Load(aggregateType, id)
+}
+
+func (s *CachingRepositorySuite) TestDeleteEvictsTheAggregate(c *C) {
+	agg := s.create(c)
+	c.Assert(s.repo.Stats().Size, Equals, 1)
+
+	c.Assert(s.repo.Delete(typeOf(agg), agg.AggregateID()), IsNil)
+
+	c.Assert(s.repo.Stats().Size, Equals, 0)
+	_, err := s.repo.Load(typeOf(agg), agg.AggregateID())
+	c.Assert(err, DeepEquals, &ErrAggregateDeleted{AggregateType: typeOf(agg), AggregateID: agg.AggregateID()})
+}
@@ -0,0 +1,250 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CheckpointStore is the interface that a store of projection checkpoints
+// must implement.
+//
+// A checkpoint is the position in an EventFeed of the next event that a
+// projection has to handle.
+type CheckpointStore interface {
+
+	// LoadCheckpoint returns the checkpoint of the projection specified, or
+	// 0 if there is none.
+	LoadCheckpoint(projection string) (int, error)
+
+	// SaveCheckpoint replaces the checkpoint of the projection specified.
+	SaveCheckpoint(projection string, position int) error
+}
+
+// MemoryCheckpointStore is an in process implementation of the
+// CheckpointStore interface.
+//
+// Checkpoints do not survive a restart of the process so the
+// MemoryCheckpointStore is mostly useful for testing.
+type MemoryCheckpointStore struct {
+	mu          sync.RWMutex
+	checkpoints map[string]int
+}
+
+// NewMemoryCheckpointStore constructs a new MemoryCheckpointStore.
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{
+		checkpoints: make(map[string]int),
+	}
+}
+
+// LoadCheckpoint returns the checkpoint of a projection.
+func (s *MemoryCheckpointStore) LoadCheckpoint(projection string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.checkpoints[projection], nil
+}
+
+// SaveCheckpoint replaces the checkpoint of a projection.
+func (s *MemoryCheckpointStore) SaveCheckpoint(projection string, position int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoints[projection] = position
+	return nil
+}
+
+// FileCheckpointStore is an implementation of the CheckpointStore interface
+// that holds the checkpoint of each projection in a JSON file in a directory.
+//
+// Files are written to a temporary file and renamed into place so that a
+// checkpoint is never left partially written.
+type FileCheckpointStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+type fileCheckpoint struct {
+	Projection string
+	Position   int
+}
+
+// NewFileCheckpointStore constructs a new FileCheckpointStore that keeps its
+// checkpoints in the directory specified. The directory is created if it does
+// not exist.
+func NewFileCheckpointStore(dir string) (*FileCheckpointStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileCheckpointStore{
+		dir: dir,
+	}, nil
+}
+
+// LoadCheckpoint returns the checkpoint of a projection.
+func (s *FileCheckpointStore) LoadCheckpoint(projection string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := ioutil.ReadFile(s.path(projection))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var checkpoint fileCheckpoint
+	if err := json.Unmarshal(b, &checkpoint); err != nil {
+		return 0, fmt.Errorf("The checkpoint of projection %s can not be read. %s", projection, err)
+	}
+	return checkpoint.Position, nil
+}
+
+// SaveCheckpoint replaces the checkpoint of a projection.
+func (s *FileCheckpointStore) SaveCheckpoint(projection string, position int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := json.Marshal(&fileCheckpoint{Projection: projection, Position: position})
+	if err != nil {
+		return err
+	}
+
+	path := s.path(projection)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// path returns the path of the file of a projection. Names are escaped so
+// that any projection name can be used.
+func (s *FileCheckpointStore) path(projection string) string {
+	return filepath.Join(s.dir, url.QueryEscape(projection)+".json")
+}
+
+// PlaceholderStyle is the style of the placeholders for parameters in the SQL
+// statements of a SQLCheckpointStore or SQLOutbox, which depends on the
+// database driver.
+type PlaceholderStyle int
+
+const (
+	// QuestionPlaceholders are placeholders of the form ?, used by MySQL and
+	// SQLite drivers. This is the default.
+	QuestionPlaceholders PlaceholderStyle = iota
+
+	// DollarPlaceholders are placeholders of the form $1, used by PostgreSQL
+	// drivers.
+	DollarPlaceholders
+)
+
+// SQLCheckpointStore is an implementation of the CheckpointStore interface
+// that holds checkpoints in a table of a SQL database.
+//
+// The table has a projection column holding the name of the projection and a
+// position column holding its checkpoint, and can be created with
+// CreateTable. Where the read model of a projection is held in the same
+// database, its checkpoint is durable exactly when its changes are.
+type SQLCheckpointStore struct {
+	db           *sql.DB
+	table        string
+	placeholders PlaceholderStyle
+}
+
+// NewSQLCheckpointStore constructs a new SQLCheckpointStore that keeps its
+// checkpoints in the table specified.
+func NewSQLCheckpointStore(db *sql.DB, table string) (*SQLCheckpointStore, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Nil database injected into checkpoint store.")
+	}
+	if table == "" {
+		return nil, fmt.Errorf("The checkpoint store has no table name.")
+	}
+	return &SQLCheckpointStore{
+		db:    db,
+		table: table,
+	}, nil
+}
+
+// SetPlaceholderStyle sets the style of the placeholders for parameters used
+// by the driver of the database.
+func (s *SQLCheckpointStore) SetPlaceholderStyle(style PlaceholderStyle) {
+	s.placeholders = style
+}
+
+// CreateTable creates the table of checkpoints if it does not exist.
+func (s *SQLCheckpointStore) CreateTable() error {
+	_, err := s.db.Exec(fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (projection VARCHAR(255) NOT NULL PRIMARY KEY, position BIGINT NOT NULL)",
+		s.table))
+	return err
+}
+
+// LoadCheckpoint returns the checkpoint of a projection.
+func (s *SQLCheckpointStore) LoadCheckpoint(projection string) (int, error) {
+	var position int64
+	err := s.db.QueryRow(fmt.Sprintf("SELECT position FROM %s WHERE projection = %s",
+		s.table, s.placeholder(1)), projection).Scan(&position)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int(position), nil
+}
+
+// SaveCheckpoint replaces the checkpoint of a projection.
+//
+// The checkpoint is read in a transaction and then updated, or inserted if
+// there is none. Whether there is a checkpoint is not judged by the rows
+// affected by the update, since some databases, such as MySQL, report no rows
+// affected when the position is unchanged.
+func (s *SQLCheckpointStore) SaveCheckpoint(projection string, position int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var current int64
+	err = tx.QueryRow(fmt.Sprintf("SELECT position FROM %s WHERE projection = %s",
+		s.table, s.placeholder(1)), projection).Scan(&current)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (projection, position) VALUES (%s, %s)",
+			s.table, s.placeholder(1), s.placeholder(2)), projection, int64(position))
+	case err == nil:
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET position = %s WHERE projection = %s",
+			s.table, s.placeholder(1), s.placeholder(2)), int64(position), projection)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// placeholder returns the placeholder of the nth parameter of a statement.
+func (s *SQLCheckpointStore) placeholder(n int) string {
+	return s.placeholders.placeholder(n)
+}
+
+// placeholder returns the placeholder of the nth parameter of a statement in
+// the style.
+func (p PlaceholderStyle) placeholder(n int) string {
+	if p == DollarPlaceholders {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
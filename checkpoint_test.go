@@ -0,0 +1,228 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&CheckpointSuite{})
+
+type CheckpointSuite struct{}
+
+func (s *CheckpointSuite) checkStore(c *C, store CheckpointStore) {
+	position, err := store.LoadCheckpoint("some-projection")
+	c.Assert(err, IsNil)
+	c.Assert(position, Equals, 0)
+
+	c.Assert(store.SaveCheckpoint("some-projection", 5), IsNil)
+	c.Assert(store.SaveCheckpoint("some-other-projection", 2), IsNil)
+	c.Assert(store.SaveCheckpoint("some-projection", 9), IsNil)
+
+	position, err = store.LoadCheckpoint("some-projection")
+	c.Assert(err, IsNil)
+	c.Assert(position, Equals, 9)
+	position, err = store.LoadCheckpoint("some-other-projection")
+	c.Assert(err, IsNil)
+	c.Assert(position, Equals, 2)
+}
+
+func (s *CheckpointSuite) TestMemoryCheckpointStore(c *C) {
+	s.checkStore(c, NewMemoryCheckpointStore())
+}
+
+func (s *CheckpointSuite) TestFileCheckpointStore(c *C) {
+	store, err := NewFileCheckpointStore(c.MkDir())
+	c.Assert(err, IsNil)
+
+	s.checkStore(c, store)
+}
+
+func (s *CheckpointSuite) TestFileCheckpointsSurviveANewStore(c *C) {
+	dir := c.MkDir()
+	store, _ := NewFileCheckpointStore(dir)
+	c.Assert(store.SaveCheckpoint("inventory/list", 7), IsNil)
+
+	store, _ = NewFileCheckpointStore(dir)
+	position, err := store.LoadCheckpoint("inventory/list")
+
+	c.Assert(err, IsNil)
+	c.Assert(position, Equals, 7)
+	files, _ := ioutil.ReadDir(dir)
+	c.Assert(files, HasLen, 1)
+	c.Assert(files[0].Name(), Equals, "inventory%2Flist.json")
+}
+
+func (s *CheckpointSuite) TestCorruptFileCheckpointsReturnAnError(c *C) {
+	dir := c.MkDir()
+	store, _ := NewFileCheckpointStore(dir)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "some-projection.json"), []byte("{"), 0644), IsNil)
+
+	_, err := store.LoadCheckpoint("some-projection")
+
+	c.Assert(err, ErrorMatches, "The checkpoint of projection some-projection can not be read. .*")
+}
+
+func (s *CheckpointSuite) TestSQLCheckpointStore(c *C) {
+	db, _ := openFakeCheckpointDB(c)
+	store, err := NewSQLCheckpointStore(db, "checkpoints")
+	c.Assert(err, IsNil)
+	c.Assert(store.CreateTable(), IsNil)
+
+	s.checkStore(c, store)
+}
+
+func (s *CheckpointSuite) TestSQLCheckpointStoreUsesThePlaceholderStyle(c *C) {
+	db, conn := openFakeCheckpointDB(c)
+	store, _ := NewSQLCheckpointStore(db, "checkpoints")
+	store.SetPlaceholderStyle(DollarPlaceholders)
+
+	c.Assert(store.SaveCheckpoint("some-projection", 1), IsNil)
+	c.Assert(store.SaveCheckpoint("some-projection", 2), IsNil)
+	_, err := store.LoadCheckpoint("some-projection")
+	c.Assert(err, IsNil)
+
+	c.Assert(conn.queries, DeepEquals, []string{
+		"SELECT position FROM checkpoints WHERE projection = $1",
+		"INSERT INTO checkpoints (projection, position) VALUES ($1, $2)",
+		"SELECT position FROM checkpoints WHERE projection = $1",
+		"UPDATE checkpoints SET position = $1 WHERE projection = $2",
+		"SELECT position FROM checkpoints WHERE projection = $1",
+	})
+}
+
+func (s *CheckpointSuite) TestSQLCheckpointStoreSavesAnUnchangedCheckpoint(c *C) {
+	db, _ := openFakeCheckpointDB(c)
+	store, _ := NewSQLCheckpointStore(db, "checkpoints")
+	c.Assert(store.SaveCheckpoint("some-projection", 3), IsNil)
+
+	err := store.SaveCheckpoint("some-projection", 3)
+
+	c.Assert(err, IsNil)
+	position, _ := store.LoadCheckpoint("some-projection")
+	c.Assert(position, Equals, 3)
+}
+
+func (s *CheckpointSuite) TestSQLCheckpointStoreRequiresADatabaseAndTable(c *C) {
+	_, err := NewSQLCheckpointStore(nil, "checkpoints")
+	c.Assert(err, ErrorMatches, "Nil database injected into checkpoint store.")
+
+	db, _ := openFakeCheckpointDB(c)
+	_, err = NewSQLCheckpointStore(db, "")
+	c.Assert(err, ErrorMatches, "The checkpoint store has no table name.")
+}
+
+// fakeCheckpointDriver is a database/sql driver that understands only the
+// statements of the SQLCheckpointStore. Like MySQL, it reports no rows
+// affected by an update that leaves a row unchanged.
+type fakeCheckpointDriver struct {
+	mu    sync.Mutex
+	conns map[string]*fakeCheckpointConn
+}
+
+var checkpointDriver = &fakeCheckpointDriver{conns: make(map[string]*fakeCheckpointConn)}
+
+func init() {
+	sql.Register("fakecheckpoints", checkpointDriver)
+}
+
+func openFakeCheckpointDB(c *C) (*sql.DB, *fakeCheckpointConn) {
+	name := NewUUID()
+	conn := &fakeCheckpointConn{rows: make(map[string]int64)}
+	checkpointDriver.mu.Lock()
+	checkpointDriver.conns[name] = conn
+	checkpointDriver.mu.Unlock()
+
+	db, err := sql.Open("fakecheckpoints", name)
+	c.Assert(err, IsNil)
+	db.SetMaxOpenConns(1)
+	return db, conn
+}
+
+func (d *fakeCheckpointDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.conns[name], nil
+}
+
+type fakeCheckpointConn struct {
+	queries []string
+	rows    map[string]int64
+}
+
+func (c *fakeCheckpointConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeCheckpointStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeCheckpointConn) Close() error              { return nil }
+func (c *fakeCheckpointConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *fakeCheckpointConn) Commit() error             { return nil }
+func (c *fakeCheckpointConn) Rollback() error           { return nil }
+
+type fakeCheckpointStmt struct {
+	conn  *fakeCheckpointConn
+	query string
+}
+
+func (s *fakeCheckpointStmt) Close() error  { return nil }
+func (s *fakeCheckpointStmt) NumInput() int { return -1 }
+
+func (s *fakeCheckpointStmt) Exec(args []driver.Value) (driver.Result, error) {
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE"):
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(s.query, "UPDATE"):
+		s.conn.queries = append(s.conn.queries, s.query)
+		name := args[1].(string)
+		if position, ok := s.conn.rows[name]; !ok || position == args[0].(int64) {
+			return driver.RowsAffected(0), nil
+		}
+		s.conn.rows[name] = args[0].(int64)
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(s.query, "INSERT"):
+		s.conn.queries = append(s.conn.queries, s.query)
+		if _, ok := s.conn.rows[args[0].(string)]; ok {
+			return nil, fmt.Errorf("Duplicate entry '%s' for key 'PRIMARY'", args[0])
+		}
+		s.conn.rows[args[0].(string)] = args[1].(int64)
+		return driver.RowsAffected(1), nil
+	}
+	return nil, fmt.Errorf("unexpected statement %s", s.query)
+}
+
+func (s *fakeCheckpointStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.conn.queries = append(s.conn.queries, s.query)
+	rows := &fakeCheckpointRows{}
+	if position, ok := s.conn.rows[args[0].(string)]; ok {
+		rows.values = []int64{position}
+	}
+	return rows, nil
+}
+
+type fakeCheckpointRows struct {
+	values []int64
+}
+
+func (r *fakeCheckpointRows) Columns() []string { return []string{"position"} }
+func (r *fakeCheckpointRows) Close() error      { return nil }
+
+func (r *fakeCheckpointRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0] = r.values[0]
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ClaimCheckHeader is the key of the header that holds the id of the blob in
+// which the payload of an event is held.
+//
+// Events persisted with this header have a BlobReference in place of their
+// payload.
+const ClaimCheckHeader = "ClaimCheck"
+
+// ClaimCheck moves event payloads larger than a threshold to a BlobStore,
+// leaving only a reference in the event.
+type ClaimCheck struct {
+	store     BlobStore
+	threshold int
+}
+
+// NewClaimCheck constructs a new ClaimCheck that moves payloads of more than
+// threshold bytes to the blob store specified.
+//
+// The threshold applies to the payload as serialised and compressed. Payloads
+// that are not JSON are base64 encoded in GetEventStore, so the threshold
+// should allow for their size growing by a third.
+func NewClaimCheck(store BlobStore, threshold int) (*ClaimCheck, error) {
+	if store == nil {
+		return nil, fmt.Errorf("Nil BlobStore injected into claim check.")
+	}
+
+	return &ClaimCheck{
+		store:     store,
+		threshold: threshold,
+	}, nil
+}
+
+// Check stores data in the blob store if it is larger than the threshold and
+// returns a reference to it. If data is not larger than the threshold nil is
+// returned.
+func (c *ClaimCheck) Check(streamName string, data []byte) (*BlobReference, error) {
+	if len(data) <= c.threshold {
+		return nil, nil
+	}
+	return c.store.Put(streamName, data)
+}
+
+// Claim returns the payload referenced. If the payload does not match the
+// hash in the reference an *ErrBlobIntegrity is returned.
+func (c *ClaimCheck) Claim(ref *BlobReference) ([]byte, error) {
+	data, err := c.store.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) != ref.Size || blobHash(data) != ref.SHA256 {
+		return nil, &ErrBlobIntegrity{Reference: ref}
+	}
+	return data, nil
+}
+
+// ClaimCheckEventMessage is an EventMessage whose payload is held in a blob
+// store and is only read when Event is first called.
+type ClaimCheckEventMessage struct {
+	*EventDescriptor
+	eventType string
+	resolve   func() (interface{}, error)
+	once      sync.Once
+	err       error
+}
+
+// NewClaimCheckEventMessage returns a new ClaimCheckEventMessage that calls
+// the resolve function to read its payload.
+func NewClaimCheckEventMessage(aggregateID, eventType string, version *int, resolve func() (interface{}, error)) *ClaimCheckEventMessage {
+	return &ClaimCheckEventMessage{
+		EventDescriptor: NewEventMessage(aggregateID, nil, version),
+		eventType:       eventType,
+		resolve:         resolve,
+	}
+}
+
+// EventType returns the current name of the event type, following any
+// aliases, without reading the payload.
+func (m *ClaimCheckEventMessage) EventType() string {
+	return ResolveTypeName(m.eventType)
+}
+
+// Event reads the payload of the event if it has not yet been read and
+// returns it. If the payload can not be read nil is returned and the error is
+// available from Err.
+func (m *ClaimCheckEventMessage) Event() interface{} {
+	m.once.Do(func() {
+		m.event, m.err = m.resolve()
+	})
+	return m.event
+}
+
+// Err returns the error encountered reading the payload, if any.
+func (m *ClaimCheckEventMessage) Err() error {
+	m.Event()
+	return m.err
+}
+
+// payloadErr returns the error reading the payload of a claim checked event,
+// if any.
+func payloadErr(em EventMessage) error {
+	if m, ok := em.(*ClaimCheckEventMessage); ok {
+		return m.Err()
+	}
+	return nil
+}
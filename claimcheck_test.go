@@ -0,0 +1,101 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&ClaimCheckSuite{})
+
+type ClaimCheckSuite struct {
+	store      *FileBlobStore
+	claimCheck *ClaimCheck
+}
+
+func (s *ClaimCheckSuite) SetUpTest(c *C) {
+	s.store, _ = NewFileBlobStore(c.MkDir())
+	s.claimCheck, _ = NewClaimCheck(s.store, 4)
+}
+
+func (s *ClaimCheckSuite) TestNewClaimCheckWithNilBlobStoreReturnsAnError(c *C) {
+	claimCheck, err := NewClaimCheck(nil, 4)
+
+	c.Assert(claimCheck, IsNil)
+	c.Assert(err, ErrorMatches, "Nil BlobStore injected into claim check.")
+}
+
+func (s *ClaimCheckSuite) TestSmallPayloadsAreNotChecked(c *C) {
+	ref, err := s.claimCheck.Check("order-1", []byte("abcd"))
+
+	c.Assert(err, IsNil)
+	c.Assert(ref, IsNil)
+}
+
+func (s *ClaimCheckSuite) TestCheckAndClaim(c *C) {
+	ref, err := s.claimCheck.Check("order-1", []byte("abcde"))
+	c.Assert(err, IsNil)
+	c.Assert(ref, NotNil)
+
+	got, err := s.claimCheck.Claim(ref)
+
+	c.Assert(err, IsNil)
+	c.Assert(string(got), Equals, "abcde")
+}
+
+func (s *ClaimCheckSuite) TestTamperedPayloadReturnsErrBlobIntegrity(c *C) {
+	ref, _ := s.claimCheck.Check("order-1", []byte("abcde"))
+	ioutil.WriteFile(filepath.Join(s.store.streamDir("order-1"), ref.ID), []byte("abcdf"), 0644)
+
+	_, err := s.claimCheck.Claim(ref)
+
+	c.Assert(err, DeepEquals, &ErrBlobIntegrity{Reference: ref})
+}
+
+func (s *ClaimCheckSuite) TestClaimCheckEventMessageResolvesOnce(c *C) {
+	calls := 0
+	em := NewClaimCheckEventMessage("id", "SomeEvent", Int(3), func() (interface{}, error) {
+		calls++
+		return &SomeEvent{Item: "Some Item"}, nil
+	})
+
+	c.Assert(em.EventType(), Equals, "SomeEvent")
+	c.Assert(*em.Version(), Equals, 3)
+	c.Assert(calls, Equals, 0)
+
+	c.Assert(em.Event(), DeepEquals, &SomeEvent{Item: "Some Item"})
+	c.Assert(em.Event(), DeepEquals, &SomeEvent{Item: "Some Item"})
+	c.Assert(em.Err(), IsNil)
+	c.Assert(calls, Equals, 1)
+}
+
+func (s *ClaimCheckSuite) TestClaimCheckEventMessageResolvesTypeAliases(c *C) {
+	c.Assert(RegisterTypeAlias("SomeOldEvent", "SomeEvent"), IsNil)
+	defer func() {
+		typeNames.Lock()
+		delete(typeNames.aliases, "SomeOldEvent")
+		typeNames.Unlock()
+	}()
+
+	em := NewClaimCheckEventMessage("id", "SomeOldEvent", nil, func() (interface{}, error) {
+		return &SomeEvent{Item: "Some Item"}, nil
+	})
+
+	c.Assert(em.EventType(), Equals, "SomeEvent")
+}
+
+func (s *ClaimCheckSuite) TestClaimCheckEventMessageError(c *C) {
+	em := NewClaimCheckEventMessage("id", "SomeEvent", nil, func() (interface{}, error) {
+		return nil, fmt.Errorf("Some error")
+	})
+
+	c.Assert(em.Event(), IsNil)
+	c.Assert(em.Err(), ErrorMatches, "Some error")
+}
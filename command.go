@@ -64,3 +64,13 @@ func (c *CommandDescriptor) SetHeader(key string, value interface{}) {
 func (c *CommandDescriptor) Command() interface{} {
 	return c.command
 }
+
+// CommandID returns the unique id of the command.
+func (c *CommandDescriptor) CommandID() string {
+	return CommandIDOf(c)
+}
+
+// CorrelationID returns the correlation id of the command.
+func (c *CommandDescriptor) CorrelationID() string {
+	return CommandCorrelationIDOf(c)
+}
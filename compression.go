@@ -0,0 +1,186 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+)
+
+// ContentEncodingHeader is the key of the header that records the codec used
+// to compress the body of an event.
+//
+// Events persisted without this header are not compressed.
+const ContentEncodingHeader = "ContentEncoding"
+
+// Compressor is the interface that a compression codec must implement.
+//
+// Gzip and deflate codecs are provided. Other codecs, such as zstd, can be
+// used by wrapping a third party package in a Compressor.
+type Compressor interface {
+
+	// Encoding returns the name recorded in the ContentEncoding header of
+	// events compressed by the codec.
+	Encoding() string
+
+	// Compress returns the compressed form of data.
+	Compress([]byte) ([]byte, error)
+
+	// Decompress returns the data compressed in the input.
+	Decompress([]byte) ([]byte, error)
+}
+
+// GzipCompressor compresses event bodies with gzip.
+type GzipCompressor struct {
+	// Level is the gzip compression level. The zero value is
+	// gzip.DefaultCompression.
+	Level int
+}
+
+// Encoding returns gzip.
+func (g *GzipCompressor) Encoding() string { return "gzip" }
+
+// Compress returns the gzip compressed form of data.
+func (g *GzipCompressor) Compress(data []byte) ([]byte, error) {
+	level := g.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress returns the data compressed with gzip in the input.
+func (g *GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// DeflateCompressor compresses event bodies with raw deflate, which has less
+// overhead than gzip for small bodies.
+type DeflateCompressor struct {
+	// Level is the deflate compression level. The zero value is
+	// flate.DefaultCompression.
+	Level int
+}
+
+// Encoding returns deflate.
+func (d *DeflateCompressor) Encoding() string { return "deflate" }
+
+// Compress returns the deflate compressed form of data.
+func (d *DeflateCompressor) Compress(data []byte) ([]byte, error) {
+	level := d.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress returns the data compressed with deflate in the input.
+func (d *DeflateCompressor) Decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// Compression compresses the serialised bodies of events that are larger than
+// a threshold and decompresses event bodies by their content encoding.
+//
+// Compression works on serialised event bodies and headers only and so can be
+// used by any repository that stores events in serialised form. Events whose
+// body is smaller than the threshold, and events persisted before compression
+// was enabled, are stored and read uncompressed.
+type Compression struct {
+	compressor Compressor
+	threshold  int
+	codecs     map[string]Compressor
+}
+
+// NewCompression constructs a new Compression that compresses event bodies of
+// threshold bytes or more with the compressor specified.
+//
+// The compressor is also registered for decompression, as are gzip and
+// deflate.
+func NewCompression(compressor Compressor, threshold int) (*Compression, error) {
+	if compressor == nil {
+		return nil, fmt.Errorf("Nil Compressor injected into compression.")
+	}
+
+	c := &Compression{
+		compressor: compressor,
+		threshold:  threshold,
+		codecs:     make(map[string]Compressor),
+	}
+	c.Register(&GzipCompressor{})
+	c.Register(&DeflateCompressor{})
+	c.Register(compressor)
+	return c, nil
+}
+
+// Register makes a compressor available for decompressing events of its
+// encoding. This allows events written with a codec that is no longer used
+// for writing to be read.
+func (c *Compression) Register(compressor Compressor) {
+	c.codecs[compressor.Encoding()] = compressor
+}
+
+// Compress compresses data if it is at least as large as the threshold. The
+// encoding returned is empty if data was not compressed.
+func (c *Compression) Compress(data []byte) ([]byte, string, error) {
+	if len(data) < c.threshold {
+		return data, "", nil
+	}
+
+	compressed, err := c.compressor.Compress(data)
+	if err != nil {
+		return nil, "", err
+	}
+	return compressed, c.compressor.Encoding(), nil
+}
+
+// Decompress decompresses data compressed with the encoding specified. Data
+// with an empty encoding is returned unchanged.
+func (c *Compression) Decompress(data []byte, encoding string) ([]byte, error) {
+	if encoding == "" {
+		return data, nil
+	}
+
+	codec, ok := c.codecs[encoding]
+	if !ok {
+		return nil, fmt.Errorf("There is no compressor registered for content encoding: %s", encoding)
+	}
+	return codec.Decompress(data)
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"bytes"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&CompressionSuite{})
+
+type CompressionSuite struct {
+	compression *Compression
+	large       []byte
+}
+
+func (s *CompressionSuite) SetUpTest(c *C) {
+	s.compression, _ = NewCompression(&GzipCompressor{}, 100)
+	s.large = bytes.Repeat([]byte(`{"Item":"Some Item"}`), 50)
+}
+
+func (s *CompressionSuite) TestNewCompressionWithNilCompressorReturnsAnError(c *C) {
+	compression, err := NewCompression(nil, 100)
+
+	c.Assert(compression, IsNil)
+	c.Assert(err, ErrorMatches, "Nil Compressor injected into compression.")
+}
+
+func (s *CompressionSuite) TestDataBelowTheThresholdIsNotCompressed(c *C) {
+	data := []byte(`{"Item":"Some Item"}`)
+
+	got, encoding, err := s.compression.Compress(data)
+
+	c.Assert(err, IsNil)
+	c.Assert(encoding, Equals, "")
+	c.Assert(got, DeepEquals, data)
+}
+
+func (s *CompressionSuite) TestRoundTrip(c *C) {
+	compressed, encoding, err := s.compression.Compress(s.large)
+	c.Assert(err, IsNil)
+	c.Assert(encoding, Equals, "gzip")
+	c.Assert(len(compressed) < len(s.large), Equals, true)
+
+	got, err := s.compression.Decompress(compressed, encoding)
+
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, s.large)
+}
+
+func (s *CompressionSuite) TestDeflateRoundTrip(c *C) {
+	compression, _ := NewCompression(&DeflateCompressor{}, 100)
+	compressed, encoding, err := compression.Compress(s.large)
+	c.Assert(err, IsNil)
+	c.Assert(encoding, Equals, "deflate")
+	c.Assert(len(compressed) < len(s.large), Equals, true)
+
+	got, err := s.compression.Decompress(compressed, encoding)
+
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, s.large)
+}
+
+func (s *CompressionSuite) TestCorruptDeflateDataReturnsAnError(c *C) {
+	_, err := s.compression.Decompress([]byte("not deflate"), "deflate")
+
+	c.Assert(err, NotNil)
+}
+
+func (s *CompressionSuite) TestUncompressedDataIsReturnedUnchanged(c *C) {
+	got, err := s.compression.Decompress(s.large, "")
+
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, s.large)
+}
+
+func (s *CompressionSuite) TestUnknownEncodingReturnsAnError(c *C) {
+	_, err := s.compression.Decompress(s.large, "zstd")
+
+	c.Assert(err, ErrorMatches, "There is no compressor registered for content encoding: zstd")
+}
+
+func (s *CompressionSuite) TestRegisteredCompressorsAreUsedForDecompression(c *C) {
+	s.compression.Register(&FakeCompressor{})
+
+	got, err := s.compression.Decompress([]byte("olleh"), "reverse")
+
+	c.Assert(err, IsNil)
+	c.Assert(string(got), Equals, "hello")
+}
+
+func (s *CompressionSuite) TestGzipIsAlwaysReadable(c *C) {
+	compressed, _, _ := s.compression.Compress(s.large)
+	compression, _ := NewCompression(&FakeCompressor{}, 100)
+
+	got, err := compression.Decompress(compressed, "gzip")
+
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, s.large)
+}
+
+// FakeCompressor reverses its input.
+type FakeCompressor struct{}
+
+func (f *FakeCompressor) Encoding() string { return "reverse" }
+
+func (f *FakeCompressor) Compress(data []byte) ([]byte, error) {
+	return f.reverse(data), nil
+}
+
+func (f *FakeCompressor) Decompress(data []byte) ([]byte, error) {
+	return f.reverse(data), nil
+}
+
+func (f *FakeCompressor) reverse(data []byte) []byte {
+	ret := make([]byte, len(data))
+	for k, v := range data {
+		ret[len(data)-1-k] = v
+	}
+	return ret
+}
@@ -0,0 +1,277 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// CommandIDHeader is the key of the header that holds the unique id of a
+// command.
+//
+// Commands carry their correlation and causation ids in the
+// CorrelationIDHeader and CausationIDHeader headers as events do.
+const CommandIDHeader = "CommandID"
+
+// CommandIDOf returns the id of a command, or an empty string if it has none.
+func CommandIDOf(command CommandMessage) string {
+	v, _ := command.Headers()[CommandIDHeader].(string)
+	return v
+}
+
+// CommandCorrelationIDOf returns the correlation id of a command, or an empty
+// string if it has none.
+func CommandCorrelationIDOf(command CommandMessage) string {
+	v, _ := command.Headers()[CorrelationIDHeader].(string)
+	return v
+}
+
+// stampCommand sets the id of a command if it is not already set. A command
+// without a correlation id starts a new correlation identified by its own id.
+func stampCommand(command CommandMessage) {
+	if CommandIDOf(command) == "" {
+		command.SetHeader(CommandIDHeader, NewUUID())
+	}
+	if CommandCorrelationIDOf(command) == "" {
+		command.SetHeader(CorrelationIDHeader, CommandIDOf(command))
+	}
+}
+
+// CausedBy sets the correlation and causation ids of an event raised in
+// response to another event, for instance by a process manager. The event
+// takes the correlation id of the cause and the id of the cause as its
+// causation id.
+func CausedBy(cause EventMessage, event EventMessage) {
+	event.SetHeader(CorrelationIDHeader, correlationIDOf(cause))
+	event.SetHeader(CausationIDHeader, EventIDOf(cause))
+}
+
+// CommandCausedBy sets the correlation and causation ids of a command sent in
+// response to an event, for instance by a process manager.
+func CommandCausedBy(cause EventMessage, command CommandMessage) {
+	command.SetHeader(CorrelationIDHeader, correlationIDOf(cause))
+	command.SetHeader(CausationIDHeader, EventIDOf(cause))
+}
+
+// correlationIDOf returns the correlation id of an event. An event without a
+// correlation id is the start of its own correlation.
+func correlationIDOf(em EventMessage) string {
+	if id := CorrelationIDOf(em); id != "" {
+		return id
+	}
+	return EventIDOf(em)
+}
+
+// CorrelatingRepository is a DomainRepository that gives the events saved
+// through it the correlation id of a command and the command id as their
+// causation id.
+//
+// A CorrelatingRepository is scoped to the handling of a single command, so
+// the events of every aggregate that the handler saves, whether one at a
+// time or together in a UnitOfWork, are correlated with that command, and
+// commands handled concurrently for the same aggregate are never confused.
+// It is usually made for each command by a CorrelatedCommandHandler. Events
+// that already have a correlation id are not changed.
+type CorrelatingRepository struct {
+	repo    DomainRepository
+	command CommandMessage
+}
+
+// NewCorrelatingRepository constructs a new CorrelatingRepository that saves
+// aggregates with the repository specified and correlates their events with
+// the command specified.
+func NewCorrelatingRepository(repo DomainRepository, command CommandMessage) (*CorrelatingRepository, error) {
+	if repo == nil {
+		return nil, fmt.Errorf("Nil DomainRepository injected into correlating repository.")
+	}
+
+	if command == nil {
+		return nil, fmt.Errorf("Nil CommandMessage injected into correlating repository.")
+	}
+
+	return &CorrelatingRepository{
+		repo:    repo,
+		command: command,
+	}, nil
+}
+
+// Load loads an aggregate with the underlying repository.
+func (r *CorrelatingRepository) Load(aggregateType, id string) (AggregateRoot, error) {
+	return r.repo.Load(aggregateType, id)
+}
+
+// Save correlates the changes of an aggregate with the command and saves it
+// with the underlying repository.
+func (r *CorrelatingRepository) Save(aggregate AggregateRoot, expectedVersion *int) error {
+	r.correlate(aggregate)
+	return r.repo.Save(aggregate, expectedVersion)
+}
+
+// SaveAll correlates the changes of several aggregates with the command and
+// saves them with the underlying repository.
+func (r *CorrelatingRepository) SaveAll(aggregates []AggregateRoot, expectedVersions []*int) error {
+	for _, v := range aggregates {
+		r.correlate(v)
+	}
+	return saveAll(r.repo, aggregates, expectedVersions)
+}
+
+func (r *CorrelatingRepository) correlate(aggregate AggregateRoot) {
+	for _, v := range aggregate.GetChanges() {
+		if CorrelationIDOf(v) == "" {
+			CorrelateWithCommand(r.command, v)
+		}
+	}
+}
+
+// CorrelatedCommandHandler is a CommandHandler that makes a command handler
+// for each command it handles, with a CorrelatingRepository scoped to the
+// command, so that every event the handler saves is correlated with the
+// command.
+//
+// The dispatcher stamps commands but does not correlate events, so a
+// CorrelatedCommandHandler is required for the events saved by a command
+// handler to carry correlation and causation headers.
+//
+//	handler, err := ycq.NewCorrelatedCommandHandler(repo, func(repo ycq.DomainRepository) ycq.CommandHandler {
+//		return NewInventoryCommandHandlers(repo)
+//	})
+//	...
+//	dispatcher.RegisterHandler(handler, &CreateInventoryItem{}, &RenameInventoryItem{})
+type CorrelatedCommandHandler struct {
+	repo       DomainRepository
+	newHandler func(DomainRepository) CommandHandler
+}
+
+// NewCorrelatedCommandHandler constructs a new CorrelatedCommandHandler that
+// makes command handlers with the delegate specified.
+func NewCorrelatedCommandHandler(repo DomainRepository, newHandler func(DomainRepository) CommandHandler) (*CorrelatedCommandHandler, error) {
+	if repo == nil {
+		return nil, fmt.Errorf("Nil DomainRepository injected into correlated command handler.")
+	}
+
+	if newHandler == nil {
+		return nil, fmt.Errorf("Nil delegate injected into correlated command handler.")
+	}
+
+	return &CorrelatedCommandHandler{
+		repo:       repo,
+		newHandler: newHandler,
+	}, nil
+}
+
+// Handle passes the command to a command handler made with a repository
+// scoped to the command.
+//
+// A command without an id is given one, and a command without a correlation
+// id is given its own id as its correlation id.
+func (h *CorrelatedCommandHandler) Handle(command CommandMessage) error {
+	stampCommand(command)
+	repo, err := NewCorrelatingRepository(h.repo, command)
+	if err != nil {
+		return err
+	}
+	return h.newHandler(repo).Handle(command)
+}
+
+// CorrelateWithCommand sets the correlation and causation ids of an event
+// raised in response to a command.
+func CorrelateWithCommand(command CommandMessage, event EventMessage) {
+	stampCommand(command)
+	event.SetHeader(CorrelationIDHeader, CommandCorrelationIDOf(command))
+	event.SetHeader(CausationIDHeader, CommandIDOf(command))
+}
+
+// CorrelationReader is implemented by stores that can read all of the events
+// with a correlation id.
+type CorrelationReader interface {
+	EventsByCorrelationID(correlationID string) ([]EventMessage, error)
+}
+
+// CausalNode is a message in a causal tree.
+//
+// Event is nil for messages that are not events, such as the commands that
+// caused events, which are known only by their id.
+type CausalNode struct {
+	ID       string
+	Event    EventMessage
+	Children []*CausalNode
+}
+
+// CausalTree reads the events of a correlation and returns them arranged by
+// causation. See BuildCausalTree.
+func CausalTree(reader CorrelationReader, correlationID string) ([]*CausalNode, error) {
+	events, err := reader.EventsByCorrelationID(correlationID)
+	if err != nil {
+		return nil, err
+	}
+	return BuildCausalTree(events), nil
+}
+
+// BuildCausalTree arranges events by causation.
+//
+// Each event is a child of the message that caused it. Messages that are
+// not among the events, such as commands, are included as nodes without an
+// event. The roots of the tree are returned. Children are ordered by the time
+// at which they occurred.
+func BuildCausalTree(events []EventMessage) []*CausalNode {
+	nodes := make(map[string]*CausalNode)
+	node := func(id string) *CausalNode {
+		n, ok := nodes[id]
+		if !ok {
+			n = &CausalNode{ID: id}
+			nodes[id] = n
+		}
+		return n
+	}
+
+	for _, v := range events {
+		node(EventIDOf(v)).Event = v
+	}
+
+	hasParent := make(map[string]bool)
+	for _, v := range events {
+		cause := CausationIDOf(v)
+		if cause == "" {
+			continue
+		}
+		parent := node(cause)
+		parent.Children = append(parent.Children, nodes[EventIDOf(v)])
+		hasParent[EventIDOf(v)] = true
+	}
+
+	var roots []*CausalNode
+	for id, n := range nodes {
+		sortCausalNodes(n.Children)
+		if !hasParent[id] {
+			roots = append(roots, n)
+		}
+	}
+	sortCausalNodes(roots)
+	return roots
+}
+
+func sortCausalNodes(nodes []*CausalNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return causalNodeTime(nodes[i]).Before(causalNodeTime(nodes[j]))
+	})
+}
+
+// causalNodeTime returns the time at which the message of a node occurred. A
+// node without an event is placed at the time of its earliest child.
+func causalNodeTime(n *CausalNode) (t time.Time) {
+	if n.Event != nil {
+		return OccurredAtOf(n.Event)
+	}
+	for _, v := range n.Children {
+		if ct := causalNodeTime(v); t.IsZero() || ct.Before(t) {
+			t = ct
+		}
+	}
+	return t
+}
@@ -0,0 +1,251 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&CorrelationSuite{})
+
+type CorrelationSuite struct{}
+
+// SaveSomeEventHandler is a command handler that saves a SomeEvent to the
+// aggregate of each command it handles.
+type SaveSomeEventHandler struct {
+	repo DomainRepository
+}
+
+func (h *SaveSomeEventHandler) Handle(command CommandMessage) error {
+	agg := NewSomeAggregate(command.AggregateID())
+	agg.TrackChange(NewEventMessage(command.AggregateID(), &SomeEvent{"Some data", 4}, nil))
+	return h.repo.Save(agg, nil)
+}
+
+func newTimedEventMessage(id, correlationID, causationID string, at time.Time) *EventDescriptor {
+	em := NewEventMessage(NewUUID(), &SomeEvent{"Some data", 4}, nil)
+	em.SetHeader(EventIDHeader, id)
+	em.SetHeader(OccurredAtHeader, at)
+	em.SetHeader(CorrelationIDHeader, correlationID)
+	if causationID != "" {
+		em.SetHeader(CausationIDHeader, causationID)
+	}
+	return em
+}
+
+func (s *CorrelationSuite) TestDispatchGivesCommandAnIDAndCorrelationID(c *C) {
+	bus := NewInMemoryDispatcher()
+	bus.RegisterHandler(&TestCommandHandler{}, &SomeCommand{})
+	cmd := NewSomeCommandMessage(NewUUID())
+
+	err := bus.Dispatch(cmd)
+
+	c.Assert(err, IsNil)
+	c.Assert(cmd.CommandID(), Not(Equals), "")
+	c.Assert(cmd.CorrelationID(), Equals, cmd.CommandID())
+}
+
+func (s *CorrelationSuite) TestDispatchKeepsExistingCorrelationID(c *C) {
+	bus := NewInMemoryDispatcher()
+	bus.RegisterHandler(&TestCommandHandler{}, &SomeCommand{})
+	cmd := NewSomeCommandMessage(NewUUID())
+	cmd.SetHeader(CommandIDHeader, "command-1")
+	cmd.SetHeader(CorrelationIDHeader, "correlation-1")
+
+	c.Assert(bus.Dispatch(cmd), IsNil)
+
+	c.Assert(cmd.CommandID(), Equals, "command-1")
+	c.Assert(cmd.CorrelationID(), Equals, "correlation-1")
+}
+
+func (s *CorrelationSuite) TestEventsSavedByHandlerAreCorrelatedWithCommand(c *C) {
+	eventBus := NewInternalEventBus()
+	handler := NewMockEventHandler()
+	eventBus.AddHandler(handler, &SomeEvent{})
+	repo, _ := NewInMemoryRepository(eventBus)
+	correlated, err := NewCorrelatedCommandHandler(repo, func(repo DomainRepository) CommandHandler {
+		return &SaveSomeEventHandler{repo: repo}
+	})
+	c.Assert(err, IsNil)
+	bus := NewInMemoryDispatcher()
+	bus.RegisterHandler(correlated, &SomeCommand{})
+	cmd := NewSomeCommandMessage(NewUUID())
+	cmd.SetHeader(CorrelationIDHeader, "correlation-1")
+
+	c.Assert(bus.Dispatch(cmd), IsNil)
+
+	c.Assert(handler.events, HasLen, 1)
+	c.Assert(CorrelationIDOf(handler.events[0]), Equals, "correlation-1")
+	c.Assert(CausationIDOf(handler.events[0]), Equals, cmd.CommandID())
+}
+
+// SaveTwoAggregatesHandler is a command handler that saves an event to the
+// aggregate of each command it handles and to a second aggregate in the same
+// unit of work.
+type SaveTwoAggregatesHandler struct {
+	repo  DomainRepository
+	other string
+}
+
+func (h *SaveTwoAggregatesHandler) Handle(command CommandMessage) error {
+	uow := NewUnitOfWork(h.repo)
+	for _, id := range []string{command.AggregateID(), h.other} {
+		agg := NewSomeAggregate(id)
+		agg.TrackChange(NewEventMessage(id, &SomeEvent{"Some data", 4}, nil))
+		uow.Track(agg)
+	}
+	return uow.Commit()
+}
+
+func (s *CorrelationSuite) TestEventsOfEveryAggregateSavedByHandlerAreCorrelated(c *C) {
+	eventBus := NewInternalEventBus()
+	handler := NewMockEventHandler()
+	eventBus.AddHandler(handler, &SomeEvent{})
+	repo, _ := NewInMemoryRepository(eventBus)
+	other := NewUUID()
+	correlated, _ := NewCorrelatedCommandHandler(repo, func(repo DomainRepository) CommandHandler {
+		return &SaveTwoAggregatesHandler{repo: repo, other: other}
+	})
+	cmd := NewSomeCommandMessage(NewUUID())
+	cmd.SetHeader(CorrelationIDHeader, "correlation-1")
+
+	c.Assert(correlated.Handle(cmd), IsNil)
+
+	c.Assert(handler.events, HasLen, 2)
+	c.Assert(handler.events[1].AggregateID(), Equals, other)
+	for _, v := range handler.events {
+		c.Assert(CorrelationIDOf(v), Equals, "correlation-1")
+		c.Assert(CausationIDOf(v), Equals, cmd.CommandID())
+	}
+}
+
+func (s *CorrelationSuite) TestCommandsForTheSameAggregateAreCorrelatedSeparately(c *C) {
+	repo, _ := NewInMemoryRepository(NewInternalEventBus())
+	id := NewUUID()
+	first := NewSomeCommandMessage(id)
+	stampCommand(first)
+	second := NewSomeCommandMessage(id)
+	stampCommand(second)
+	firstRepo, _ := NewCorrelatingRepository(repo, first)
+	secondRepo, _ := NewCorrelatingRepository(repo, second)
+	firstAgg := NewSomeAggregate(id)
+	firstEvent := NewEventMessage(id, &SomeEvent{"Some data", 1}, nil)
+	firstAgg.TrackChange(firstEvent)
+	secondAgg := NewSomeAggregate(id)
+	secondEvent := NewEventMessage(id, &SomeEvent{"Some data", 2}, nil)
+	secondAgg.TrackChange(secondEvent)
+
+	c.Assert(secondRepo.Save(secondAgg, nil), IsNil)
+	c.Assert(firstRepo.Save(firstAgg, nil), IsNil)
+
+	c.Assert(CausationIDOf(firstEvent), Equals, first.CommandID())
+	c.Assert(CausationIDOf(secondEvent), Equals, second.CommandID())
+}
+
+func (s *CorrelationSuite) TestCorrelatingRepositoryDoesNotReplaceExistingCorrelation(c *C) {
+	repo, _ := NewInMemoryRepository(NewInternalEventBus())
+	cmd := NewSomeCommandMessage(NewUUID())
+	stampCommand(cmd)
+	correlating, _ := NewCorrelatingRepository(repo, cmd)
+	agg := NewSomeAggregate(cmd.AggregateID())
+	em := NewEventMessage(cmd.AggregateID(), &SomeEvent{"Some data", 4}, nil)
+	em.SetHeader(CorrelationIDHeader, "correlation-1")
+	agg.TrackChange(em)
+
+	c.Assert(correlating.Save(agg, nil), IsNil)
+
+	c.Assert(CorrelationIDOf(em), Equals, "correlation-1")
+	c.Assert(CausationIDOf(em), Equals, "")
+}
+
+func (s *CorrelationSuite) TestCorrelationChecksItsDependencies(c *C) {
+	repo, _ := NewInMemoryRepository(NewInternalEventBus())
+	_, err := NewCorrelatingRepository(nil, NewSomeCommandMessage(NewUUID()))
+	c.Assert(err, ErrorMatches, "Nil DomainRepository injected into correlating repository.")
+	_, err = NewCorrelatingRepository(repo, nil)
+	c.Assert(err, ErrorMatches, "Nil CommandMessage injected into correlating repository.")
+	_, err = NewCorrelatedCommandHandler(nil, func(DomainRepository) CommandHandler { return nil })
+	c.Assert(err, ErrorMatches, "Nil DomainRepository injected into correlated command handler.")
+	_, err = NewCorrelatedCommandHandler(repo, nil)
+	c.Assert(err, ErrorMatches, "Nil delegate injected into correlated command handler.")
+}
+
+func (s *CorrelationSuite) TestCausedBy(c *C) {
+	cause := newTimedEventMessage("event-1", "correlation-1", "command-1", time.Now())
+	em := NewEventMessage(NewUUID(), &SomeOtherEvent{"Some order"}, nil)
+
+	CausedBy(cause, em)
+
+	c.Assert(CorrelationIDOf(em), Equals, "correlation-1")
+	c.Assert(CausationIDOf(em), Equals, "event-1")
+}
+
+func (s *CorrelationSuite) TestCausedByUncorrelatedEventStartsCorrelation(c *C) {
+	cause := NewEventMessage(NewUUID(), &SomeEvent{"Some data", 4}, nil)
+	cause.SetHeader(EventIDHeader, "event-1")
+	em := NewEventMessage(NewUUID(), &SomeOtherEvent{"Some order"}, nil)
+
+	CausedBy(cause, em)
+
+	c.Assert(CorrelationIDOf(em), Equals, "event-1")
+	c.Assert(CausationIDOf(em), Equals, "event-1")
+}
+
+func (s *CorrelationSuite) TestCommandCausedByKeepsCorrelationThroughDispatch(c *C) {
+	cause := newTimedEventMessage("event-1", "correlation-1", "command-1", time.Now())
+	cmd := NewSomeCommandMessage(NewUUID())
+	CommandCausedBy(cause, cmd)
+	bus := NewInMemoryDispatcher()
+	bus.RegisterHandler(&TestCommandHandler{}, &SomeCommand{})
+
+	c.Assert(bus.Dispatch(cmd), IsNil)
+
+	c.Assert(cmd.CorrelationID(), Equals, "correlation-1")
+	c.Assert(cmd.Headers()[CausationIDHeader], Equals, "event-1")
+}
+
+func (s *CorrelationSuite) TestBuildCausalTree(c *C) {
+	at := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := newTimedEventMessage("event-1", "correlation-1", "command-1", at)
+	second := newTimedEventMessage("event-2", "correlation-1", "command-1", at.Add(time.Second))
+	third := newTimedEventMessage("event-3", "correlation-1", "event-1", at.Add(2*time.Second))
+
+	roots := BuildCausalTree([]EventMessage{third, second, first})
+
+	c.Assert(roots, HasLen, 1)
+	c.Assert(roots[0].ID, Equals, "command-1")
+	c.Assert(roots[0].Event, IsNil)
+	c.Assert(roots[0].Children, HasLen, 2)
+	c.Assert(roots[0].Children[0].Event, Equals, first)
+	c.Assert(roots[0].Children[1].Event, Equals, second)
+	c.Assert(roots[0].Children[0].Children, HasLen, 1)
+	c.Assert(roots[0].Children[0].Children[0].Event, Equals, third)
+}
+
+func (s *CorrelationSuite) TestCausalTreeFromInMemoryRepository(c *C) {
+	repo, _ := NewInMemoryRepository(NewInternalEventBus())
+	id := NewUUID()
+	cause := newTimedEventMessage("event-1", "correlation-1", "command-1", time.Now())
+	effect := NewEventMessage(id, &SomeOtherEvent{"Some order"}, nil)
+	CausedBy(cause, effect)
+	agg := NewSomeAggregate(id)
+	agg.TrackChange(cause)
+	agg.TrackChange(effect)
+	agg.TrackChange(NewEventMessage(id, &SomeEvent{"Unrelated", 1}, nil))
+	c.Assert(repo.Save(agg, nil), IsNil)
+
+	roots, err := CausalTree(repo, "correlation-1")
+
+	c.Assert(err, IsNil)
+	c.Assert(roots, HasLen, 1)
+	c.Assert(roots[0].ID, Equals, "command-1")
+	c.Assert(roots[0].Children, HasLen, 1)
+	c.Assert(EventIDOf(roots[0].Children[0].Event), Equals, "event-1")
+	c.Assert(roots[0].Children[0].Children, HasLen, 1)
+	c.Assert(roots[0].Children[0].Children[0].Event.Event(), DeepEquals, &SomeOtherEvent{"Some order"})
+}
@@ -41,8 +41,14 @@ func NewInMemoryDispatcher() *InMemoryDispatcher {
 }
 
 //Dispatch passes the CommandMessage on to all registered command handlers.
+//
+//A command without an id is given one, and a command without a correlation id
+//is given its own id as its correlation id. Only the command is stamped; for
+//the events saved by a handler to be correlated with the command the handler
+//must be registered as a CorrelatedCommandHandler.
 func (b *InMemoryDispatcher) Dispatch(command CommandMessage) error {
 	if handler, ok := b.handlers[command.CommandType()]; ok {
+		stampCommand(command)
 		return handler.Handle(command)
 	}
 	return fmt.Errorf("The command bus does not have a handler for commands of type: %s", command.CommandType())
@@ -52,7 +58,10 @@ func (b *InMemoryDispatcher) Dispatch(command CommandMessage) error {
 //variadic commands parameter.
 func (b *InMemoryDispatcher) RegisterHandler(handler CommandHandler, commands ...interface{}) error {
 	for _, command := range commands {
-		typeName := typeOf(command)
+		typeName, err := TypeNameOf(command)
+		if err != nil {
+			return err
+		}
 		if _, ok := b.handlers[typeName]; ok {
 			return fmt.Errorf("Duplicate command handler registration with command bus for command of type: %s", typeName)
 		}
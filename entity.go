@@ -0,0 +1,181 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"fmt"
+)
+
+// EntityIDHeader is the key of the header that holds the id of the child
+// entity of an aggregate that an event is addressed to.
+const EntityIDHeader = "EntityID"
+
+// EntityIDOf returns the id of the child entity an event is addressed to, or
+// an empty string if the event is addressed to the aggregate root.
+func EntityIDOf(em EventMessage) string {
+	v, _ := HeaderString(em, EntityIDHeader)
+	return v
+}
+
+// Entity is the interface that child entities of an aggregate must
+// implement.
+//
+// An entity has an identity of its own within the aggregate but no stream of
+// its own. Its events are saved in the stream of the aggregate root, which
+// owns versioning and change tracking, and are routed to the entity by the
+// id in the EntityIDHeader.
+type Entity interface {
+	EntityID() string
+	Apply(event EventMessage)
+}
+
+// Entities holds the child entities of an aggregate and routes the events
+// addressed to them.
+//
+// The aggregate root creates its entities as it applies its own events, so
+// that loading the aggregate rebuilds the entities as well as the root. The
+// root routes events to the entities before applying them itself.
+//
+//	func (o *Order) Apply(em ycq.EventMessage, isNew bool) {
+//		if isNew {
+//			o.TrackChange(em)
+//		}
+//		if o.lines.Route(em) {
+//			return
+//		}
+//		switch ev := em.Event().(type) {
+//		case *LineAdded:
+//			o.lines.Add(NewOrderLine(ev.LineID, o.lines))
+//		}
+//	}
+type Entities struct {
+	root      *AggregateBase
+	aggregate AggregateRoot
+	entities  map[string]Entity
+	order     []string
+}
+
+// NewEntities constructs a new Entities for the aggregate specified. The root
+// is the AggregateBase embedded in the aggregate, which gives the events
+// raised by the entities their versions.
+func NewEntities(root *AggregateBase, aggregate AggregateRoot) *Entities {
+	return &Entities{
+		root:      root,
+		aggregate: aggregate,
+		entities:  make(map[string]Entity),
+	}
+}
+
+// Add adds an entity to the aggregate.
+func (e *Entities) Add(entity Entity) error {
+	if entity == nil {
+		return fmt.Errorf("Nil entity added to aggregate %s.", e.root.AggregateID())
+	}
+	id := entity.EntityID()
+	if id == "" {
+		return fmt.Errorf("Entity of type %s added to aggregate %s has no id.", typeOf(entity), e.root.AggregateID())
+	}
+	if _, ok := e.entities[id]; ok {
+		return fmt.Errorf("Entity with id %s already added to aggregate %s.", id, e.root.AggregateID())
+	}
+	e.entities[id] = entity
+	e.order = append(e.order, id)
+	return nil
+}
+
+// Remove removes the entity with the id specified. Events later addressed to
+// the entity are dropped by Route.
+func (e *Entities) Remove(id string) {
+	if _, ok := e.entities[id]; !ok {
+		return
+	}
+	delete(e.entities, id)
+	for k, v := range e.order {
+		if v == id {
+			e.order = append(e.order[:k], e.order[k+1:]...)
+			break
+		}
+	}
+}
+
+// Get returns the entity with the id specified, or nil if there is none.
+func (e *Entities) Get(id string) Entity {
+	return e.entities[id]
+}
+
+// All returns the entities in the order in which they were added.
+func (e *Entities) All() []Entity {
+	all := make([]Entity, 0, len(e.order))
+	for _, id := range e.order {
+		all = append(all, e.entities[id])
+	}
+	return all
+}
+
+// Len returns the number of entities.
+func (e *Entities) Len() int {
+	return len(e.order)
+}
+
+// Route applies an event addressed to an entity to that entity and reports
+// whether the event was addressed to an entity, in which case the root must
+// not apply it. Events addressed to the root are not routed.
+//
+// Events addressed to an entity that has not been added or has been removed
+// are dropped: Route reports them as routed without applying them, so that
+// they never reach the handlers of the root.
+func (e *Entities) Route(em EventMessage) bool {
+	id := EntityIDOf(em)
+	if id == "" {
+		return false
+	}
+	if entity, ok := e.entities[id]; ok {
+		entity.Apply(em)
+	}
+	return true
+}
+
+// raiseEvent raises an event addressed to an entity through the aggregate
+// root.
+func (e *Entities) raiseEvent(entityID string, event interface{}) EventMessage {
+	em := e.root.newEvent(event)
+	em.SetHeader(EntityIDHeader, entityID)
+	e.root.TrackChange(em)
+	e.aggregate.Apply(em, false)
+	return em
+}
+
+// EntityBase is a type that can be embedded in an Entity implementation to
+// give it an id and have it raise events through its aggregate.
+type EntityBase struct {
+	id       string
+	entities *Entities
+}
+
+// NewEntityBase constructs a new EntityBase for an entity with the id
+// specified, held by the entities of an aggregate.
+func NewEntityBase(id string, entities *Entities) *EntityBase {
+	return &EntityBase{
+		id:       id,
+		entities: entities,
+	}
+}
+
+// EntityID returns the id of the entity.
+func (e *EntityBase) EntityID() string {
+	return e.id
+}
+
+// RaiseEvent wraps an event payload in an EventMessage addressed to the
+// entity, tracks it as a change of the aggregate, applies it to the
+// aggregate and returns it.
+//
+// The message is given the id and next version of the aggregate, which
+// routes it to the entity. As with AggregateBase.RaiseEvent, Apply is called
+// with isNew false.
+func (e *EntityBase) RaiseEvent(event interface{}) EventMessage {
+	return e.entities.raiseEvent(e.id, event)
+}
@@ -0,0 +1,223 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&EntitySuite{})
+
+type EntitySuite struct{}
+
+type SomeLineAdded struct {
+	LineID string
+	Count  int
+}
+
+type SomeLineCountChanged struct {
+	Count int
+}
+
+type SomeLineRemoved struct {
+	LineID string
+}
+
+// SomeOrderAggregate is an aggregate with line items as child entities.
+type SomeOrderAggregate struct {
+	*AggregateBase
+	lines *Entities
+}
+
+func NewSomeOrderAggregate(id string) *SomeOrderAggregate {
+	a := &SomeOrderAggregate{AggregateBase: NewAggregateBase(id)}
+	a.lines = NewEntities(a.AggregateBase, a)
+	return a
+}
+
+func (a *SomeOrderAggregate) Apply(em EventMessage, isNew bool) {
+	if isNew {
+		a.TrackChange(em)
+	}
+	if a.lines.Route(em) {
+		return
+	}
+	switch ev := em.Event().(type) {
+	case *SomeLineAdded:
+		a.lines.Add(NewSomeLine(ev.LineID, ev.Count, a.lines))
+	case *SomeLineRemoved:
+		a.lines.Remove(ev.LineID)
+	}
+}
+
+func (a *SomeOrderAggregate) line(id string) *SomeLine {
+	line, _ := a.lines.Get(id).(*SomeLine)
+	return line
+}
+
+// SomeLine is a line item of SomeOrderAggregate.
+type SomeLine struct {
+	*EntityBase
+	count int
+}
+
+func NewSomeLine(id string, count int, entities *Entities) *SomeLine {
+	return &SomeLine{EntityBase: NewEntityBase(id, entities), count: count}
+}
+
+func (l *SomeLine) ChangeCount(count int) EventMessage {
+	return l.RaiseEvent(&SomeLineCountChanged{Count: count})
+}
+
+func (l *SomeLine) Apply(em EventMessage) {
+	if ev, ok := em.Event().(*SomeLineCountChanged); ok {
+		l.count = ev.Count
+	}
+}
+
+// SomeRoutedOrderAggregate is a routed aggregate with line items as child
+// entities.
+type SomeRoutedOrderAggregate struct {
+	*RoutedAggregateBase
+}
+
+func NewSomeRoutedOrderAggregate(id string) *SomeRoutedOrderAggregate {
+	a := &SomeRoutedOrderAggregate{}
+	a.RoutedAggregateBase = NewRoutedAggregateBase(id, a)
+	return a
+}
+
+func (a *SomeRoutedOrderAggregate) OnSomeLineAdded(ev *SomeLineAdded) {
+	a.Entities().Add(NewSomeLine(ev.LineID, ev.Count, a.Entities()))
+}
+
+func (s *EntitySuite) TestEventsRaisedByAnEntityAreTrackedByTheRoot(c *C) {
+	id := NewUUID()
+	agg := NewSomeOrderAggregate(id)
+	agg.RaiseEvent(agg, &SomeLineAdded{LineID: "line-1", Count: 1})
+
+	em := agg.line("line-1").ChangeCount(3)
+
+	c.Assert(agg.line("line-1").count, Equals, 3)
+	c.Assert(agg.GetChanges(), HasLen, 2)
+	c.Assert(agg.GetChanges()[1], Equals, em)
+	c.Assert(em.AggregateID(), Equals, id)
+	c.Assert(EntityIDOf(em), Equals, "line-1")
+	c.Assert(*em.Version(), Equals, 1)
+	c.Assert(agg.CurrentVersion(), Equals, 1)
+}
+
+func (s *EntitySuite) TestEventsAreRoutedByEntityID(c *C) {
+	agg := NewSomeOrderAggregate(NewUUID())
+	agg.RaiseEvent(agg, &SomeLineAdded{LineID: "line-1", Count: 1})
+	agg.RaiseEvent(agg, &SomeLineAdded{LineID: "line-2", Count: 2})
+
+	agg.line("line-2").ChangeCount(5)
+
+	c.Assert(agg.line("line-1").count, Equals, 1)
+	c.Assert(agg.line("line-2").count, Equals, 5)
+}
+
+func (s *EntitySuite) TestEventsForRemovedEntitiesAreDropped(c *C) {
+	agg := NewSomeOrderAggregate(NewUUID())
+	agg.RaiseEvent(agg, &SomeLineAdded{LineID: "line-1", Count: 1})
+	line := agg.line("line-1")
+	em := NewEventMessage(agg.AggregateID(), &SomeLineCountChanged{Count: 3}, nil)
+	em.SetHeader(EntityIDHeader, "line-1")
+	agg.RaiseEvent(agg, &SomeLineRemoved{LineID: "line-1"})
+
+	c.Assert(agg.lines.Route(em), Equals, true)
+	c.Assert(line.count, Equals, 1)
+	c.Assert(agg.lines.Len(), Equals, 0)
+}
+
+func (s *EntitySuite) TestEventsForUnknownEntitiesDoNotReachTheRoot(c *C) {
+	agg := NewSomeRoutedOrderAggregate(NewUUID())
+	em := NewEventMessage(agg.AggregateID(), &SomeLineAdded{LineID: "line-1", Count: 1}, nil)
+	em.SetHeader(EntityIDHeader, "line-9")
+
+	agg.Apply(em, false)
+
+	c.Assert(agg.Entities().Len(), Equals, 0)
+}
+
+func (s *EntitySuite) TestEventsForTheRootAreNotRouted(c *C) {
+	agg := NewSomeOrderAggregate(NewUUID())
+
+	c.Assert(agg.lines.Route(NewTestEventMessage(agg.AggregateID())), Equals, false)
+}
+
+func (s *EntitySuite) TestAllReturnsEntitiesInTheOrderAdded(c *C) {
+	agg := NewSomeOrderAggregate(NewUUID())
+	agg.RaiseEvent(agg, &SomeLineAdded{LineID: "line-2", Count: 1})
+	agg.RaiseEvent(agg, &SomeLineAdded{LineID: "line-1", Count: 1})
+	agg.RaiseEvent(agg, &SomeLineAdded{LineID: "line-3", Count: 1})
+	agg.lines.Remove("line-1")
+
+	all := agg.lines.All()
+
+	c.Assert(all, HasLen, 2)
+	c.Assert(all[0].EntityID(), Equals, "line-2")
+	c.Assert(all[1].EntityID(), Equals, "line-3")
+}
+
+func (s *EntitySuite) TestAddingAnEntityTwiceReturnsAnError(c *C) {
+	agg := NewSomeOrderAggregate(NewUUID())
+	c.Assert(agg.lines.Add(NewSomeLine("line-1", 1, agg.lines)), IsNil)
+
+	err := agg.lines.Add(NewSomeLine("line-1", 1, agg.lines))
+
+	c.Assert(err, ErrorMatches, "Entity with id line-1 already added to aggregate .*")
+}
+
+func (s *EntitySuite) TestAddingAnEntityWithoutAnIDReturnsAnError(c *C) {
+	agg := NewSomeOrderAggregate(NewUUID())
+
+	err := agg.lines.Add(NewSomeLine("", 1, agg.lines))
+
+	c.Assert(err, ErrorMatches, "Entity of type SomeLine added to aggregate .* has no id.")
+}
+
+func (s *EntitySuite) TestLoadRebuildsTheEntities(c *C) {
+	repo, _ := NewInMemoryRepository(NewInternalEventBus())
+	factory := NewDelegateAggregateFactory()
+	factory.RegisterConstructors(NewSomeOrderAggregate)
+	repo.SetAggregateFactory(factory)
+	id := NewUUID()
+	agg := NewSomeOrderAggregate(id)
+	agg.RaiseEvent(agg, &SomeLineAdded{LineID: "line-1", Count: 1})
+	agg.RaiseEvent(agg, &SomeLineAdded{LineID: "line-2", Count: 2})
+	agg.line("line-1").ChangeCount(4)
+	agg.RaiseEvent(agg, &SomeLineRemoved{LineID: "line-2"})
+	c.Assert(repo.Save(agg, nil), IsNil)
+
+	got, err := repo.Load(typeOf(agg), id)
+
+	c.Assert(err, IsNil)
+	order := got.(*SomeOrderAggregate)
+	c.Assert(order.lines.Len(), Equals, 1)
+	c.Assert(order.line("line-1").count, Equals, 4)
+	c.Assert(order.OriginalVersion(), Equals, 3)
+}
+
+func (s *EntitySuite) TestRoutedAggregatesRouteEventsToEntities(c *C) {
+	agg := NewSomeRoutedOrderAggregate(NewUUID())
+	agg.RaiseEvent(&SomeLineAdded{LineID: "line-1", Count: 1})
+
+	line := agg.Entities().Get("line-1").(*SomeLine)
+	em := line.ChangeCount(2)
+
+	c.Assert(line.count, Equals, 2)
+	c.Assert(agg.GetChanges(), HasLen, 2)
+	c.Assert(*em.Version(), Equals, 1)
+}
+
+func (s *EntitySuite) TestEntityIDHeaderIsDecodedAsAString(c *C) {
+	headers, err := NewHeaderRegistry().Decode([]byte(`{"EntityID":"line-1"}`))
+
+	c.Assert(err, IsNil)
+	c.Assert(headers[EntityIDHeader], Equals, "line-1")
+}
@@ -1,6 +1,9 @@
 package ycq
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // ErrCommandExecution is the error returned in response to a failed command.
 type ErrCommandExecution struct {
@@ -64,3 +67,136 @@ func (e *ErrAggregateNotFound) Error() string {
 		e.AggregateType,
 		e.AggregateID)
 }
+
+// ErrPartialCommit is returned when the changes of several aggregates were
+// being saved without a transaction and saving failed after some of the
+// aggregates had already been persisted.
+//
+// The aggregates in Committed were persisted. The aggregate in Failed and any
+// aggregates after it were not. The original error is available in the Err
+// field.
+type ErrPartialCommit struct {
+	Committed []AggregateRoot
+	Failed    AggregateRoot
+	Err       error
+}
+
+func (e *ErrPartialCommit) Error() string {
+	return fmt.Sprintf("Only %d aggregates were saved before saving aggregate %s failed. %s",
+		len(e.Committed),
+		e.Failed.AggregateID(),
+		e.Err)
+}
+
+// ErrKeyNotFound is returned by a KeyStore when a subject has no encryption
+// key, either because none was created or because it has been deleted.
+type ErrKeyNotFound struct {
+	SubjectID string
+}
+
+func (e *ErrKeyNotFound) Error() string {
+	return fmt.Sprintf("There is no encryption key for subject %s", e.SubjectID)
+}
+
+// ErrKeyDeleted is returned by a KeyStore when a key is requested for a
+// subject whose key has been deleted.
+type ErrKeyDeleted struct {
+	SubjectID string
+}
+
+func (e *ErrKeyDeleted) Error() string {
+	return fmt.Sprintf("The encryption key of subject %s has been deleted.", e.SubjectID)
+}
+
+// ErrBlobIntegrity is returned when a payload read from a BlobStore does not
+// match the hash recorded in its reference.
+type ErrBlobIntegrity struct {
+	Reference *BlobReference
+}
+
+func (e *ErrBlobIntegrity) Error() string {
+	return fmt.Sprintf("The blob %s of stream %s does not match its hash.",
+		e.Reference.ID,
+		e.Reference.StreamName)
+}
+
+// ErrAggregateDeleted is returned when an aggregate whose stream has been
+// deleted is loaded or, if the stream was tombstoned, saved.
+type ErrAggregateDeleted struct {
+	AggregateID   string
+	AggregateType string
+}
+
+func (e *ErrAggregateDeleted) Error() string {
+	return fmt.Sprintf("The aggregate of type %s with id %s has been deleted",
+		e.AggregateType,
+		e.AggregateID)
+}
+
+// ErrRegistration is returned when types have been registered incorrectly or
+// incompletely. All of the mistakes found are listed in Errors.
+type ErrRegistration struct {
+	Errors []error
+}
+
+func (e *ErrRegistration) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for k, v := range e.Errors {
+		msgs[k] = v.Error()
+	}
+	return fmt.Sprintf("%d registration errors: %s", len(e.Errors), strings.Join(msgs, " "))
+}
+
+// ErrUnknownEvent is returned when an event read from a stream has a type for
+// which the event factory has no delegate.
+type ErrUnknownEvent struct {
+	StreamName  string
+	EventNumber int
+	EventType   string
+}
+
+func (e *ErrUnknownEvent) Error() string {
+	return fmt.Sprintf("There is no event factory delegate for event type %s at position %d of stream %s",
+		e.EventType,
+		e.EventNumber,
+		e.StreamName)
+}
+
+// ErrInvariantViolation is returned when an aggregate is saved in a state that
+// breaks one or more of its invariants. Every broken invariant is listed in
+// Violations.
+//
+// The aggregate instance must be discarded and loaded again, since its state
+// still reflects the changes that were discarded.
+type ErrInvariantViolation struct {
+	AggregateID   string
+	AggregateType string
+	Violations    []InvariantViolation
+}
+
+func (e *ErrInvariantViolation) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for k, v := range e.Violations {
+		msgs[k] = fmt.Sprintf("%s: %s.", v.Invariant, v.Err)
+	}
+	return fmt.Sprintf("The aggregate of type %s with id %s breaks %d invariants. %s",
+		e.AggregateType,
+		e.AggregateID,
+		len(e.Violations),
+		strings.Join(msgs, " "))
+}
+
+// ErrInvariantViolations is returned when several aggregates are saved
+// together and one or more of them break their invariants. The violations of
+// every such aggregate are listed.
+type ErrInvariantViolations struct {
+	Violations []*ErrInvariantViolation
+}
+
+func (e *ErrInvariantViolations) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for k, v := range e.Violations {
+		msgs[k] = v.Error()
+	}
+	return fmt.Sprintf("%d aggregates break their invariants: %s", len(e.Violations), strings.Join(msgs, " "))
+}
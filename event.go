@@ -5,6 +5,16 @@
 
 package ycq
 
+import "time"
+
+// Keys of the headers that hold the envelope fields of an event.
+const (
+	EventIDHeader       = "EventID"
+	OccurredAtHeader    = "OccurredAt"
+	CorrelationIDHeader = "CorrelationID"
+	CausationIDHeader   = "CausationID"
+)
+
 // EventMessage is the interface that a command must implement.
 type EventMessage interface {
 
@@ -30,6 +40,30 @@ type EventMessage interface {
 	Version() *int
 }
 
+// EventEnvelope is the interface implemented by event messages that expose
+// the envelope fields of an event.
+//
+// The envelope fields are held in the headers of the event message so that
+// they are persisted and restored by the repositories along with any other
+// header. The functions EventIDOf, OccurredAtOf, CorrelationIDOf and
+// CausationIDOf read them from any EventMessage.
+type EventEnvelope interface {
+	EventMessage
+
+	// EventID returns the unique id of the event.
+	EventID() string
+
+	// OccurredAt returns the time at which the event was raised.
+	OccurredAt() time.Time
+
+	// CorrelationID returns the id shared by all of the messages that
+	// result from the same original request.
+	CorrelationID() string
+
+	// CausationID returns the id of the message that caused the event.
+	CausationID() string
+}
+
 // EventDescriptor is an implementation of the event message interface.
 type EventDescriptor struct {
 	id      string
@@ -77,3 +111,61 @@ func (c *EventDescriptor) Event() interface{} {
 func (c *EventDescriptor) Version() *int {
 	return c.version
 }
+
+// EventID returns the unique id of the event.
+func (c *EventDescriptor) EventID() string {
+	return EventIDOf(c)
+}
+
+// OccurredAt returns the time at which the event was raised.
+func (c *EventDescriptor) OccurredAt() time.Time {
+	return OccurredAtOf(c)
+}
+
+// CorrelationID returns the correlation id of the event.
+func (c *EventDescriptor) CorrelationID() string {
+	return CorrelationIDOf(c)
+}
+
+// CausationID returns the causation id of the event.
+func (c *EventDescriptor) CausationID() string {
+	return CausationIDOf(c)
+}
+
+// EventIDOf returns the id of an event, or an empty string if it has none.
+func EventIDOf(em EventMessage) string {
+	v, _ := HeaderString(em, EventIDHeader)
+	return v
+}
+
+// OccurredAtOf returns the time at which an event was raised, or the zero
+// time if it is not known.
+func OccurredAtOf(em EventMessage) time.Time {
+	v, _ := HeaderTime(em, OccurredAtHeader)
+	return v
+}
+
+// CorrelationIDOf returns the correlation id of an event, or an empty string
+// if it has none.
+func CorrelationIDOf(em EventMessage) string {
+	v, _ := HeaderString(em, CorrelationIDHeader)
+	return v
+}
+
+// CausationIDOf returns the causation id of an event, or an empty string if
+// it has none.
+func CausationIDOf(em EventMessage) string {
+	v, _ := HeaderString(em, CausationIDHeader)
+	return v
+}
+
+// stampEvent sets the id and the occurred at time of an event message if they
+// are not already set.
+func stampEvent(em EventMessage) {
+	if EventIDOf(em) == "" {
+		em.SetHeader(EventIDHeader, NewUUID())
+	}
+	if _, ok := em.GetHeaders()[OccurredAtHeader]; !ok {
+		em.SetHeader(OccurredAtHeader, time.Now().UTC())
+	}
+}
@@ -7,6 +7,7 @@ package ycq
 
 import (
 	"math/rand"
+	"time"
 
 	. "gopkg.in/check.v1"
 )
@@ -103,3 +104,39 @@ func (s *EventSuite) TestAddHeaderStruct(c *C) {
 
 	c.Assert(em.headers["a"], DeepEquals, ev)
 }
+
+func (s *EventSuite) TestEnvelopeFields(c *C) {
+	occurred := time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC)
+	em := NewTestEventMessage(NewUUID())
+	em.SetHeader(EventIDHeader, "event-1")
+	em.SetHeader(OccurredAtHeader, occurred)
+	em.SetHeader(CorrelationIDHeader, "correlation-1")
+	em.SetHeader(CausationIDHeader, "command-1")
+
+	var envelope EventEnvelope = em
+	c.Assert(envelope.EventID(), Equals, "event-1")
+	c.Assert(envelope.OccurredAt(), Equals, occurred)
+	c.Assert(envelope.CorrelationID(), Equals, "correlation-1")
+	c.Assert(envelope.CausationID(), Equals, "command-1")
+}
+
+func (s *EventSuite) TestEnvelopeFieldsAreEmptyWhenNotSet(c *C) {
+	em := NewTestEventMessage(NewUUID())
+
+	c.Assert(em.EventID(), Equals, "")
+	c.Assert(em.OccurredAt().IsZero(), Equals, true)
+	c.Assert(em.CorrelationID(), Equals, "")
+	c.Assert(em.CausationID(), Equals, "")
+}
+
+func (s *EventSuite) TestStampEventKeepsExistingValues(c *C) {
+	occurred := time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC)
+	em := NewTestEventMessage(NewUUID())
+	em.SetHeader(EventIDHeader, "event-1")
+	em.SetHeader(OccurredAtHeader, occurred)
+
+	stampEvent(em)
+
+	c.Assert(em.EventID(), Equals, "event-1")
+	c.Assert(em.OccurredAt(), Equals, occurred)
+}
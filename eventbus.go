@@ -35,10 +35,29 @@ func (b *InternalEventBus) PublishEvent(event EventMessage) {
 
 // AddHandler registers an event handler for all of the events specified in the
 // variadic events parameter.
+//
+// Events whose type can not be named are not registered. Use RegisterHandler
+// to be told of them.
 func (b *InternalEventBus) AddHandler(handler EventHandler, events ...interface{}) {
+	b.RegisterHandler(handler, events...)
+}
 
+// RegisterHandler registers an event handler for all of the events specified
+// in the variadic events parameter.
+//
+// If the type of an event can not be named an error is returned and the
+// handler is not registered for any of the events.
+func (b *InternalEventBus) RegisterHandler(handler EventHandler, events ...interface{}) error {
+	typeNames := make([]string, 0, len(events))
 	for _, event := range events {
-		typeName := typeOf(event)
+		typeName, err := TypeNameOf(event)
+		if err != nil {
+			return err
+		}
+		typeNames = append(typeNames, typeName)
+	}
+
+	for _, typeName := range typeNames {
 
 		// There can be multiple handlers for any event.
 		// Here we check that a map is initialized to hold these handlers
@@ -50,4 +69,5 @@ func (b *InternalEventBus) AddHandler(handler EventHandler, events ...interface{
 		// Add this handler to the collection of handlers for the type.
 		b.eventHandlers[typeName][handler] = struct{}{}
 	}
+	return nil
 }
@@ -7,6 +7,7 @@ package ycq
 
 import (
 	"fmt"
+	"reflect"
 )
 
 // EventFactory is the interface that an event factory should implement.
@@ -42,7 +43,10 @@ func NewDelegateEventFactory() *DelegateEventFactory {
 // If an attempt is made to register multiple delegates for an event type, an error
 // is returned.
 func (t *DelegateEventFactory) RegisterDelegate(event interface{}, delegate func() interface{}) error {
-	typeName := typeOf(event)
+	typeName, err := TypeNameOf(event)
+	if err != nil {
+		return err
+	}
 	if _, ok := t.eventFactories[typeName]; ok {
 		return fmt.Errorf("Factory delegate already registered for type: \"%s\"", typeName)
 	}
@@ -50,12 +54,57 @@ func (t *DelegateEventFactory) RegisterDelegate(event interface{}, delegate func
 	return nil
 }
 
+// RegisterEvents registers the event types specified by the variadic events
+// parameter. Instances are created by reflection as new zero values of the
+// types, so no delegate is needed.
+//
+// Events must be given as pointers, for instance &MyEvent{}. All of the
+// events that can be registered are, and every mistake, such as an event
+// that is not a pointer or is already registered, is returned together in an
+// *ErrRegistration.
+func (t *DelegateEventFactory) RegisterEvents(events ...interface{}) error {
+	var errs []error
+	for _, event := range events {
+		delegate, err := newEventDelegate(event)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := t.RegisterDelegate(event, delegate); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ErrRegistration{Errors: errs}
+	}
+	return nil
+}
+
+// newEventDelegate returns a delegate that creates new instances of the type
+// that event points to.
+func newEventDelegate(event interface{}) (func() interface{}, error) {
+	if event == nil {
+		return nil, fmt.Errorf("Can not register a nil event.")
+	}
+	t := reflect.TypeOf(event)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() == reflect.Ptr {
+		return nil, fmt.Errorf("Event of type %s must be registered as a pointer to a value.", t)
+	}
+	elem := t.Elem()
+	return func() interface{} {
+		return reflect.New(elem).Interface()
+	}, nil
+}
+
 // GetEvent returns an event instance given an event type as a string.
 //
 // An appropriate delegate must be registered for the event type.
 // If an appropriate delegate is not registered, the method will return nil.
+// The event type may be given by a registered alias, so that events persisted
+// before a type was renamed are instantiated as the renamed type.
 func (t *DelegateEventFactory) GetEvent(typeName string) interface{} {
-	if f, ok := t.eventFactories[typeName]; ok {
+	if f, ok := t.eventFactories[ResolveTypeName(typeName)]; ok {
 		return f()
 	}
 	return nil
@@ -60,3 +60,26 @@ func (s *DelegateEventFactorySuite) TestCanGetEventInstanceFromString(c *C) {
 	ev := s.factory.GetEvent(typeOf(&SomeEvent{}))
 	c.Assert(ev, DeepEquals, &SomeEvent{})
 }
+
+func (s *DelegateEventFactorySuite) TestRegisterEventsCreatesNewInstances(c *C) {
+	err := s.factory.RegisterEvents(&SomeEvent{}, &SomeOtherEvent{})
+
+	c.Assert(err, IsNil)
+	first := s.factory.GetEvent(typeOf(&SomeEvent{}))
+	second := s.factory.GetEvent(typeOf(&SomeEvent{}))
+	c.Assert(first, DeepEquals, &SomeEvent{})
+	c.Assert(first == second, Equals, false)
+	c.Assert(s.factory.GetEvent(typeOf(&SomeOtherEvent{})), DeepEquals, &SomeOtherEvent{})
+}
+
+func (s *DelegateEventFactorySuite) TestRegisterEventsReportsAllMistakes(c *C) {
+	err := s.factory.RegisterEvents(&SomeEvent{}, SomeOtherEvent{}, &SomeEvent{}, nil)
+
+	c.Assert(err, FitsTypeOf, &ErrRegistration{})
+	errs := err.(*ErrRegistration).Errors
+	c.Assert(errs, HasLen, 3)
+	c.Assert(errs[0], ErrorMatches, "Event of type ycq.SomeOtherEvent must be registered as a pointer to a value.")
+	c.Assert(errs[1], ErrorMatches, "Factory delegate already registered for type: \"SomeEvent\"")
+	c.Assert(errs[2], ErrorMatches, "Can not register a nil event.")
+	c.Assert(s.factory.GetEvent(typeOf(&SomeEvent{})), DeepEquals, &SomeEvent{})
+}
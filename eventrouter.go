@@ -0,0 +1,219 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// EventRouter routes events to handler functions by event type.
+//
+// Handlers are registered per event type with On, or discovered by
+// RegisterMethods from methods named On followed by the name of the event
+// type, such as
+//
+//	func (a *InventoryItem) OnInventoryItemCreated(event *InventoryItemCreated)
+//
+// A method may also take the EventMessage as a second parameter.
+type EventRouter struct {
+	handlers map[string]func(EventMessage)
+}
+
+// NewEventRouter constructs a new EventRouter.
+func NewEventRouter() *EventRouter {
+	return &EventRouter{
+		handlers: make(map[string]func(EventMessage)),
+	}
+}
+
+// On registers the handler for events of the type specified.
+func (r *EventRouter) On(event interface{}, handler func(EventMessage)) error {
+	typeName, err := TypeNameOf(event)
+	if err != nil {
+		return err
+	}
+	if handler == nil {
+		return fmt.Errorf("Nil event handler registered for type: \"%s\"", typeName)
+	}
+	if _, ok := r.handlers[typeName]; ok {
+		return fmt.Errorf("Event handler already registered for type: \"%s\"", typeName)
+	}
+	r.handlers[typeName] = handler
+	return nil
+}
+
+// RegisterMethods registers the methods of target that handle events by
+// convention. Methods named On followed by the name of the type of their
+// first parameter, which must be a pointer to the event type, are
+// registered. Methods registered with On are not replaced.
+func (r *EventRouter) RegisterMethods(target interface{}) error {
+	v := reflect.ValueOf(target)
+	t := v.Type()
+	for i := 0; i < t.NumMethod(); i++ {
+		method := t.Method(i)
+		mt := method.Type
+
+		// The method type of a method obtained from a type includes the
+		// receiver as its first parameter.
+		if mt.NumIn() < 2 || mt.NumIn() > 3 || mt.NumOut() != 0 {
+			continue
+		}
+		param := mt.In(1)
+		if param.Kind() != reflect.Ptr || method.Name != "On"+param.Elem().Name() {
+			continue
+		}
+		withMessage := mt.NumIn() == 3
+		if withMessage && mt.In(2) != reflect.TypeOf((*EventMessage)(nil)).Elem() {
+			continue
+		}
+
+		typeName, err := TypeNameOf(reflect.New(param.Elem()).Interface())
+		if err != nil {
+			return err
+		}
+		if _, ok := r.handlers[typeName]; ok {
+			continue
+		}
+
+		fn := v.Method(i)
+		r.handlers[typeName] = func(em EventMessage) {
+			ev := reflect.ValueOf(em.Event())
+			if !ev.IsValid() || !ev.Type().AssignableTo(param) {
+				return
+			}
+			args := []reflect.Value{ev}
+			if withMessage {
+				args = append(args, reflect.ValueOf(&em).Elem())
+			}
+			fn.Call(args)
+		}
+	}
+	return nil
+}
+
+// Route calls the handler for the type of the event and reports whether the
+// event was handled.
+func (r *EventRouter) Route(em EventMessage) bool {
+	handler, ok := r.handlers[em.EventType()]
+	if !ok {
+		return false
+	}
+	handler(em)
+	return true
+}
+
+// HandlesEvent reports whether the router has a handler for the event type
+// specified.
+func (r *EventRouter) HandlesEvent(eventType string) bool {
+	_, ok := r.handlers[eventType]
+	return ok
+}
+
+// CheckHandlers checks that the router has a handler for each of the events
+// specified. The events without a handler are returned together in an
+// *ErrRegistration.
+func (r *EventRouter) CheckHandlers(events ...interface{}) error {
+	var errs []error
+	for _, event := range events {
+		typeName, err := TypeNameOf(event)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !r.HandlesEvent(typeName) {
+			errs = append(errs, fmt.Errorf("There is no event handler for type: \"%s\"", typeName))
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ErrRegistration{Errors: errs}
+	}
+	return nil
+}
+
+// RoutedAggregateBase is a type that can be embedded in an AggregateRoot
+// implementation in place of AggregateBase to have events routed to handlers
+// by an EventRouter, so that the aggregate need not implement Apply.
+//
+// The aggregate passes itself to NewRoutedAggregateBase so that its On
+// methods are registered.
+//
+//	func NewInventoryItem(id string) *InventoryItem {
+//		a := &InventoryItem{}
+//		a.RoutedAggregateBase = ycq.NewRoutedAggregateBase(id, a)
+//		return a
+//	}
+type RoutedAggregateBase struct {
+	*AggregateBase
+	*EventRouter
+	entities *Entities
+}
+
+// NewRoutedAggregateBase constructs a new RoutedAggregateBase that routes
+// events to the On methods of the aggregate specified. Further handlers can
+// be registered with On.
+func NewRoutedAggregateBase(id string, aggregate interface{}) *RoutedAggregateBase {
+	b := &RoutedAggregateBase{
+		AggregateBase: NewAggregateBase(id),
+		EventRouter:   NewEventRouter(),
+	}
+	b.entities = NewEntities(b.AggregateBase, b)
+	if aggregate != nil {
+		b.RegisterMethods(aggregate)
+	}
+	return b
+}
+
+// routedAggregate is implemented by aggregates that embed RoutedAggregateBase.
+type routedAggregate interface {
+	HandlesEvent(eventType string) bool
+	routes() bool
+}
+
+// routes reports whether the base has been constructed, which it has not in
+// an aggregate registered as a zero value.
+func (b *RoutedAggregateBase) routes() bool {
+	return b != nil && b.EventRouter != nil
+}
+
+// Entities returns the child entities of the aggregate. Events addressed to
+// an entity are routed to it before the handlers of the aggregate.
+func (b *RoutedAggregateBase) Entities() *Entities {
+	return b.entities
+}
+
+// RaiseEvent wraps an event payload in an EventMessage for the aggregate,
+// tracks it as a change, routes it and returns it. The message is given the
+// aggregate id, the next version of the aggregate, an event id and the time
+// at which it occurred.
+//
+// Unlike AggregateBase.RaiseEvent the aggregate need not be passed in, since
+// the events of a RoutedAggregateBase are applied by its router.
+func (b *RoutedAggregateBase) RaiseEvent(event interface{}) EventMessage {
+	em := b.newEvent(event)
+	b.TrackChange(em)
+	b.Apply(em, false)
+	return em
+}
+
+// Apply routes the event to its handler. New events are tracked as changes
+// first. Events addressed to a child entity are applied to the entity, or
+// dropped if the aggregate has no such entity, and never reach the handlers
+// of the aggregate. Events without a handler are tracked but otherwise
+// ignored.
+//
+// The version of the aggregate follows its changes and, for events that are
+// not new, is incremented by the repository as events are loaded.
+func (b *RoutedAggregateBase) Apply(em EventMessage, isNew bool) {
+	if isNew {
+		b.TrackChange(em)
+	}
+	if b.entities.Route(em) {
+		return
+	}
+	b.Route(em)
+}
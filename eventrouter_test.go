@@ -0,0 +1,176 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&EventRouterSuite{})
+
+type EventRouterSuite struct{}
+
+// SomeRoutedAggregate is an aggregate whose events are routed to its On
+// methods.
+type SomeRoutedAggregate struct {
+	*RoutedAggregateBase
+	items   []string
+	orders  []string
+	headers []map[string]interface{}
+}
+
+func NewSomeRoutedAggregate(id string) AggregateRoot {
+	a := &SomeRoutedAggregate{}
+	a.RoutedAggregateBase = NewRoutedAggregateBase(id, a)
+	return a
+}
+
+func (a *SomeRoutedAggregate) OnSomeEvent(event *SomeEvent) {
+	a.items = append(a.items, event.Item)
+}
+
+func (a *SomeRoutedAggregate) OnSomeOtherEvent(event *SomeOtherEvent, em EventMessage) {
+	a.orders = append(a.orders, event.OrderID)
+	a.headers = append(a.headers, em.GetHeaders())
+}
+
+// OnSomething does not follow the convention and is not registered.
+func (a *SomeRoutedAggregate) OnSomething(event *SomeEvent) {
+	panic("not a handler")
+}
+
+func (s *EventRouterSuite) TestOnMethodsAreRegistered(c *C) {
+	agg := NewSomeRoutedAggregate(NewUUID()).(*SomeRoutedAggregate)
+
+	c.Assert(agg.HandlesEvent("SomeEvent"), Equals, true)
+	c.Assert(agg.HandlesEvent("SomeOtherEvent"), Equals, true)
+	c.Assert(agg.CheckHandlers(&SomeEvent{}, &SomeOtherEvent{}), IsNil)
+}
+
+func (s *EventRouterSuite) TestApplyRoutesEventsAndTracksNewEvents(c *C) {
+	id := NewUUID()
+	agg := NewSomeRoutedAggregate(id).(*SomeRoutedAggregate)
+	em := NewEventMessage(id, &SomeOtherEvent{"Some order"}, nil)
+	em.SetHeader("Key", "Value")
+
+	agg.Apply(NewEventMessage(id, &SomeEvent{"Some item", 1}, nil), false)
+	agg.Apply(em, true)
+
+	c.Assert(agg.items, DeepEquals, []string{"Some item"})
+	c.Assert(agg.orders, DeepEquals, []string{"Some order"})
+	c.Assert(agg.headers[0]["Key"], Equals, "Value")
+	c.Assert(agg.GetChanges(), HasLen, 1)
+	c.Assert(agg.CurrentVersion(), Equals, 0)
+}
+
+func (s *EventRouterSuite) TestRegisteredFunctionsTakePrecedence(c *C) {
+	agg := NewSomeRoutedAggregate(NewUUID()).(*SomeRoutedAggregate)
+	router := NewEventRouter()
+	var routed []EventMessage
+	c.Assert(router.On(&SomeEvent{}, func(em EventMessage) { routed = append(routed, em) }), IsNil)
+	c.Assert(router.RegisterMethods(agg), IsNil)
+
+	router.Route(NewEventMessage(NewUUID(), &SomeEvent{"Some item", 1}, nil))
+
+	c.Assert(routed, HasLen, 1)
+	c.Assert(agg.items, HasLen, 0)
+}
+
+func (s *EventRouterSuite) TestDuplicateRegistrationReturnsError(c *C) {
+	router := NewEventRouter()
+	router.On(&SomeEvent{}, func(EventMessage) {})
+
+	err := router.On(&SomeEvent{}, func(EventMessage) {})
+
+	c.Assert(err, ErrorMatches, "Event handler already registered for type: \"SomeEvent\"")
+}
+
+func (s *EventRouterSuite) TestRouteReportsUnhandledEvents(c *C) {
+	router := NewEventRouter()
+
+	c.Assert(router.Route(NewEventMessage(NewUUID(), &SomeEvent{}, nil)), Equals, false)
+}
+
+func (s *EventRouterSuite) TestCheckHandlersReportsMissingHandlers(c *C) {
+	router := NewEventRouter()
+
+	err := router.CheckHandlers(&SomeEvent{}, &SomeOtherEvent{})
+
+	c.Assert(err, FitsTypeOf, &ErrRegistration{})
+	c.Assert(err.(*ErrRegistration).Errors, HasLen, 2)
+	c.Assert(err.(*ErrRegistration).Errors[0], ErrorMatches, "There is no event handler for type: \"SomeEvent\"")
+}
+
+func (s *EventRouterSuite) TestRegistryDetectsMissingHandlers(c *C) {
+	registry := NewTypeRegistry()
+	registry.Aggregate(&SomeRoutedAggregate{}, NewSomeRoutedAggregate).
+		Events(&SomeEvent{}, &SomeRenamedEvent{}).
+		StreamName(func(t, id string) string { return id })
+
+	err := registry.Validate()
+
+	c.Assert(err, FitsTypeOf, &ErrRegistration{})
+	c.Assert(err.(*ErrRegistration).Errors, HasLen, 1)
+	c.Assert(err.(*ErrRegistration).Errors[0], ErrorMatches,
+		"Aggregate type \"SomeRoutedAggregate\" has no handler for event type \"SomeRenamedEvent\".")
+}
+
+func (s *EventRouterSuite) TestRegistryDoesNotInstantiateAggregatesToFindHandlers(c *C) {
+	instantiated := false
+	registry := NewTypeRegistry()
+	registry.Aggregate(&SomeRoutedAggregate{}, func(id string) AggregateRoot {
+		instantiated = true
+		return NewSomeRoutedAggregate(id)
+	}).
+		Events(&SomeEvent{}, &SomeOtherEvent{}).
+		StreamName(func(t, id string) string { return id })
+
+	c.Assert(registry.Validate(), IsNil)
+	c.Assert(instantiated, Equals, false)
+}
+
+func (s *EventRouterSuite) TestRegistryChecksHandlersOfConstructedAggregate(c *C) {
+	agg := NewSomeRoutedAggregate("").(*SomeRoutedAggregate)
+	agg.On(&SomeRenamedEvent{}, func(EventMessage) {})
+	registry := NewTypeRegistry()
+	registry.Aggregate(agg, NewSomeRoutedAggregate).
+		Events(&SomeEvent{}, &SomeRenamedEvent{}).
+		StreamName(func(t, id string) string { return id })
+
+	c.Assert(registry.Validate(), IsNil)
+}
+
+func (s *EventRouterSuite) TestRoutedAggregateSurvivesSaveAndLoad(c *C) {
+	repo, _ := NewInMemoryRepository(NewInternalEventBus())
+	factory := NewDelegateAggregateFactory()
+	factory.RegisterConstructors(NewSomeRoutedAggregate)
+	repo.SetAggregateFactory(factory)
+	id := NewUUID()
+	agg := NewSomeRoutedAggregate(id)
+	agg.Apply(NewEventMessage(id, &SomeEvent{"Some item", 1}, nil), true)
+	c.Assert(repo.Save(agg, nil), IsNil)
+
+	got, err := repo.Load(typeOf(agg), id)
+
+	c.Assert(err, IsNil)
+	c.Assert(got.(*SomeRoutedAggregate).items, DeepEquals, []string{"Some item"})
+	c.Assert(got.OriginalVersion(), Equals, 0)
+}
+
+func (s *EventRouterSuite) TestRaiseEventRoutesAndTracksTheEvent(c *C) {
+	id := NewUUID()
+	agg := NewSomeRoutedAggregate(id).(*SomeRoutedAggregate)
+
+	first := agg.RaiseEvent(&SomeEvent{"Some item", 1})
+	second := agg.RaiseEvent(&SomeOtherEvent{"Some order"})
+
+	c.Assert(agg.items, DeepEquals, []string{"Some item"})
+	c.Assert(agg.orders, DeepEquals, []string{"Some order"})
+	c.Assert(agg.GetChanges(), DeepEquals, []EventMessage{first, second})
+	c.Assert(*first.Version(), Equals, 0)
+	c.Assert(*second.Version(), Equals, 1)
+	c.Assert(first.AggregateID(), Equals, id)
+}
@@ -70,14 +70,23 @@ func init() {
 	//	log.Fatal(err)
 	//}
 
-	// Create an InventoryCommandHandlers instance
-	inventoryCommandHandler := simplecqrs.NewInventoryCommandHandlers(repo)
+	// Create a correlated command handler that makes an InventoryCommandHandlers
+	// instance for each command, with a repository that gives the events saved
+	// the correlation id of the command and the command id as their causation
+	// id. Without it the events carry no correlation or causation headers.
+	inventoryCommandHandler, err := ycq.NewCorrelatedCommandHandler(repo,
+		func(repo ycq.DomainRepository) ycq.CommandHandler {
+			return simplecqrs.NewInventoryCommandHandlers(repo)
+		})
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Create a dispatcher
 	dispatcher = ycq.NewInMemoryDispatcher()
 	// Register the inventory command handlers instance as a command handler
 	// for the events specified.
-	err := dispatcher.RegisterHandler(inventoryCommandHandler,
+	err = dispatcher.RegisterHandler(inventoryCommandHandler,
 		&simplecqrs.CreateInventoryItem{},
 		&simplecqrs.DeactivateInventoryItem{},
 		&simplecqrs.RenameInventoryItem{},
@@ -1,25 +1,21 @@
 package simplecqrs
 
 import (
+	"fmt"
 	"log"
 	"reflect"
 
 	"github.com/jetbasrawi/go.cqrs"
 )
 
-type InventoryItemRepository interface {
-	Load(string, string) (*InventoryItem, error)
-	Save(ycq.AggregateRoot, *int) error
-}
-
 // InventoryCommandHandlers provides methods for processing commands related
 // to inventory items.
 type InventoryCommandHandlers struct {
-	repo InventoryItemRepository
+	repo ycq.DomainRepository
 }
 
 // NewInventoryCommandHandlers contructs a new InventoryCommandHandlers
-func NewInventoryCommandHandlers(repo InventoryItemRepository) *InventoryCommandHandlers {
+func NewInventoryCommandHandlers(repo ycq.DomainRepository) *InventoryCommandHandlers {
 	return &InventoryCommandHandlers{
 		repo: repo,
 	}
@@ -42,7 +38,7 @@ func (h *InventoryCommandHandlers) Handle(message ycq.CommandMessage) error {
 
 	case *DeactivateInventoryItem:
 
-		item, _ = h.repo.Load(reflect.TypeOf(&InventoryItem{}).Elem().Name(), message.AggregateID())
+		item, _ = h.load(message.AggregateID())
 		if err := item.Deactivate(); err != nil {
 			return &ycq.ErrCommandExecution{Command: message, Reason: err.Error()}
 		}
@@ -50,19 +46,19 @@ func (h *InventoryCommandHandlers) Handle(message ycq.CommandMessage) error {
 
 	case *RemoveItemsFromInventory:
 
-		item, _ = h.repo.Load(reflect.TypeOf(&InventoryItem{}).Elem().Name(), message.AggregateID())
+		item, _ = h.load(message.AggregateID())
 		item.Remove(cmd.Count)
 		return h.repo.Save(item, ycq.Int(item.OriginalVersion()))
 
 	case *CheckInItemsToInventory:
 
-		item, _ = h.repo.Load(reflect.TypeOf(&InventoryItem{}).Elem().Name(), message.AggregateID())
+		item, _ = h.load(message.AggregateID())
 		item.CheckIn(cmd.Count)
 		return h.repo.Save(item, ycq.Int(item.OriginalVersion()))
 
 	case *RenameInventoryItem:
 
-		item, _ = h.repo.Load(reflect.TypeOf(&InventoryItem{}).Elem().Name(), message.AggregateID())
+		item, _ = h.load(message.AggregateID())
 		if err := item.ChangeName(cmd.NewName); err != nil {
 			return &ycq.ErrCommandExecution{Command: message, Reason: err.Error()}
 		}
@@ -74,3 +70,18 @@ func (h *InventoryCommandHandlers) Handle(message ycq.CommandMessage) error {
 
 	return nil
 }
+
+// load loads an inventory item. The repository returns an AggregateRoot so
+// the type assertion to *InventoryItem is made here.
+func (h *InventoryCommandHandlers) load(id string) (*InventoryItem, error) {
+	ar, err := h.repo.Load(reflect.TypeOf(&InventoryItem{}).Elem().Name(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	if ret, ok := ar.(*InventoryItem); ok {
+		return ret, nil
+	}
+
+	return nil, fmt.Errorf("Could not cast aggregate returned to type of %s", reflect.TypeOf(&InventoryItem{}).Elem().Name())
+}
@@ -19,7 +19,7 @@ func NewInMemoryRepo(eventBus ycq.EventBus) *InMemoryRepo {
 }
 
 // Load loads an aggregate of the specified type.
-func (r *InMemoryRepo) Load(aggregateType, id string) (*InventoryItem, error) {
+func (r *InMemoryRepo) Load(aggregateType, id string) (ycq.AggregateRoot, error) {
 
 	events, ok := r.current[id]
 	if !ok {
@@ -8,18 +8,18 @@ import (
 
 // InventoryItem is the aggregate for an inventory item.
 type InventoryItem struct {
-	*ycq.AggregateBase
+	*ycq.RoutedAggregateBase
 	activated bool
 	count     int
 }
 
 // NewInventoryItem constructs a new inventory item aggregate.
 //
-// Importantly it embeds a new AggregateBase.
+// Importantly it embeds a new RoutedAggregateBase, which routes events to
+// the On methods of the item.
 func NewInventoryItem(id string) *InventoryItem {
-	i := &InventoryItem{
-		AggregateBase: ycq.NewAggregateBase(id),
-	}
+	i := &InventoryItem{}
+	i.RoutedAggregateBase = ycq.NewRoutedAggregateBase(id, i)
 
 	return i
 }
@@ -30,9 +30,7 @@ func (a *InventoryItem) Create(name string) error {
 		return errors.New("the name can not be empty")
 	}
 
-	a.Apply(ycq.NewEventMessage(a.AggregateID(),
-		&InventoryItemCreated{ID: a.AggregateID(), Name: name},
-		ycq.Int(a.CurrentVersion())), true)
+	a.RaiseEvent(&InventoryItemCreated{ID: a.AggregateID(), Name: name})
 
 	return nil
 }
@@ -43,9 +41,7 @@ func (a *InventoryItem) ChangeName(newName string) error {
 		return errors.New("the name can not be empty")
 	}
 
-	a.Apply(ycq.NewEventMessage(a.AggregateID(),
-		&InventoryItemRenamed{ID: a.AggregateID(), NewName: newName},
-		ycq.Int(a.CurrentVersion())), true)
+	a.RaiseEvent(&InventoryItemRenamed{ID: a.AggregateID(), NewName: newName})
 
 	return nil
 }
@@ -60,9 +56,7 @@ func (a *InventoryItem) Remove(count int) error {
 		return errors.New("can't remove more items from inventory than the number of items in inventory")
 	}
 
-	a.Apply(ycq.NewEventMessage(a.AggregateID(),
-		&ItemsRemovedFromInventory{ID: a.AggregateID(), Count: count},
-		ycq.Int(a.CurrentVersion())), true)
+	a.RaiseEvent(&ItemsRemovedFromInventory{ID: a.AggregateID(), Count: count})
 
 	return nil
 }
@@ -73,9 +67,7 @@ func (a *InventoryItem) CheckIn(count int) error {
 		return errors.New("must have a count greater than 0 to add to inventory")
 	}
 
-	a.Apply(ycq.NewEventMessage(a.AggregateID(),
-		&ItemsCheckedIntoInventory{ID: a.AggregateID(), Count: count},
-		ycq.Int(a.CurrentVersion())), true)
+	a.RaiseEvent(&ItemsCheckedIntoInventory{ID: a.AggregateID(), Count: count})
 
 	return nil
 }
@@ -86,33 +78,43 @@ func (a *InventoryItem) Deactivate() error {
 		return errors.New("already deactivated")
 	}
 
-	a.Apply(ycq.NewEventMessage(a.AggregateID(),
-		&InventoryItemDeactivated{ID: a.AggregateID()},
-		ycq.Int(a.CurrentVersion())), true)
+	a.RaiseEvent(&InventoryItemDeactivated{ID: a.AggregateID()})
 
 	return nil
 }
 
-// Apply handles the logic of events on the aggregate.
-func (a *InventoryItem) Apply(message ycq.EventMessage, isNew bool) {
-	if isNew {
-		a.TrackChange(message)
+// Invariants returns the rules that must hold for the item after every
+// command. They are checked by the repository before the item is saved.
+func (a *InventoryItem) Invariants() []ycq.Invariant {
+	return []ycq.Invariant{
+		{Name: "CountNotNegative", Check: func() error {
+			if a.count < 0 {
+				return errors.New("the count of items in inventory is negative")
+			}
+			return nil
+		}},
 	}
+}
 
-	switch ev := message.Event().(type) {
-
-	case *InventoryItemCreated:
-		a.activated = true
-
-	case *InventoryItemDeactivated:
-		a.activated = false
+// OnInventoryItemCreated activates the item.
+func (a *InventoryItem) OnInventoryItemCreated(event *InventoryItemCreated) {
+	a.activated = true
+}
 
-	case *ItemsRemovedFromInventory:
-		a.count -= ev.Count
+// OnInventoryItemRenamed has no effect on the state of the item.
+func (a *InventoryItem) OnInventoryItemRenamed(event *InventoryItemRenamed) {}
 
-	case *ItemsCheckedIntoInventory:
-		a.count += ev.Count
+// OnInventoryItemDeactivated deactivates the item.
+func (a *InventoryItem) OnInventoryItemDeactivated(event *InventoryItemDeactivated) {
+	a.activated = false
+}
 
-	}
+// OnItemsRemovedFromInventory reduces the count of items.
+func (a *InventoryItem) OnItemsRemovedFromInventory(event *ItemsRemovedFromInventory) {
+	a.count -= event.Count
+}
 
+// OnItemsCheckedIntoInventory increases the count of items.
+func (a *InventoryItem) OnItemsCheckedIntoInventory(event *ItemsCheckedIntoInventory) {
+	a.count += event.Count
 }
@@ -1,7 +1,6 @@
 package simplecqrs
 
 import (
-	"fmt"
 	"reflect"
 
 	"github.com/jetbasrawi/go.cqrs"
@@ -15,9 +14,9 @@ import (
 // specific aggregate type, it is better to do so. There can be quite a lot of
 // repository configuration that is specific to a type and it is cleaner if that
 // code is contained in a specialized repository as shown here.
-// Also because the CommonDomainRepository Load method returns an interface{}, a
-// type assertion is required. Here the type assertion is contained in this specialized
-// repo and a *InventoryItem is returned from the repo.
+// The repository is itself a ycq.DomainRepository, so it can be wrapped, for
+// instance by a ycq.CorrelatedCommandHandler, and the command handlers make
+// the type assertion to *InventoryItem.
 type InventoryItemRepo struct {
 	repo *ycq.GetEventStoreCommonDomainRepo
 }
@@ -34,59 +33,42 @@ func NewInventoryItemRepo(eventStore *goes.Client, eventBus ycq.EventBus) (*Inve
 		repo: r,
 	}
 
-	// An aggregate factory creates an aggregate instance given the name of an aggregate.
-	aggregateFactory := ycq.NewDelegateAggregateFactory()
-	aggregateFactory.RegisterDelegate(&InventoryItem{},
-		func(id string) ycq.AggregateRoot { return NewInventoryItem(id) })
-	ret.repo.SetAggregateFactory(aggregateFactory)
-
-	// A stream name delegate constructs a stream name.
+	// The registry declares the aggregate together with its events and the
+	// way its stream is named, and configures the aggregate factory, event
+	// factory and stream namer of the repository.
+	//
 	// A common way to construct a stream name is to use a bounded context and
-	// an aggregate id.
-	// The interface for a stream name delegate takes a two strings. One may be
-	// the aggregate type and the other the aggregate id. In this case the first
-	// argument and the second argument are concatenated with a hyphen.
-	streamNameDelegate := ycq.NewDelegateStreamNamer()
-	streamNameDelegate.RegisterDelegate(func(t string, id string) string {
-		return t + "-" + id
-	}, &InventoryItem{})
-	ret.repo.SetStreamNameDelegate(streamNameDelegate)
+	// an aggregate id. The stream name delegate takes two strings, the
+	// aggregate type and the aggregate id, which are here concatenated with a
+	// hyphen.
+	registry := ycq.NewTypeRegistry()
+	registry.Aggregate(&InventoryItem{},
+		func(id string) ycq.AggregateRoot { return NewInventoryItem(id) }).
+		Events(
+			&InventoryItemCreated{},
+			&InventoryItemRenamed{},
+			&InventoryItemDeactivated{},
+			&ItemsRemovedFromInventory{},
+			&ItemsCheckedIntoInventory{},
+		).
+		StreamName(func(t string, id string) string { return t + "-" + id })
 
-	// An event factory creates an instance of an event given the name of an event
-	// as a string.
-	eventFactory := ycq.NewDelegateEventFactory()
-	eventFactory.RegisterDelegate(&InventoryItemCreated{},
-		func() interface{} { return &InventoryItemCreated{} })
-	eventFactory.RegisterDelegate(&InventoryItemRenamed{},
-		func() interface{} { return &InventoryItemRenamed{} })
-	eventFactory.RegisterDelegate(&InventoryItemDeactivated{},
-		func() interface{} { return &InventoryItemDeactivated{} })
-	eventFactory.RegisterDelegate(&ItemsRemovedFromInventory{},
-		func() interface{} { return &ItemsRemovedFromInventory{} })
-	eventFactory.RegisterDelegate(&ItemsCheckedIntoInventory{},
-		func() interface{} { return &ItemsCheckedIntoInventory{} })
-	ret.repo.SetEventFactory(eventFactory)
+	if err := registry.ConfigureRepository(ret.repo); err != nil {
+		return nil, err
+	}
 
 	return ret, nil
 }
 
 // Load loads events for an aggregate.
 //
-// Returns an *InventoryAggregate.
-func (r *InventoryItemRepo) Load(aggregateType, id string) (*InventoryItem, error) {
+// Returns an *InventoryItem.
+func (r *InventoryItemRepo) Load(aggregateType, id string) (ycq.AggregateRoot, error) {
 	ar, err := r.repo.Load(reflect.TypeOf(&InventoryItem{}).Elem().Name(), id)
 	if _, ok := err.(*ycq.ErrAggregateNotFound); ok {
 		return nil, nil
 	}
-	if err != nil {
-		return nil, err
-	}
-
-	if ret, ok := ar.(*InventoryItem); ok {
-		return ret, nil
-	}
-
-	return nil, fmt.Errorf("Could not cast aggregate returned to type of %s", reflect.TypeOf(&InventoryItem{}).Elem().Name())
+	return ar, err
 }
 
 // Save persists an aggregate.
@@ -0,0 +1,30 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+// EventFeed is implemented by stores that can read all of their events in a
+// single global order, such as the order in which they were written.
+type EventFeed interface {
+
+	// ReadFeed returns the events at up to max positions of the feed starting
+	// at the position specified, in order. An empty slice is returned when
+	// there are no events at or after the position.
+	//
+	// Several events may share a position where an upcaster splits an event.
+	ReadFeed(from int, max int) ([]*FeedEvent, error)
+}
+
+// FeedHead is implemented by feeds that can report the position that follows
+// their last event, so that the progress of reading the feed can be measured.
+type FeedHead interface {
+	FeedHead() (int, error)
+}
+
+// FeedEvent is an event read from an EventFeed with its position in the feed.
+type FeedEvent struct {
+	Position int
+	Event    EventMessage
+}
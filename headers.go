@@ -0,0 +1,150 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// AggregateIDHeader is the key of the header that holds the id of the
+// aggregate an event belongs to.
+const AggregateIDHeader = "AggregateID"
+
+// HeaderRegistry holds the types of event headers so that headers read from
+// the store have the same types as when they were saved.
+//
+// Headers are persisted as JSON. A header whose key is not registered is
+// decoded as JSON is decoded into an interface{}, so numbers are read as
+// float64 and objects as map[string]interface{}. Registering the type of a
+// header decodes it into a value of that type instead.
+type HeaderRegistry struct {
+	types map[string]reflect.Type
+}
+
+// NewHeaderRegistry constructs a new HeaderRegistry with the headers used by
+// this package registered.
+func NewHeaderRegistry() *HeaderRegistry {
+	r := &HeaderRegistry{
+		types: make(map[string]reflect.Type),
+	}
+	r.Register(AggregateIDHeader, "")
+	r.Register(SchemaVersionHeader, 0)
+	r.Register(ContentTypeHeader, "")
+	r.Register(ContentEncodingHeader, "")
+	r.Register(ClaimCheckHeader, "")
+	r.Register(EventIDHeader, "")
+	r.Register(OccurredAtHeader, time.Time{})
+	r.Register(CorrelationIDHeader, "")
+	r.Register(CausationIDHeader, "")
+	r.Register(EntityIDHeader, "")
+	return r
+}
+
+// Register registers the type of the header specified. The type is given by
+// an example value of the type, for instance time.Time{}, which must not be
+// nil.
+func (r *HeaderRegistry) Register(key string, value interface{}) error {
+	if value == nil {
+		return fmt.Errorf("Nil value registered for header key: \"%s\"", key)
+	}
+	if _, ok := r.types[key]; ok {
+		return fmt.Errorf("Header type already registered for key: \"%s\"", key)
+	}
+	r.types[key] = reflect.TypeOf(value)
+	return nil
+}
+
+// Decode decodes JSON encoded headers, giving registered headers their
+// registered types.
+func (r *HeaderRegistry) Decode(data []byte) (map[string]interface{}, error) {
+	headers := make(map[string]interface{})
+	if len(data) == 0 {
+		return headers, nil
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	for k, v := range raw {
+		t, ok := r.types[k]
+		if !ok {
+			var value interface{}
+			if err := json.Unmarshal(v, &value); err != nil {
+				return nil, err
+			}
+			headers[k] = value
+			continue
+		}
+
+		value := reflect.New(t)
+		if err := json.Unmarshal(v, value.Interface()); err != nil {
+			return nil, fmt.Errorf("Header %s can not be decoded as %s. %s", k, t, err)
+		}
+		headers[k] = value.Elem().Interface()
+	}
+	return headers, nil
+}
+
+// HeaderString returns the value of a string header.
+func HeaderString(em EventMessage, key string) (string, bool) {
+	v, ok := em.GetHeaders()[key].(string)
+	return v, ok
+}
+
+// stringHeader returns the value of a string header, or an empty string if
+// the header is not set or is not a string.
+func stringHeader(headers map[string]interface{}, key string) string {
+	v, _ := headers[key].(string)
+	return v
+}
+
+// HeaderInt returns the value of an integer header. Integers decoded without
+// a registered type, as float64 or json.Number, are also returned.
+func HeaderInt(em EventMessage, key string) (int, bool) {
+	switch v := em.GetHeaders()[key].(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), true
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i), true
+		}
+	}
+	return 0, false
+}
+
+// HeaderTime returns the value of a time header. Times decoded without a
+// registered type, as RFC 3339 strings, are also returned.
+func HeaderTime(em EventMessage, key string) (time.Time, bool) {
+	switch v := em.GetHeaders()[key].(type) {
+	case time.Time:
+		return v, true
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// SchemaVersionOf returns the schema version of an event. Events without a
+// SchemaVersion header are at schema version 1.
+func SchemaVersionOf(em EventMessage) int {
+	if v, ok := HeaderInt(em, SchemaVersionHeader); ok {
+		return v
+	}
+	return 1
+}
@@ -0,0 +1,139 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&HeaderRegistrySuite{})
+
+type HeaderRegistrySuite struct {
+	registry *HeaderRegistry
+}
+
+func (s *HeaderRegistrySuite) SetUpTest(c *C) {
+	s.registry = NewHeaderRegistry()
+}
+
+// TypedHeaders returns headers of several types used to test that headers
+// survive persistence with their types.
+func TypedHeaders() map[string]interface{} {
+	return map[string]interface{}{
+		AggregateIDHeader:   NewUUID(),
+		EventIDHeader:       NewUUID(),
+		OccurredAtHeader:    time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC),
+		SchemaVersionHeader: 3,
+		"Occurred":          time.Date(2016, 6, 1, 12, 30, 0, 500, time.UTC),
+		"Retries":           int64(2),
+		"Origin":            HeaderOrigin{Host: "web-1", Port: 8080},
+	}
+}
+
+// RegisterTypedHeaders registers the custom headers returned by TypedHeaders.
+func RegisterTypedHeaders(registry *HeaderRegistry) {
+	registry.Register("Occurred", time.Time{})
+	registry.Register("Retries", int64(0))
+	registry.Register("Origin", HeaderOrigin{})
+}
+
+type HeaderOrigin struct {
+	Host string
+	Port int
+}
+
+func (s *HeaderRegistrySuite) TestRegisteredHeadersKeepTheirTypes(c *C) {
+	RegisterTypedHeaders(s.registry)
+	headers := TypedHeaders()
+	data, _ := json.Marshal(headers)
+
+	got, err := s.registry.Decode(data)
+
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, headers)
+}
+
+func (s *HeaderRegistrySuite) TestUnregisteredHeadersAreDecodedAsJSON(c *C) {
+	got, err := s.registry.Decode([]byte(`{"Count":3,"Name":"a","Nested":{"A":true}}`))
+
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, map[string]interface{}{
+		"Count":  float64(3),
+		"Name":   "a",
+		"Nested": map[string]interface{}{"A": true},
+	})
+}
+
+func (s *HeaderRegistrySuite) TestEmptyHeaders(c *C) {
+	got, err := s.registry.Decode(nil)
+
+	c.Assert(err, IsNil)
+	c.Assert(got, HasLen, 0)
+}
+
+func (s *HeaderRegistrySuite) TestHeaderOfTheWrongTypeReturnsAnError(c *C) {
+	_, err := s.registry.Decode([]byte(`{"SchemaVersion":"two"}`))
+
+	c.Assert(err, ErrorMatches, "Header SchemaVersion can not be decoded as int.*")
+}
+
+func (s *HeaderRegistrySuite) TestDuplicateRegistrationReturnsAnError(c *C) {
+	err := s.registry.Register(SchemaVersionHeader, 0)
+
+	c.Assert(err, DeepEquals, fmt.Errorf("Header type already registered for key: \"SchemaVersion\""))
+}
+
+func (s *HeaderRegistrySuite) TestNilRegistrationReturnsAnError(c *C) {
+	err := s.registry.Register("Origin", nil)
+
+	c.Assert(err, DeepEquals, fmt.Errorf("Nil value registered for header key: \"Origin\""))
+	_, err = s.registry.Decode([]byte(`{"Origin":{}}`))
+	c.Assert(err, IsNil)
+}
+
+func (s *HeaderRegistrySuite) TestTypedAccessors(c *C) {
+	occurred := time.Date(2016, 6, 1, 12, 30, 0, 0, time.UTC)
+	em := NewEventMessage(NewUUID(), &SomeEvent{}, nil)
+	em.SetHeader("Name", "a")
+	em.SetHeader("Count", float64(3))
+	em.SetHeader("Ratio", 0.5)
+	em.SetHeader("Occurred", occurred)
+	em.SetHeader("OccurredString", occurred.Format(time.RFC3339Nano))
+
+	name, ok := HeaderString(em, "Name")
+	c.Assert(ok, Equals, true)
+	c.Assert(name, Equals, "a")
+
+	count, ok := HeaderInt(em, "Count")
+	c.Assert(ok, Equals, true)
+	c.Assert(count, Equals, 3)
+
+	_, ok = HeaderInt(em, "Ratio")
+	c.Assert(ok, Equals, false)
+
+	t, ok := HeaderTime(em, "Occurred")
+	c.Assert(ok, Equals, true)
+	c.Assert(t, Equals, occurred)
+
+	t, ok = HeaderTime(em, "OccurredString")
+	c.Assert(ok, Equals, true)
+	c.Assert(t.Equal(occurred), Equals, true)
+
+	_, ok = HeaderString(em, "Missing")
+	c.Assert(ok, Equals, false)
+}
+
+func (s *HeaderRegistrySuite) TestSchemaVersionOf(c *C) {
+	em := NewEventMessage(NewUUID(), &SomeEvent{}, nil)
+	c.Assert(SchemaVersionOf(em), Equals, 1)
+
+	em.SetHeader(SchemaVersionHeader, 2)
+	c.Assert(SchemaVersionOf(em), Equals, 2)
+}
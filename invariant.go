@@ -0,0 +1,104 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+// Invariant is a rule about the state of an aggregate that must hold after
+// every command.
+//
+// Check returns nil if the invariant holds, or an error describing how it is
+// broken.
+type Invariant struct {
+	Name  string
+	Check func() error
+}
+
+// InvariantChecker is implemented by aggregates that declare invariants.
+//
+// The repositories check the invariants of an aggregate before its changes
+// are persisted. If any invariant is broken the changes are discarded and an
+// *ErrInvariantViolation is returned.
+//
+// Discarding the changes does not undo their effect on the state of the
+// aggregate, which was changed as they were applied. An aggregate instance
+// that breaks its invariants must not be used again; load it afresh from the
+// repository instead.
+//
+//	func (a *InventoryItem) Invariants() []ycq.Invariant {
+//		return []ycq.Invariant{
+//			{Name: "CountNotNegative", Check: func() error {
+//				if a.count < 0 {
+//					return errors.New("the count is negative")
+//				}
+//				return nil
+//			}},
+//		}
+//	}
+type InvariantChecker interface {
+	Invariants() []Invariant
+}
+
+// InvariantViolation describes a broken invariant.
+type InvariantViolation struct {
+	Invariant string
+	Err       error
+}
+
+// CheckInvariants checks the invariants of an aggregate, if it declares any,
+// and returns an *ErrInvariantViolation listing every invariant that is
+// broken.
+func CheckInvariants(aggregate AggregateRoot) error {
+	checker, ok := aggregate.(InvariantChecker)
+	if !ok {
+		return nil
+	}
+
+	var violations []InvariantViolation
+	for _, v := range checker.Invariants() {
+		if v.Check == nil {
+			continue
+		}
+		if err := v.Check(); err != nil {
+			violations = append(violations, InvariantViolation{Invariant: v.Name, Err: err})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &ErrInvariantViolation{
+			AggregateType: typeOf(aggregate),
+			AggregateID:   aggregate.AggregateID(),
+			Violations:    violations,
+		}
+	}
+	return nil
+}
+
+// checkInvariants checks the invariants of an aggregate before it is
+// persisted. If any are broken the changes of the aggregate are discarded.
+func checkInvariants(aggregate AggregateRoot) error {
+	if err := CheckInvariants(aggregate); err != nil {
+		aggregate.ClearChanges()
+		return err
+	}
+	return nil
+}
+
+// checkAllInvariants checks the invariants of several aggregates before any
+// of them are persisted. The changes of each aggregate whose invariants are
+// broken are discarded, and the violations of all of them are returned
+// together in an *ErrInvariantViolations.
+func checkAllInvariants(aggregates []AggregateRoot) error {
+	var violations []*ErrInvariantViolation
+	for _, v := range aggregates {
+		if err := checkInvariants(v); err != nil {
+			violations = append(violations, err.(*ErrInvariantViolation))
+		}
+	}
+
+	if len(violations) > 0 {
+		return &ErrInvariantViolations{Violations: violations}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"errors"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&InvariantSuite{})
+
+type InvariantSuite struct{}
+
+// SomeInvariantAggregate is an aggregate whose count must not be negative
+// and must not exceed ten.
+type SomeInvariantAggregate struct {
+	*AggregateBase
+	count  int
+	checks int
+}
+
+func NewSomeInvariantAggregate(id string) *SomeInvariantAggregate {
+	return &SomeInvariantAggregate{AggregateBase: NewAggregateBase(id)}
+}
+
+func (a *SomeInvariantAggregate) Apply(em EventMessage, isNew bool) {
+	if isNew {
+		a.TrackChange(em)
+	}
+	if ev, ok := em.Event().(*SomeEvent); ok {
+		a.count += ev.Count
+	}
+}
+
+func (a *SomeInvariantAggregate) Invariants() []Invariant {
+	return []Invariant{
+		{Name: "NotNegative", Check: func() error {
+			a.checks++
+			if a.count < 0 {
+				return errors.New("the count is negative")
+			}
+			return nil
+		}},
+		{Name: "AtMostTen", Check: func() error {
+			if a.count > 10 {
+				return errors.New("the count is more than ten")
+			}
+			return nil
+		}},
+		{Name: "NotThirteen", Check: func() error {
+			if a.count == 13 {
+				return errors.New("the count is thirteen")
+			}
+			return nil
+		}},
+	}
+}
+
+func (s *InvariantSuite) TestAggregatesWithoutInvariantsPass(c *C) {
+	c.Assert(CheckInvariants(NewSomeAggregate(NewUUID())), IsNil)
+}
+
+func (s *InvariantSuite) TestHoldingInvariantsPass(c *C) {
+	agg := NewSomeInvariantAggregate(NewUUID())
+	agg.RaiseEvent(agg, &SomeEvent{"Some data", 4})
+
+	c.Assert(CheckInvariants(agg), IsNil)
+}
+
+func (s *InvariantSuite) TestEveryBrokenInvariantIsListed(c *C) {
+	id := NewUUID()
+	agg := NewSomeInvariantAggregate(id)
+	agg.RaiseEvent(agg, &SomeEvent{"Some data", 13})
+
+	err := CheckInvariants(agg)
+
+	c.Assert(err, DeepEquals, &ErrInvariantViolation{
+		AggregateType: "SomeInvariantAggregate",
+		AggregateID:   id,
+		Violations: []InvariantViolation{
+			{Invariant: "AtMostTen", Err: errors.New("the count is more than ten")},
+			{Invariant: "NotThirteen", Err: errors.New("the count is thirteen")},
+		},
+	})
+	c.Assert(err, ErrorMatches, "The aggregate of type SomeInvariantAggregate with id "+id+
+		" breaks 2 invariants. AtMostTen: the count is more than ten. NotThirteen: the count is thirteen.")
+}
+
+func (s *InvariantSuite) TestSaveWithBrokenInvariantsIsAbortedAndChangesDiscarded(c *C) {
+	repo, _ := NewInMemoryRepository(NewInternalEventBus())
+	id := NewUUID()
+	agg := NewSomeInvariantAggregate(id)
+	agg.RaiseEvent(agg, &SomeEvent{"Some data", -1})
+
+	err := repo.Save(agg, nil)
+
+	c.Assert(err, FitsTypeOf, &ErrInvariantViolation{})
+	c.Assert(agg.GetChanges(), HasLen, 0)
+	_, _, err = repo.read(typeOf(agg), id)
+	c.Assert(err, FitsTypeOf, &ErrAggregateNotFound{})
+}
+
+func (s *InvariantSuite) TestSaveChecksInvariantsOnce(c *C) {
+	repo, _ := NewInMemoryRepository(NewInternalEventBus())
+	agg := NewSomeInvariantAggregate(NewUUID())
+	agg.RaiseEvent(agg, &SomeEvent{"Some data", 1})
+
+	err := repo.Save(agg, nil)
+
+	c.Assert(err, IsNil)
+	c.Assert(agg.checks, Equals, 1)
+}
+
+func (s *InvariantSuite) TestSaveAllSavesNothingIfAnyAggregateBreaksInvariants(c *C) {
+	repo, _ := NewInMemoryRepository(NewInternalEventBus())
+	valid := NewSomeInvariantAggregate(NewUUID())
+	valid.RaiseEvent(valid, &SomeEvent{"Some data", 1})
+	invalid := NewSomeInvariantAggregate(NewUUID())
+	invalid.RaiseEvent(invalid, &SomeEvent{"Some data", 11})
+
+	err := repo.SaveAll([]AggregateRoot{valid, invalid}, []*int{nil, nil})
+
+	c.Assert(err, FitsTypeOf, &ErrInvariantViolations{})
+	c.Assert(valid.GetChanges(), HasLen, 1)
+	c.Assert(invalid.GetChanges(), HasLen, 0)
+	_, _, err = repo.read(typeOf(valid), valid.AggregateID())
+	c.Assert(err, FitsTypeOf, &ErrAggregateNotFound{})
+}
+
+func (s *InvariantSuite) TestUnitOfWorkChecksInvariantsBeforeSavingAny(c *C) {
+	repo := &FakeSingleSaveRepository{fail: -1}
+	uow := NewUnitOfWork(repo)
+	valid := NewSomeInvariantAggregate(NewUUID())
+	valid.RaiseEvent(valid, &SomeEvent{"Some data", 1})
+	invalid := NewSomeInvariantAggregate(NewUUID())
+	invalid.RaiseEvent(invalid, &SomeEvent{"Some data", -1})
+	uow.Track(valid)
+	uow.Track(invalid)
+
+	err := uow.Commit()
+
+	c.Assert(err, FitsTypeOf, &ErrInvariantViolations{})
+	c.Assert(repo.saved, HasLen, 0)
+}
+
+func (s *InvariantSuite) TestSaveAllReturnsTheViolationsOfEveryAggregate(c *C) {
+	repo, _ := NewInMemoryRepository(NewInternalEventBus())
+	first := NewSomeInvariantAggregate(NewUUID())
+	first.RaiseEvent(first, &SomeEvent{"Some data", -1})
+	valid := NewSomeInvariantAggregate(NewUUID())
+	valid.RaiseEvent(valid, &SomeEvent{"Some data", 1})
+	second := NewSomeInvariantAggregate(NewUUID())
+	second.RaiseEvent(second, &SomeEvent{"Some data", 11})
+
+	err := repo.SaveAll([]AggregateRoot{first, valid, second}, []*int{nil, nil, nil})
+
+	c.Assert(err, FitsTypeOf, &ErrInvariantViolations{})
+	violations := err.(*ErrInvariantViolations).Violations
+	c.Assert(violations, HasLen, 2)
+	c.Assert(violations[0].AggregateID, Equals, first.AggregateID())
+	c.Assert(violations[1].AggregateID, Equals, second.AggregateID())
+	c.Assert(first.GetChanges(), HasLen, 0)
+	c.Assert(second.GetChanges(), HasLen, 0)
+}
@@ -0,0 +1,209 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// keySize is the size in bytes of the AES-256 keys created by the key stores.
+const keySize = 32
+
+// KeyStore is the interface that a store of per subject encryption keys must
+// implement.
+type KeyStore interface {
+
+	// Key returns the key of the subject specified. If the subject has no
+	// key, an *ErrKeyNotFound is returned.
+	Key(subjectID string) ([]byte, error)
+
+	// CreateKey returns the key of the subject specified, creating a new key
+	// if the subject does not have one. If the key of the subject has been
+	// deleted an *ErrKeyDeleted is returned, so that personal data of a
+	// shredded subject is never written again under a new key.
+	CreateKey(subjectID string) ([]byte, error)
+
+	// DeleteKey deletes the key of the subject specified and records that it
+	// was deleted. Deleting a key that does not exist is not an error.
+	DeleteKey(subjectID string) error
+}
+
+// MemoryKeyStore is a KeyStore that holds keys in memory.
+type MemoryKeyStore struct {
+	mu      sync.Mutex
+	keys    map[string][]byte
+	deleted map[string]bool
+}
+
+// NewMemoryKeyStore constructs a new MemoryKeyStore.
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{
+		keys:    make(map[string][]byte),
+		deleted: make(map[string]bool),
+	}
+}
+
+// Key returns the key of a subject.
+func (s *MemoryKeyStore) Key(subjectID string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[subjectID]
+	if !ok {
+		return nil, &ErrKeyNotFound{SubjectID: subjectID}
+	}
+	return key, nil
+}
+
+// CreateKey returns the key of a subject, creating it if necessary.
+func (s *MemoryKeyStore) CreateKey(subjectID string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.keys[subjectID]; ok {
+		return key, nil
+	}
+	if s.deleted[subjectID] {
+		return nil, &ErrKeyDeleted{SubjectID: subjectID}
+	}
+
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	s.keys[subjectID] = key
+	return key, nil
+}
+
+// DeleteKey deletes the key of a subject.
+func (s *MemoryKeyStore) DeleteKey(subjectID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keys, subjectID)
+	s.deleted[subjectID] = true
+	return nil
+}
+
+// FileKeyStore is a KeyStore that holds each key in a file in a directory.
+//
+// Deleting a key removes its file and leaves an empty tombstone file in its
+// place. Backups of the directory must be managed so that deleted keys do not
+// survive in them.
+type FileKeyStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// NewFileKeyStore constructs a new FileKeyStore that holds keys in the
+// directory specified. The directory is created if it does not exist.
+func NewFileKeyStore(dir string) (*FileKeyStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &FileKeyStore{
+		dir: dir,
+	}, nil
+}
+
+// Key returns the key of a subject.
+func (s *FileKeyStore) Key(subjectID string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.read(subjectID)
+}
+
+// CreateKey returns the key of a subject, creating it if necessary.
+func (s *FileKeyStore) CreateKey(subjectID string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, err := s.read(subjectID)
+	if _, ok := err.(*ErrKeyNotFound); !ok {
+		return key, err
+	}
+	if _, err := os.Stat(s.tombstone(subjectID)); err == nil {
+		return nil, &ErrKeyDeleted{SubjectID: subjectID}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err = newKey()
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, "key-")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Write(key); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), s.path(subjectID)); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return key, nil
+}
+
+// DeleteKey deletes the key of a subject.
+func (s *FileKeyStore) DeleteKey(subjectID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The tombstone is written first, so that a key is never removed without
+	// one.
+	if err := ioutil.WriteFile(s.tombstone(subjectID), nil, 0600); err != nil {
+		return err
+	}
+	err := os.Remove(s.path(subjectID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *FileKeyStore) read(subjectID string) ([]byte, error) {
+	key, err := ioutil.ReadFile(s.path(subjectID))
+	if os.IsNotExist(err) {
+		return nil, &ErrKeyNotFound{SubjectID: subjectID}
+	}
+	return key, err
+}
+
+// path returns the name of the file holding the key of a subject. Subject
+// ids are hex encoded so that any id is a valid file name.
+func (s *FileKeyStore) path(subjectID string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(subjectID))+".key")
+}
+
+// tombstone returns the name of the file recording that the key of a subject
+// has been deleted.
+func (s *FileKeyStore) tombstone(subjectID string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(subjectID))+".deleted")
+}
+
+func newKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
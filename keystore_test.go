@@ -0,0 +1,102 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&KeyStoreSuite{})
+
+type KeyStoreSuite struct {
+	stores []KeyStore
+}
+
+func (s *KeyStoreSuite) SetUpTest(c *C) {
+	fileStore, err := NewFileKeyStore(c.MkDir())
+	c.Assert(err, IsNil)
+	s.stores = []KeyStore{NewMemoryKeyStore(), fileStore}
+}
+
+func (s *KeyStoreSuite) TestKeyOfUnknownSubjectReturnsErrKeyNotFound(c *C) {
+	for _, store := range s.stores {
+		key, err := store.Key("subject")
+
+		c.Assert(key, IsNil)
+		c.Assert(err, DeepEquals, &ErrKeyNotFound{SubjectID: "subject"})
+	}
+}
+
+func (s *KeyStoreSuite) TestCreateKeyReturnsTheSameKeyForASubject(c *C) {
+	for _, store := range s.stores {
+		key, err := store.CreateKey("subject")
+		c.Assert(err, IsNil)
+		c.Assert(key, HasLen, 32)
+
+		again, err := store.CreateKey("subject")
+		c.Assert(err, IsNil)
+		c.Assert(again, DeepEquals, key)
+
+		got, err := store.Key("subject")
+		c.Assert(err, IsNil)
+		c.Assert(got, DeepEquals, key)
+
+		other, err := store.CreateKey("other/subject")
+		c.Assert(err, IsNil)
+		c.Assert(other, Not(DeepEquals), key)
+	}
+}
+
+func (s *KeyStoreSuite) TestDeleteKey(c *C) {
+	for _, store := range s.stores {
+		_, err := store.CreateKey("subject")
+		c.Assert(err, IsNil)
+
+		c.Assert(store.DeleteKey("subject"), IsNil)
+		c.Assert(store.DeleteKey("subject"), IsNil)
+
+		_, err = store.Key("subject")
+		c.Assert(err, DeepEquals, &ErrKeyNotFound{SubjectID: "subject"})
+	}
+}
+
+func (s *KeyStoreSuite) TestDeletedKeysAreNotCreatedAgain(c *C) {
+	for _, store := range s.stores {
+		store.CreateKey("subject")
+		c.Assert(store.DeleteKey("subject"), IsNil)
+
+		_, err := store.CreateKey("subject")
+
+		c.Assert(err, DeepEquals, &ErrKeyDeleted{SubjectID: "subject"})
+		_, err = store.Key("subject")
+		c.Assert(err, DeepEquals, &ErrKeyNotFound{SubjectID: "subject"})
+	}
+}
+
+func (s *KeyStoreSuite) TestFileKeyStoreTombstonesSurviveANewInstance(c *C) {
+	dir := c.MkDir()
+	store, _ := NewFileKeyStore(dir)
+	store.CreateKey("subject")
+	store.DeleteKey("subject")
+
+	again, _ := NewFileKeyStore(dir)
+	_, err := again.CreateKey("subject")
+
+	c.Assert(err, DeepEquals, &ErrKeyDeleted{SubjectID: "subject"})
+}
+
+func (s *KeyStoreSuite) TestFileKeyStoreKeysSurviveANewInstance(c *C) {
+	dir := c.MkDir()
+	store, _ := NewFileKeyStore(dir)
+	key, err := store.CreateKey("subject")
+	c.Assert(err, IsNil)
+
+	store, _ = NewFileKeyStore(dir)
+	got, err := store.Key("subject")
+
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, key)
+}
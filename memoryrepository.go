@@ -0,0 +1,395 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// InMemoryRepository is an implementation of the DomainRepository that holds
+// events in memory.
+//
+// Events do not survive a restart of the process so the InMemoryRepository
+// is mostly useful for testing and for prototyping.
+type InMemoryRepository struct {
+	mu               sync.RWMutex
+	eventBus         EventBus
+	aggregateFactory AggregateFactory
+	encryptor        *PersonalDataEncryptor
+	archive          Archive
+	streams          map[string][]EventMessage
+	truncated        map[string]int
+	tombstones       map[string]bool
+	feed             []EventMessage
+}
+
+// NewInMemoryRepository constructs a new InMemoryRepository.
+func NewInMemoryRepository(eventBus EventBus) (*InMemoryRepository, error) {
+	if eventBus == nil {
+		return nil, fmt.Errorf("Nil EventBus injected into repository.")
+	}
+
+	return &InMemoryRepository{
+		eventBus:   eventBus,
+		streams:    make(map[string][]EventMessage),
+		truncated:  make(map[string]int),
+		tombstones: make(map[string]bool),
+	}, nil
+}
+
+// SetAggregateFactory sets the aggregate factory that should be used to
+// instantate aggregate instances
+func (r *InMemoryRepository) SetAggregateFactory(factory AggregateFactory) {
+	r.aggregateFactory = factory
+}
+
+// SetPersonalDataEncryptor sets the encryptor used to encrypt the personal
+// data fields of events as they are saved and decrypt them as they are loaded.
+//
+// Events are published on the EventBus with their personal data decrypted.
+func (r *InMemoryRepository) SetPersonalDataEncryptor(encryptor *PersonalDataEncryptor) {
+	r.encryptor = encryptor
+}
+
+// SetArchive sets the archive that the events of a stream are written to
+// before the stream is deleted.
+func (r *InMemoryRepository) SetArchive(archive Archive) {
+	r.archive = archive
+}
+
+// Load applies all events for the aggregate specified to a new aggregate
+// instance.
+func (r *InMemoryRepository) Load(aggregateType, id string) (AggregateRoot, error) {
+
+	if r.aggregateFactory == nil {
+		return nil, fmt.Errorf("The in memory repository has no Aggregate Factory.")
+	}
+
+	aggregate := r.aggregateFactory.GetAggregate(aggregateType, id)
+	if aggregate == nil {
+		return nil, fmt.Errorf("The repository has no aggregate factory registered for aggregate type: %s", aggregateType)
+	}
+
+	events, from, err := r.read(aggregateType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// The version of a stream that has been deleted and written to again
+	// continues from before it was deleted.
+	for k := 0; k < from; k++ {
+		aggregate.IncrementVersion()
+	}
+	for _, v := range events[from:] {
+		em, err := r.decrypt(v)
+		if err != nil {
+			return nil, err
+		}
+		aggregate.Apply(em, false)
+		aggregate.IncrementVersion()
+	}
+
+	return aggregate, nil
+}
+
+// CatchUp applies to an aggregate the events that have been saved since the
+// aggregate was loaded.
+func (r *InMemoryRepository) CatchUp(aggregate AggregateRoot) error {
+	events, from, err := r.read(typeOf(aggregate), aggregate.AggregateID())
+	if err != nil {
+		return err
+	}
+	if aggregate.OriginalVersion()+1 < from {
+		return &ErrAggregateDeleted{AggregateType: typeOf(aggregate), AggregateID: aggregate.AggregateID()}
+	}
+
+	for k := aggregate.OriginalVersion() + 1; k < len(events); k++ {
+		em, err := r.decrypt(events[k])
+		if err != nil {
+			return err
+		}
+		aggregate.Apply(em, false)
+		aggregate.IncrementVersion()
+	}
+
+	return nil
+}
+
+// Save persists an aggregate.
+//
+// If the aggregate breaks any of its invariants its changes are discarded and
+// an *ErrInvariantViolation is returned.
+func (r *InMemoryRepository) Save(aggregate AggregateRoot, expectedVersion *int) error {
+	if err := checkInvariants(aggregate); err != nil {
+		return err
+	}
+	return r.save([]AggregateRoot{aggregate}, []*int{expectedVersion})
+}
+
+// SaveAll persists the changes of several aggregates atomically.
+//
+// The expected versions of all of the aggregates are checked before any
+// changes are persisted. Either all of the changes are persisted or, if any
+// expected version does not match, none of them are. Events are published once
+// all of the changes have been persisted.
+//
+// If any aggregate breaks its invariants nothing is persisted, the changes of
+// the aggregates that break their invariants are discarded and an
+// *ErrInvariantViolations listing all of them is returned.
+func (r *InMemoryRepository) SaveAll(aggregates []AggregateRoot, expectedVersions []*int) error {
+	if len(aggregates) != len(expectedVersions) {
+		return fmt.Errorf("The number of expected versions does not match the number of aggregates.")
+	}
+
+	if err := checkAllInvariants(aggregates); err != nil {
+		return err
+	}
+	return r.save(aggregates, expectedVersions)
+}
+
+// save persists the changes of aggregates whose invariants have been checked.
+func (r *InMemoryRepository) save(aggregates []AggregateRoot, expectedVersions []*int) error {
+	r.mu.Lock()
+
+	for k, aggregate := range aggregates {
+		key := streamKey(typeOf(aggregate), aggregate.AggregateID())
+		if r.tombstones[key] {
+			r.mu.Unlock()
+			return &ErrAggregateDeleted{AggregateType: typeOf(aggregate), AggregateID: aggregate.AggregateID()}
+		}
+		expectedVersion := expectedVersions[k]
+		if expectedVersion != nil && *expectedVersion != len(r.streams[key])-1 {
+			r.mu.Unlock()
+			return &ErrConcurrencyViolation{Aggregate: aggregate, ExpectedVersion: expectedVersion, StreamName: key}
+		}
+	}
+
+	streams := make(map[string][]EventMessage)
+	var held, published []EventMessage
+	for _, aggregate := range aggregates {
+		key := streamKey(typeOf(aggregate), aggregate.AggregateID())
+		if _, ok := streams[key]; !ok {
+			streams[key] = r.streams[key]
+		}
+		for _, v := range aggregate.GetChanges() {
+			stampEvent(v)
+			version := Int(len(streams[key]))
+			stored, err := r.encrypt(aggregate.AggregateID(), v.Event())
+			if err != nil {
+				r.mu.Unlock()
+				return err
+			}
+			em := NewEventMessage(aggregate.AggregateID(), v.Event(), version)
+			sm := NewEventMessage(aggregate.AggregateID(), stored, version)
+			for h, value := range v.GetHeaders() {
+				em.SetHeader(h, value)
+				sm.SetHeader(h, value)
+			}
+			streams[key] = append(streams[key], sm)
+			held = append(held, sm)
+			published = append(published, em)
+		}
+	}
+
+	for k, v := range streams {
+		r.streams[k] = v
+	}
+	r.feed = append(r.feed, held...)
+	for _, aggregate := range aggregates {
+		aggregate.ClearChanges()
+	}
+
+	r.mu.Unlock()
+
+	for _, v := range published {
+		r.eventBus.PublishEvent(v)
+	}
+
+	return nil
+}
+
+// Delete soft deletes the stream of an aggregate. If an archive is set the
+// events of the stream are archived first.
+func (r *InMemoryRepository) Delete(aggregateType, id string) error {
+	return r.delete(aggregateType, id, false)
+}
+
+// Tombstone hard deletes the stream of an aggregate. The stream can not be
+// written to again. If an archive is set the events of the stream are
+// archived first.
+func (r *InMemoryRepository) Tombstone(aggregateType, id string) error {
+	return r.delete(aggregateType, id, true)
+}
+
+func (r *InMemoryRepository) delete(aggregateType, id string, hard bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := streamKey(aggregateType, id)
+	if r.tombstones[key] {
+		return &ErrAggregateDeleted{AggregateType: aggregateType, AggregateID: id}
+	}
+	events, ok := r.streams[key]
+	if !ok {
+		return &ErrAggregateNotFound{AggregateType: aggregateType, AggregateID: id}
+	}
+	from := r.truncated[key]
+	if from == len(events) && !hard {
+		return &ErrAggregateDeleted{AggregateType: aggregateType, AggregateID: id}
+	}
+
+	if r.archive != nil {
+		archived := make([]*ArchivedEvent, 0, len(events)-from)
+		for k := from; k < len(events); k++ {
+			data, err := json.Marshal(events[k].Event())
+			if err != nil {
+				return err
+			}
+			meta, err := json.Marshal(events[k].GetHeaders())
+			if err != nil {
+				return err
+			}
+			archived = append(archived, &ArchivedEvent{
+				StreamName:  key,
+				EventType:   events[k].EventType(),
+				EventNumber: k,
+				Data:        data,
+				MetaData:    meta,
+			})
+		}
+		if err := r.archive.Archive(key, archived); err != nil {
+			return err
+		}
+	}
+
+	if hard {
+		delete(r.streams, key)
+		delete(r.truncated, key)
+		r.tombstones[key] = true
+	} else {
+		r.truncated[key] = len(events)
+	}
+	return nil
+}
+
+// EventsByCorrelationID returns the events of all streams that have the
+// correlation id specified, ordered by the time at which they occurred.
+// Events of deleted streams are not returned.
+func (r *InMemoryRepository) EventsByCorrelationID(correlationID string) ([]EventMessage, error) {
+	r.mu.RLock()
+	var held []EventMessage
+	for k, events := range r.streams {
+		for _, v := range events[r.truncated[k]:] {
+			if CorrelationIDOf(v) == correlationID {
+				held = append(held, v)
+			}
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.SliceStable(held, func(i, j int) bool {
+		return OccurredAtOf(held[i]).Before(OccurredAtOf(held[j]))
+	})
+
+	events := make([]EventMessage, 0, len(held))
+	for _, v := range held {
+		em, err := r.decrypt(v)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, em)
+	}
+	return events, nil
+}
+
+// ReadFeed returns up to max of the events of all streams in the order in
+// which they were saved, starting at the position specified. The position of
+// an event is the number of events saved before it.
+//
+// Events remain in the feed when their stream is deleted.
+func (r *InMemoryRepository) ReadFeed(from int, max int) ([]*FeedEvent, error) {
+	r.mu.RLock()
+	var held []EventMessage
+	if from >= 0 && from < len(r.feed) {
+		to := len(r.feed)
+		if max >= 0 && from+max < to {
+			to = from + max
+		}
+		held = r.feed[from:to]
+	}
+	r.mu.RUnlock()
+
+	events := make([]*FeedEvent, 0, len(held))
+	for k, v := range held {
+		em, err := r.decrypt(v)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &FeedEvent{Position: from + k, Event: em})
+	}
+	return events, nil
+}
+
+// FeedHead returns the position that follows the last event of the feed.
+func (r *InMemoryRepository) FeedHead() (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.feed), nil
+}
+
+// read returns the events of a stream and the index of the first event that
+// has not been deleted.
+func (r *InMemoryRepository) read(aggregateType, id string) ([]EventMessage, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key := streamKey(aggregateType, id)
+	if r.tombstones[key] {
+		return nil, 0, &ErrAggregateDeleted{AggregateType: aggregateType, AggregateID: id}
+	}
+	events, ok := r.streams[key]
+	if !ok {
+		return nil, 0, &ErrAggregateNotFound{AggregateType: aggregateType, AggregateID: id}
+	}
+	from := r.truncated[key]
+	if from == len(events) {
+		return nil, 0, &ErrAggregateDeleted{AggregateType: aggregateType, AggregateID: id}
+	}
+	return events, from, nil
+}
+
+// encrypt returns the event in the form in which it is held.
+func (r *InMemoryRepository) encrypt(aggregateID string, event interface{}) (interface{}, error) {
+	if r.encryptor == nil {
+		return event, nil
+	}
+	return r.encryptor.Encrypt(event, aggregateID)
+}
+
+// decrypt returns a held event message with its personal data decrypted.
+func (r *InMemoryRepository) decrypt(em EventMessage) (EventMessage, error) {
+	if r.encryptor == nil {
+		return em, nil
+	}
+
+	event, err := r.encryptor.Decrypt(em.Event(), em.AggregateID())
+	if err != nil {
+		return nil, err
+	}
+	ret := NewEventMessage(em.AggregateID(), event, em.Version())
+	for k, v := range em.GetHeaders() {
+		ret.SetHeader(k, v)
+	}
+	return ret, nil
+}
+
+// streamKey returns the key under which the events of an aggregate are held.
+func streamKey(aggregateType, id string) string {
+	return aggregateType + "-" + id
+}
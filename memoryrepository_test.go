@@ -0,0 +1,270 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&InMemoryRepositorySuite{})
+
+type InMemoryRepositorySuite struct {
+	bus     *InternalEventBus
+	handler *MockEventHandler
+	repo    *InMemoryRepository
+}
+
+func (s *InMemoryRepositorySuite) SetUpTest(c *C) {
+	s.bus = NewInternalEventBus()
+	s.handler = NewMockEventHandler()
+	s.bus.AddHandler(s.handler, &SomeEvent{}, &SomeOtherEvent{})
+
+	s.repo, _ = NewInMemoryRepository(s.bus)
+
+	aggregateFactory := NewDelegateAggregateFactory()
+	aggregateFactory.RegisterDelegate(&SomeAggregate{},
+		func(id string) AggregateRoot { return NewSomeAggregate(id) })
+	aggregateFactory.RegisterDelegate(&SomeOtherAggregate{},
+		func(id string) AggregateRoot { return NewSomeOtherAggregate(id) })
+	s.repo.SetAggregateFactory(aggregateFactory)
+}
+
+func (s *InMemoryRepositorySuite) TestNewInMemoryRepositoryWithNilEventBusReturnsAnError(c *C) {
+	repo, err := NewInMemoryRepository(nil)
+
+	c.Assert(repo, IsNil)
+	c.Assert(err, ErrorMatches, "Nil EventBus injected into repository.")
+}
+
+func (s *InMemoryRepositorySuite) TestSaveAndLoad(c *C) {
+	id := NewUUID()
+	agg := NewSomeAggregate(id)
+	agg.TrackChange(NewEventMessage(id, &SomeEvent{"Some data", 4}, nil))
+	agg.TrackChange(NewEventMessage(id, &SomeOtherEvent{"Some order"}, nil))
+
+	err := s.repo.Save(agg, Int(agg.OriginalVersion()))
+	c.Assert(err, IsNil)
+	c.Assert(agg.GetChanges(), HasLen, 0)
+
+	got, err := s.repo.Load(typeOf(agg), id)
+
+	c.Assert(err, IsNil)
+	c.Assert(got.OriginalVersion(), Equals, 1)
+	events := got.(*SomeAggregate).events
+	c.Assert(events, HasLen, 2)
+	c.Assert(events[0].Event(), DeepEquals, &SomeEvent{"Some data", 4})
+	c.Assert(*events[1].Version(), Equals, 1)
+}
+
+func (s *InMemoryRepositorySuite) TestSavePublishesEventsWithVersions(c *C) {
+	id := NewUUID()
+	agg := NewSomeAggregate(id)
+	agg.TrackChange(NewEventMessage(id, &SomeEvent{"Some data", 4}, nil))
+
+	err := s.repo.Save(agg, nil)
+
+	c.Assert(err, IsNil)
+	c.Assert(s.handler.events, HasLen, 1)
+	c.Assert(*s.handler.events[0].Version(), Equals, 0)
+}
+
+func (s *InMemoryRepositorySuite) TestLoadUnknownAggregateReturnsErrAggregateNotFound(c *C) {
+	id := NewUUID()
+
+	agg, err := s.repo.Load(typeOf(&SomeAggregate{}), id)
+
+	c.Assert(agg, IsNil)
+	c.Assert(err, DeepEquals, &ErrAggregateNotFound{AggregateType: typeOf(&SomeAggregate{}), AggregateID: id})
+}
+
+func (s *InMemoryRepositorySuite) TestSaveReturnsConcurrencyViolation(c *C) {
+	agg := NewSomeAggregate(NewUUID())
+	agg.TrackChange(NewTestEventMessage(agg.AggregateID()))
+
+	err := s.repo.Save(agg, Int(3))
+
+	c.Assert(err, FitsTypeOf, &ErrConcurrencyViolation{})
+	c.Assert(agg.GetChanges(), HasLen, 1)
+	c.Assert(s.handler.events, HasLen, 0)
+}
+
+func (s *InMemoryRepositorySuite) TestSaveAllIsAtomic(c *C) {
+	first := NewSomeAggregate(NewUUID())
+	first.TrackChange(NewTestEventMessage(first.AggregateID()))
+	second := NewSomeOtherAggregate(NewUUID())
+	second.TrackChange(NewTestEventMessage(second.AggregateID()))
+
+	err := s.repo.SaveAll([]AggregateRoot{first, second}, []*int{Int(-1), Int(5)})
+
+	c.Assert(err, FitsTypeOf, &ErrConcurrencyViolation{})
+	c.Assert(s.handler.events, HasLen, 0)
+	_, err = s.repo.Load(typeOf(first), first.AggregateID())
+	c.Assert(err, FitsTypeOf, &ErrAggregateNotFound{})
+}
+
+func (s *InMemoryRepositorySuite) TestReadFeedReturnsEventsInTheOrderSaved(c *C) {
+	first := NewSomeAggregate(NewUUID())
+	first.TrackChange(NewTestEventMessage(first.AggregateID()))
+	second := NewSomeOtherAggregate(NewUUID())
+	second.TrackChange(NewTestEventMessage(second.AggregateID()))
+	second.TrackChange(NewTestEventMessage(second.AggregateID()))
+	c.Assert(s.repo.Save(first, nil), IsNil)
+	c.Assert(s.repo.Save(second, nil), IsNil)
+
+	events, err := s.repo.ReadFeed(1, 5)
+
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 2)
+	c.Assert(events[0].Position, Equals, 1)
+	c.Assert(events[0].Event.AggregateID(), Equals, second.AggregateID())
+	c.Assert(*events[0].Event.Version(), Equals, 0)
+	c.Assert(events[1].Position, Equals, 2)
+	c.Assert(*events[1].Event.Version(), Equals, 1)
+
+	events, _ = s.repo.ReadFeed(0, 1)
+	c.Assert(events, HasLen, 1)
+	c.Assert(events[0].Event.AggregateID(), Equals, first.AggregateID())
+
+	events, err = s.repo.ReadFeed(3, 5)
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 0)
+}
+
+func (s *InMemoryRepositorySuite) TestSaveAllReturnsErrorIfVersionsDoNotMatchAggregates(c *C) {
+	err := s.repo.SaveAll([]AggregateRoot{NewSomeAggregate(NewUUID())}, nil)
+
+	c.Assert(err, ErrorMatches, "The number of expected versions does not match the number of aggregates.")
+}
+
+func (s *InMemoryRepositorySuite) TestPersonalDataIsEncryptedAndCanBeShredded(c *C) {
+	keys := NewMemoryKeyStore()
+	encryptor, _ := NewPersonalDataEncryptor(keys)
+	s.repo.SetPersonalDataEncryptor(encryptor)
+	s.bus.AddHandler(s.handler, &CustomerRegistered{})
+
+	id := NewUUID()
+	agg := NewSomeAggregate(id)
+	agg.TrackChange(NewEventMessage(id, &CustomerRegistered{CustomerID: "c1", Name: "Jane"}, nil))
+	c.Assert(s.repo.Save(agg, nil), IsNil)
+
+	c.Assert(s.handler.events[0].Event(), DeepEquals, &CustomerRegistered{CustomerID: "c1", Name: "Jane"})
+	stored := s.repo.streams[streamKey(typeOf(agg), id)][0].Event().(*CustomerRegistered)
+	c.Assert(stored.Name, Not(Equals), "Jane")
+
+	got, err := s.repo.Load(typeOf(agg), id)
+	c.Assert(err, IsNil)
+	c.Assert(got.(*SomeAggregate).events[0].Event(), DeepEquals, &CustomerRegistered{CustomerID: "c1", Name: "Jane"})
+
+	keys.DeleteKey("c1")
+	got, err = s.repo.Load(typeOf(agg), id)
+	c.Assert(err, IsNil)
+	c.Assert(got.(*SomeAggregate).events[0].Event(), DeepEquals, &CustomerRegistered{CustomerID: "c1", Name: RedactedPlaceholder})
+}
+
+func (s *InMemoryRepositorySuite) TestLoadDeletedAggregateReturnsErrAggregateDeleted(c *C) {
+	id := NewUUID()
+	agg := NewSomeAggregate(id)
+	agg.TrackChange(NewEventMessage(id, &SomeEvent{"Some data", 4}, nil))
+	s.repo.Save(agg, nil)
+
+	c.Assert(s.repo.Delete(typeOf(agg), id), IsNil)
+
+	got, err := s.repo.Load(typeOf(agg), id)
+	c.Assert(got, IsNil)
+	c.Assert(err, DeepEquals, &ErrAggregateDeleted{AggregateType: typeOf(agg), AggregateID: id})
+	c.Assert(s.repo.Delete(typeOf(agg), id), DeepEquals, &ErrAggregateDeleted{AggregateType: typeOf(agg), AggregateID: id})
+}
+
+func (s *InMemoryRepositorySuite) TestSoftDeletedStreamCanBeWrittenAgain(c *C) {
+	id := NewUUID()
+	agg := NewSomeAggregate(id)
+	agg.TrackChange(NewEventMessage(id, &SomeEvent{"Some data", 4}, nil))
+	s.repo.Save(agg, nil)
+	s.repo.Delete(typeOf(agg), id)
+
+	agg = NewSomeAggregate(id)
+	agg.TrackChange(NewEventMessage(id, &SomeEvent{"Other data", 5}, nil))
+	c.Assert(s.repo.Save(agg, Int(0)), IsNil)
+
+	got, err := s.repo.Load(typeOf(agg), id)
+	c.Assert(err, IsNil)
+	c.Assert(got.OriginalVersion(), Equals, 1)
+	events := got.(*SomeAggregate).events
+	c.Assert(events, HasLen, 1)
+	c.Assert(events[0].Event(), DeepEquals, &SomeEvent{"Other data", 5})
+}
+
+func (s *InMemoryRepositorySuite) TestTombstonedStreamCanNotBeWritten(c *C) {
+	id := NewUUID()
+	agg := NewSomeAggregate(id)
+	agg.TrackChange(NewEventMessage(id, &SomeEvent{"Some data", 4}, nil))
+	s.repo.Save(agg, nil)
+
+	c.Assert(s.repo.Tombstone(typeOf(agg), id), IsNil)
+
+	agg.TrackChange(NewEventMessage(id, &SomeEvent{"Other data", 5}, nil))
+	err := s.repo.Save(agg, nil)
+	c.Assert(err, DeepEquals, &ErrAggregateDeleted{AggregateType: typeOf(agg), AggregateID: id})
+
+	_, err = s.repo.Load(typeOf(agg), id)
+	c.Assert(err, DeepEquals, &ErrAggregateDeleted{AggregateType: typeOf(agg), AggregateID: id})
+}
+
+func (s *InMemoryRepositorySuite) TestDeleteArchivesTheStream(c *C) {
+	archive, _ := NewFileArchive(c.MkDir())
+	s.repo.SetArchive(archive)
+
+	id := NewUUID()
+	agg := NewSomeAggregate(id)
+	agg.TrackChange(NewEventMessage(id, &SomeEvent{"Some data", 4}, nil))
+	s.repo.Save(agg, nil)
+
+	c.Assert(s.repo.Tombstone(typeOf(agg), id), IsNil)
+
+	files, _ := archive.Files(streamKey(typeOf(agg), id))
+	c.Assert(files, HasLen, 1)
+	events, err := ReadArchive(files[0])
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 1)
+	c.Assert(events[0].EventType, Equals, "SomeEvent")
+	c.Assert(string(events[0].Data), Equals, `{"Item":"Some data","Count":4}`)
+}
+
+func (s *InMemoryRepositorySuite) TestHeadersSurviveSaveAndLoad(c *C) {
+	id := NewUUID()
+	headers := TypedHeaders()
+	agg := NewSomeAggregate(id)
+	em := NewEventMessage(id, &SomeEvent{"Some data", 4}, nil)
+	for k, v := range headers {
+		em.SetHeader(k, v)
+	}
+	agg.TrackChange(em)
+	c.Assert(s.repo.Save(agg, nil), IsNil)
+
+	got, err := s.repo.Load(typeOf(agg), id)
+
+	c.Assert(err, IsNil)
+	c.Assert(got.(*SomeAggregate).events[0].GetHeaders(), DeepEquals, headers)
+}
+
+func (s *InMemoryRepositorySuite) TestEnvelopeFieldsAreRestoredOnLoad(c *C) {
+	id := NewUUID()
+	agg := NewSomeAggregate(id)
+	em := NewEventMessage(id, &SomeEvent{"Some data", 4}, nil)
+	em.SetHeader(CorrelationIDHeader, "correlation-1")
+	em.SetHeader(CausationIDHeader, "command-1")
+	agg.TrackChange(em)
+	c.Assert(s.repo.Save(agg, nil), IsNil)
+
+	got, err := s.repo.Load(typeOf(agg), id)
+
+	c.Assert(err, IsNil)
+	loaded := got.(*SomeAggregate).events[0]
+	c.Assert(EventIDOf(loaded), Equals, em.EventID())
+	c.Assert(OccurredAtOf(loaded), Equals, em.OccurredAt())
+	c.Assert(CorrelationIDOf(loaded), Equals, "correlation-1")
+	c.Assert(CausationIDOf(loaded), Equals, "command-1")
+}
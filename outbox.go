@@ -0,0 +1,706 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutboxEntry records the events written to a stream by a single call to Save.
+//
+// An entry is added to the outbox before the events are appended to the store
+// and is committed once the append has succeeded. Only committed entries are
+// published by the OutboxRelay.
+type OutboxEntry struct {
+	ID              string
+	StreamName      string
+	ExpectedVersion *int
+	Committed       bool
+	AddedAt         time.Time
+	Events          []*OutboxEvent
+}
+
+// OutboxEvent is an event held in the outbox.
+//
+// The event payload is held in its serialised form so that an entry can
+// survive a restart of the process.
+type OutboxEvent struct {
+	EventID     string
+	EventType   string
+	AggregateID string
+	Version     *int
+	Data        json.RawMessage
+	Headers     map[string]interface{}
+}
+
+// Outbox is the interface that an outbox must implement.
+//
+// The outbox holds the events that have been saved by the repository but that
+// have not yet been published on the EventBus.
+type Outbox interface {
+
+	// Add records an entry as pending.
+	Add(*OutboxEntry) error
+
+	// Commit marks the entry with the specified id as written to the store.
+	Commit(string) error
+
+	// Discard removes the entry with the specified id without publishing it.
+	Discard(string) error
+
+	// Pending returns all entries that have not been dispatched in the order
+	// in which they were added.
+	Pending() ([]*OutboxEntry, error)
+
+	// MarkDispatched removes the entry with the specified id once its events
+	// have been published.
+	MarkDispatched(string) error
+}
+
+// OutboxVerifier is implemented by repositories that can confirm whether
+// the events of an outbox entry were written to the store.
+type OutboxVerifier interface {
+	VerifyOutboxEntry(*OutboxEntry) (bool, error)
+}
+
+// newOutboxEntry constructs a pending outbox entry for the events provided.
+//
+// Each event is assigned an event id so that the entry can later be matched
+// against the events in the store.
+func newOutboxEntry(streamName string, expectedVersion *int, events []EventMessage) (*OutboxEntry, error) {
+	entry := &OutboxEntry{
+		ID:              NewUUID(),
+		StreamName:      streamName,
+		ExpectedVersion: expectedVersion,
+		AddedAt:         time.Now().UTC(),
+		Events:          make([]*OutboxEvent, len(events)),
+	}
+
+	for k, v := range events {
+		stampEvent(v)
+		data, err := json.Marshal(v.Event())
+		if err != nil {
+			return nil, err
+		}
+
+		version := v.Version()
+		if expectedVersion != nil {
+			version = Int(*expectedVersion + k + 1)
+		}
+
+		entry.Events[k] = &OutboxEvent{
+			EventID:     EventIDOf(v),
+			EventType:   v.EventType(),
+			AggregateID: v.AggregateID(),
+			Version:     version,
+			Data:        data,
+			Headers:     v.GetHeaders(),
+		}
+	}
+
+	return entry, nil
+}
+
+// MemoryOutbox is an in process implementation of the Outbox interface.
+//
+// Entries do not survive a restart of the process so the MemoryOutbox is
+// mostly useful for testing.
+type MemoryOutbox struct {
+	mu      sync.Mutex
+	entries []*OutboxEntry
+}
+
+// NewMemoryOutbox constructs a new MemoryOutbox.
+func NewMemoryOutbox() *MemoryOutbox {
+	return &MemoryOutbox{
+		entries: []*OutboxEntry{},
+	}
+}
+
+// Add records an entry as pending.
+func (o *MemoryOutbox) Add(entry *OutboxEntry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	e := *entry
+	o.entries = append(o.entries, &e)
+	return nil
+}
+
+// Commit marks the entry with the specified id as written to the store.
+func (o *MemoryOutbox) Commit(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	i := o.indexOf(id)
+	if i < 0 {
+		return fmt.Errorf("There is no outbox entry with id: %s", id)
+	}
+	o.entries[i].Committed = true
+	return nil
+}
+
+// Discard removes the entry with the specified id.
+func (o *MemoryOutbox) Discard(id string) error {
+	return o.remove(id)
+}
+
+// Pending returns all entries that have not been dispatched.
+//
+// Copies of the entries are returned so that they are not changed by a
+// concurrent Commit.
+func (o *MemoryOutbox) Pending() ([]*OutboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	ret := make([]*OutboxEntry, len(o.entries))
+	for k, v := range o.entries {
+		e := *v
+		ret[k] = &e
+	}
+	return ret, nil
+}
+
+// MarkDispatched removes the entry with the specified id.
+func (o *MemoryOutbox) MarkDispatched(id string) error {
+	return o.remove(id)
+}
+
+func (o *MemoryOutbox) remove(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	i := o.indexOf(id)
+	if i < 0 {
+		return fmt.Errorf("There is no outbox entry with id: %s", id)
+	}
+	o.entries = append(o.entries[:i], o.entries[i+1:]...)
+	return nil
+}
+
+func (o *MemoryOutbox) indexOf(id string) int {
+	for k, v := range o.entries {
+		if v.ID == id {
+			return k
+		}
+	}
+	return -1
+}
+
+// FileOutbox is an implementation of the Outbox interface that holds each
+// entry in a JSON file in a directory.
+//
+// Files are written to a temporary file and renamed into place so that an
+// entry is never left partially written.
+type FileOutbox struct {
+	mu   sync.Mutex
+	dir  string
+	last int64
+}
+
+// NewFileOutbox constructs a new FileOutbox that keeps its entries in the
+// directory specified. The directory is created if it does not exist.
+func NewFileOutbox(dir string) (*FileOutbox, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileOutbox{
+		dir: dir,
+	}, nil
+}
+
+// Add records an entry as pending.
+//
+// File names are prefixed with a sequence so that entries are returned by
+// Pending in the order in which they were added.
+func (o *FileOutbox) Add(entry *OutboxEntry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	seq := time.Now().UnixNano()
+	if seq <= o.last {
+		seq = o.last + 1
+	}
+	o.last = seq
+
+	return o.write(fmt.Sprintf("%020d-%s.json", seq, entry.ID), entry)
+}
+
+// Commit marks the entry with the specified id as written to the store.
+func (o *FileOutbox) Commit(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	name, err := o.find(id)
+	if err != nil {
+		return err
+	}
+
+	entry, err := o.read(name)
+	if err != nil {
+		return err
+	}
+	entry.Committed = true
+
+	return o.write(name, entry)
+}
+
+// Discard removes the entry with the specified id.
+func (o *FileOutbox) Discard(id string) error {
+	return o.remove(id)
+}
+
+// Pending returns all entries that have not been dispatched.
+func (o *FileOutbox) Pending() ([]*OutboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	names, err := filepath.Glob(filepath.Join(o.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	ret := make([]*OutboxEntry, 0, len(names))
+	for _, name := range names {
+		entry, err := o.read(filepath.Base(name))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, entry)
+	}
+	return ret, nil
+}
+
+// MarkDispatched removes the entry with the specified id.
+func (o *FileOutbox) MarkDispatched(id string) error {
+	return o.remove(id)
+}
+
+func (o *FileOutbox) remove(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	name, err := o.find(id)
+	if err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(o.dir, name))
+}
+
+func (o *FileOutbox) find(id string) (string, error) {
+	names, err := filepath.Glob(filepath.Join(o.dir, "*-"+id+".json"))
+	if err != nil {
+		return "", err
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("There is no outbox entry with id: %s", id)
+	}
+	return filepath.Base(names[0]), nil
+}
+
+func (o *FileOutbox) read(name string) (*OutboxEntry, error) {
+	b, err := ioutil.ReadFile(filepath.Join(o.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	entry := &OutboxEntry{}
+	if err := json.Unmarshal(b, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (o *FileOutbox) write(name string, entry *OutboxEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(o.dir, strings.TrimSuffix(name, ".json")+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(o.dir, name))
+}
+
+// SQLOutbox is an implementation of the Outbox interface that holds entries
+// in a table of a SQL database.
+//
+// The table has a seq column that orders the entries, an id column, a
+// committed column and an entry column holding the entry as JSON, and can be
+// created with CreateTable.
+type SQLOutbox struct {
+	mu           sync.Mutex
+	db           *sql.DB
+	table        string
+	placeholders PlaceholderStyle
+	last         int64
+}
+
+// NewSQLOutbox constructs a new SQLOutbox that keeps its entries in the table
+// specified.
+func NewSQLOutbox(db *sql.DB, table string) (*SQLOutbox, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Nil database injected into outbox.")
+	}
+	if table == "" {
+		return nil, fmt.Errorf("The outbox has no table name.")
+	}
+	return &SQLOutbox{
+		db:    db,
+		table: table,
+	}, nil
+}
+
+// SetPlaceholderStyle sets the style of the placeholders for parameters used
+// by the driver of the database.
+func (o *SQLOutbox) SetPlaceholderStyle(style PlaceholderStyle) {
+	o.placeholders = style
+}
+
+// CreateTable creates the table of entries if it does not exist.
+func (o *SQLOutbox) CreateTable() error {
+	_, err := o.db.Exec(fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (seq BIGINT NOT NULL PRIMARY KEY, id VARCHAR(64) NOT NULL UNIQUE, committed SMALLINT NOT NULL, entry TEXT NOT NULL)",
+		o.table))
+	return err
+}
+
+// Add records an entry as pending.
+//
+// Entries are given a sequence so that they are returned by Pending in the
+// order in which they were added.
+func (o *SQLOutbox) Add(entry *OutboxEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	seq := time.Now().UnixNano()
+	if seq <= o.last {
+		seq = o.last + 1
+	}
+	o.last = seq
+	o.mu.Unlock()
+
+	committed := 0
+	if entry.Committed {
+		committed = 1
+	}
+	_, err = o.db.Exec(fmt.Sprintf("INSERT INTO %s (seq, id, committed, entry) VALUES (%s, %s, %s, %s)",
+		o.table, o.placeholders.placeholder(1), o.placeholders.placeholder(2),
+		o.placeholders.placeholder(3), o.placeholders.placeholder(4)),
+		seq, entry.ID, committed, string(b))
+	return err
+}
+
+// Commit marks the entry with the specified id as written to the store.
+func (o *SQLOutbox) Commit(id string) error {
+	res, err := o.db.Exec(fmt.Sprintf("UPDATE %s SET committed = 1 WHERE id = %s",
+		o.table, o.placeholders.placeholder(1)), id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	// Some databases, such as MySQL, count only the rows that are changed, so
+	// an entry that is already committed is looked for.
+	var count int64
+	err = o.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE id = %s",
+		o.table, o.placeholders.placeholder(1)), id).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("There is no outbox entry with id: %s", id)
+	}
+	return nil
+}
+
+// Discard removes the entry with the specified id.
+func (o *SQLOutbox) Discard(id string) error {
+	return o.remove(id)
+}
+
+// Pending returns all entries that have not been dispatched.
+func (o *SQLOutbox) Pending() ([]*OutboxEntry, error) {
+	rows, err := o.db.Query(fmt.Sprintf("SELECT committed, entry FROM %s ORDER BY seq", o.table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []*OutboxEntry{}
+	for rows.Next() {
+		var committed int64
+		var b string
+		if err := rows.Scan(&committed, &b); err != nil {
+			return nil, err
+		}
+		entry := &OutboxEntry{}
+		if err := json.Unmarshal([]byte(b), entry); err != nil {
+			return nil, err
+		}
+		entry.Committed = committed != 0
+		ret = append(ret, entry)
+	}
+	return ret, rows.Err()
+}
+
+// MarkDispatched removes the entry with the specified id.
+func (o *SQLOutbox) MarkDispatched(id string) error {
+	return o.remove(id)
+}
+
+func (o *SQLOutbox) remove(id string) error {
+	res, err := o.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = %s",
+		o.table, o.placeholders.placeholder(1)), id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("There is no outbox entry with id: %s", id)
+	}
+	return nil
+}
+
+// OutboxRelay publishes the events held in an Outbox on an EventBus.
+//
+// Delivery is at least once. If the process stops after an entry has been
+// published but before it has been marked as dispatched, the entry will be
+// published again when the relay next runs. Event handlers should therefore
+// be idempotent.
+type OutboxRelay struct {
+	mu           sync.Mutex
+	outbox       Outbox
+	eventBus     EventBus
+	eventFactory EventFactory
+	headers      *HeaderRegistry
+	errorHandler func(error)
+	verifier     OutboxVerifier
+	verifyAfter  time.Duration
+}
+
+// NewOutboxRelay constructs a new OutboxRelay.
+//
+// The event factory is used to instantiate the events held in the outbox
+// before they are published.
+func NewOutboxRelay(outbox Outbox, eventBus EventBus, eventFactory EventFactory) (*OutboxRelay, error) {
+	if outbox == nil {
+		return nil, fmt.Errorf("Nil Outbox injected into outbox relay.")
+	}
+
+	if eventBus == nil {
+		return nil, fmt.Errorf("Nil EventBus injected into outbox relay.")
+	}
+
+	if eventFactory == nil {
+		return nil, fmt.Errorf("Nil EventFactory injected into outbox relay.")
+	}
+
+	return &OutboxRelay{
+		outbox:       outbox,
+		eventBus:     eventBus,
+		eventFactory: eventFactory,
+		headers:      NewHeaderRegistry(),
+	}, nil
+}
+
+// SetHeaderRegistry sets the registry that gives the headers of dispatched
+// events their types.
+func (r *OutboxRelay) SetHeaderRegistry(headers *HeaderRegistry) {
+	r.headers = headers
+}
+
+// SetVerifier sets the verifier that Dispatch asks about entries that have
+// not been committed within the duration specified of being added.
+//
+// An entry is left uncommitted when a Save stops after its events may have
+// been appended, for instance when the append times out or the outbox can
+// not be updated. Such an entry holds up the entries after it until it is
+// committed, if its events are in the store, or discarded. Without a verifier
+// these entries are only reconciled by Recover.
+//
+// The duration should be longer than a Save can take, so that entries whose
+// Save is still in progress are not discarded.
+func (r *OutboxRelay) SetVerifier(verifier OutboxVerifier, after time.Duration) {
+	r.verifier = verifier
+	r.verifyAfter = after
+}
+
+// SetErrorHandler sets a function that is called with any error returned
+// by Dispatch while the relay is running.
+func (r *OutboxRelay) SetErrorHandler(handler func(error)) {
+	r.errorHandler = handler
+}
+
+// Recover reconciles entries that were added to the outbox but never
+// committed or discarded, which happens when a process stops part way
+// through a Save.
+//
+// The verifier is asked whether the events of each such entry are in the
+// store. If they are the entry is committed, otherwise it is discarded.
+//
+// Recover should be called on startup before any aggregates are saved.
+func (r *OutboxRelay) Recover(verifier OutboxVerifier) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries, err := r.outbox.Pending()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Committed {
+			continue
+		}
+		if _, err := r.reconcile(verifier, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcile commits an uncommitted entry if the verifier finds its events in
+// the store and discards it otherwise. It reports whether the entry was
+// committed.
+func (r *OutboxRelay) reconcile(verifier OutboxVerifier, entry *OutboxEntry) (bool, error) {
+	found, err := verifier.VerifyOutboxEntry(entry)
+	if err != nil {
+		return false, err
+	}
+
+	if found {
+		err = r.outbox.Commit(entry.ID)
+	} else {
+		err = r.outbox.Discard(entry.ID)
+	}
+	return found, err
+}
+
+// Dispatch publishes the events of all committed entries and marks the
+// entries as dispatched. It returns the number of entries dispatched.
+//
+// Entries are dispatched in the order in which they were added. Dispatch
+// stops at the first entry that is not yet committed so that events are
+// never published out of order. If a verifier is set, entries that have been
+// uncommitted for longer than its duration are reconciled first.
+func (r *OutboxRelay) Dispatch() (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries, err := r.outbox.Pending()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, entry := range entries {
+		if !entry.Committed {
+			if r.verifier == nil || time.Since(entry.AddedAt) < r.verifyAfter {
+				break
+			}
+			committed, err := r.reconcile(r.verifier, entry)
+			if err != nil {
+				return count, err
+			}
+			if !committed {
+				continue
+			}
+		}
+
+		messages := make([]EventMessage, len(entry.Events))
+		for k, v := range entry.Events {
+			em, err := r.eventMessage(v)
+			if err != nil {
+				return count, err
+			}
+			messages[k] = em
+		}
+
+		for _, em := range messages {
+			r.eventBus.PublishEvent(em)
+		}
+
+		if err := r.outbox.MarkDispatched(entry.ID); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Run calls Dispatch at the interval specified until the stop channel is
+// closed.
+//
+// Errors are passed to the error handler, if one is set, and the dispatch is
+// retried at the next interval.
+func (r *OutboxRelay) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Dispatch(); err != nil && r.errorHandler != nil {
+			r.errorHandler(err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *OutboxRelay) eventMessage(v *OutboxEvent) (EventMessage, error) {
+	event := r.eventFactory.GetEvent(v.EventType)
+	if event == nil {
+		return nil, fmt.Errorf("The outbox relay has no event factory delegate for event type: %s", v.EventType)
+	}
+
+	if err := json.Unmarshal(v.Data, event); err != nil {
+		return nil, err
+	}
+
+	// Headers are normalised through JSON so that they have the same types
+	// whichever outbox held them.
+	b, err := json.Marshal(v.Headers)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := r.headers.Decode(b)
+	if err != nil {
+		return nil, err
+	}
+
+	em := NewEventMessage(v.AggregateID, event, v.Version)
+	for k, h := range headers {
+		em.SetHeader(k, h)
+	}
+	return em, nil
+}
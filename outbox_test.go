@@ -0,0 +1,513 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+var (
+	_ = Suite(&OutboxSuite{})
+	_ = Suite(&OutboxRelaySuite{})
+)
+
+type OutboxSuite struct{}
+
+func (s *OutboxSuite) outboxes(c *C) []Outbox {
+	fileOutbox, err := NewFileOutbox(c.MkDir())
+	c.Assert(err, IsNil)
+	sqlOutbox, err := NewSQLOutbox(openFakeOutboxDB(c), "outbox")
+	c.Assert(err, IsNil)
+	c.Assert(sqlOutbox.CreateTable(), IsNil)
+	return []Outbox{NewMemoryOutbox(), fileOutbox, sqlOutbox}
+}
+
+func (s *OutboxSuite) TestNewOutboxEntryAssignsEventIDsAndVersions(c *C) {
+	id := NewUUID()
+	events := []EventMessage{
+		NewEventMessage(id, &SomeEvent{"Some data", 4}, nil),
+		NewEventMessage(id, &SomeOtherEvent{"Some order"}, nil),
+	}
+
+	entry, err := newOutboxEntry("astream", Int(3), events)
+
+	c.Assert(err, IsNil)
+	c.Assert(entry.ID, Not(Equals), "")
+	c.Assert(entry.Committed, Equals, false)
+	c.Assert(entry.Events, HasLen, 2)
+	c.Assert(entry.Events[0].EventID, Not(Equals), entry.Events[1].EventID)
+	c.Assert(entry.Events[0].EventType, Equals, "SomeEvent")
+	c.Assert(*entry.Events[0].Version, Equals, 4)
+	c.Assert(*entry.Events[1].Version, Equals, 5)
+}
+
+func (s *OutboxSuite) TestAddedEntriesArePendingInOrder(c *C) {
+	for _, outbox := range s.outboxes(c) {
+		first := &OutboxEntry{ID: NewUUID(), StreamName: "first"}
+		second := &OutboxEntry{ID: NewUUID(), StreamName: "second"}
+
+		c.Assert(outbox.Add(first), IsNil)
+		c.Assert(outbox.Add(second), IsNil)
+
+		pending, err := outbox.Pending()
+		c.Assert(err, IsNil)
+		c.Assert(pending, HasLen, 2)
+		c.Assert(pending[0].ID, Equals, first.ID)
+		c.Assert(pending[1].ID, Equals, second.ID)
+	}
+}
+
+func (s *OutboxSuite) TestCommitMarksEntryCommitted(c *C) {
+	for _, outbox := range s.outboxes(c) {
+		entry := &OutboxEntry{ID: NewUUID(), StreamName: "astream"}
+		c.Assert(outbox.Add(entry), IsNil)
+
+		c.Assert(outbox.Commit(entry.ID), IsNil)
+
+		pending, err := outbox.Pending()
+		c.Assert(err, IsNil)
+		c.Assert(pending[0].Committed, Equals, true)
+	}
+}
+
+func (s *OutboxSuite) TestDiscardAndMarkDispatchedRemoveEntries(c *C) {
+	for _, outbox := range s.outboxes(c) {
+		first := &OutboxEntry{ID: NewUUID()}
+		second := &OutboxEntry{ID: NewUUID()}
+		c.Assert(outbox.Add(first), IsNil)
+		c.Assert(outbox.Add(second), IsNil)
+
+		c.Assert(outbox.Discard(first.ID), IsNil)
+		c.Assert(outbox.MarkDispatched(second.ID), IsNil)
+
+		pending, err := outbox.Pending()
+		c.Assert(err, IsNil)
+		c.Assert(pending, HasLen, 0)
+	}
+}
+
+func (s *OutboxSuite) TestCommittingTwiceSucceeds(c *C) {
+	for _, outbox := range s.outboxes(c) {
+		entry := &OutboxEntry{ID: NewUUID(), StreamName: "astream"}
+		c.Assert(outbox.Add(entry), IsNil)
+
+		c.Assert(outbox.Commit(entry.ID), IsNil)
+		c.Assert(outbox.Commit(entry.ID), IsNil)
+	}
+}
+
+func (s *OutboxSuite) TestMemoryOutboxPendingReturnsCopies(c *C) {
+	outbox := NewMemoryOutbox()
+	entry := &OutboxEntry{ID: NewUUID(), StreamName: "astream"}
+	c.Assert(outbox.Add(entry), IsNil)
+	pending, _ := outbox.Pending()
+
+	c.Assert(outbox.Commit(entry.ID), IsNil)
+
+	c.Assert(entry.Committed, Equals, false)
+	c.Assert(pending[0].Committed, Equals, false)
+	pending, _ = outbox.Pending()
+	c.Assert(pending[0].Committed, Equals, true)
+}
+
+func (s *OutboxSuite) TestSQLOutboxEntriesSurviveANewOutbox(c *C) {
+	db := openFakeOutboxDB(c)
+	outbox, _ := NewSQLOutbox(db, "outbox")
+	outbox.SetPlaceholderStyle(DollarPlaceholders)
+	entry, err := newOutboxEntry("astream", Int(2), []EventMessage{NewTestEventMessage(NewUUID())})
+	c.Assert(err, IsNil)
+	c.Assert(outbox.Add(entry), IsNil)
+	c.Assert(outbox.Commit(entry.ID), IsNil)
+
+	reopened, err := NewSQLOutbox(db, "outbox")
+	c.Assert(err, IsNil)
+	pending, err := reopened.Pending()
+
+	c.Assert(err, IsNil)
+	c.Assert(pending, HasLen, 1)
+	c.Assert(pending[0].ID, Equals, entry.ID)
+	c.Assert(pending[0].StreamName, Equals, "astream")
+	c.Assert(*pending[0].ExpectedVersion, Equals, 2)
+	c.Assert(pending[0].Committed, Equals, true)
+	c.Assert(pending[0].AddedAt.Equal(entry.AddedAt), Equals, true)
+	c.Assert(pending[0].Events[0].EventID, Equals, entry.Events[0].EventID)
+	c.Assert(pending[0].Events[0].Data, DeepEquals, entry.Events[0].Data)
+}
+
+func (s *OutboxSuite) TestSQLOutboxRequiresADatabaseAndTable(c *C) {
+	_, err := NewSQLOutbox(nil, "outbox")
+	c.Assert(err, ErrorMatches, "Nil database injected into outbox.")
+
+	_, err = NewSQLOutbox(openFakeOutboxDB(c), "")
+	c.Assert(err, ErrorMatches, "The outbox has no table name.")
+}
+
+func (s *OutboxSuite) TestUnknownEntryReturnsAnError(c *C) {
+	for _, outbox := range s.outboxes(c) {
+		c.Assert(outbox.Commit("unknown"), ErrorMatches, "There is no outbox entry with id: unknown")
+		c.Assert(outbox.MarkDispatched("unknown"), ErrorMatches, "There is no outbox entry with id: unknown")
+	}
+}
+
+func (s *OutboxSuite) TestFileOutboxEntriesSurviveRestart(c *C) {
+	dir := c.MkDir()
+	outbox, _ := NewFileOutbox(dir)
+	entry := &OutboxEntry{
+		ID:         NewUUID(),
+		StreamName: "astream",
+		Events: []*OutboxEvent{
+			{EventID: NewUUID(), EventType: "SomeEvent", Data: json.RawMessage(`{"Item":"a","Count":1}`)},
+		},
+	}
+	c.Assert(outbox.Add(entry), IsNil)
+
+	reopened, err := NewFileOutbox(dir)
+	c.Assert(err, IsNil)
+	pending, err := reopened.Pending()
+
+	c.Assert(err, IsNil)
+	c.Assert(pending, DeepEquals, []*OutboxEntry{entry})
+}
+
+type OutboxRelaySuite struct {
+	outbox  *MemoryOutbox
+	bus     *InternalEventBus
+	handler *MockEventHandler
+	relay   *OutboxRelay
+}
+
+func (s *OutboxRelaySuite) SetUpTest(c *C) {
+	s.outbox = NewMemoryOutbox()
+	s.bus = NewInternalEventBus()
+	s.handler = NewMockEventHandler()
+	s.bus.AddHandler(s.handler, &SomeEvent{}, &SomeOtherEvent{})
+
+	eventFactory := NewDelegateEventFactory()
+	eventFactory.RegisterDelegate(&SomeEvent{},
+		func() interface{} { return &SomeEvent{} })
+	eventFactory.RegisterDelegate(&SomeOtherEvent{},
+		func() interface{} { return &SomeOtherEvent{} })
+
+	s.relay, _ = NewOutboxRelay(s.outbox, s.bus, eventFactory)
+}
+
+func (s *OutboxRelaySuite) addEntry(c *C, committed bool, events ...EventMessage) *OutboxEntry {
+	entry, err := newOutboxEntry("astream", nil, events)
+	c.Assert(err, IsNil)
+	entry.Committed = committed
+	c.Assert(s.outbox.Add(entry), IsNil)
+	return entry
+}
+
+func (s *OutboxRelaySuite) TestNewOutboxRelayReturnsErrorForNilDependencies(c *C) {
+	_, err := NewOutboxRelay(nil, s.bus, NewDelegateEventFactory())
+	c.Assert(err, ErrorMatches, "Nil Outbox injected into outbox relay.")
+
+	_, err = NewOutboxRelay(s.outbox, nil, NewDelegateEventFactory())
+	c.Assert(err, ErrorMatches, "Nil EventBus injected into outbox relay.")
+
+	_, err = NewOutboxRelay(s.outbox, s.bus, nil)
+	c.Assert(err, ErrorMatches, "Nil EventFactory injected into outbox relay.")
+}
+
+func (s *OutboxRelaySuite) TestDispatchPublishesCommittedEntries(c *C) {
+	id := NewUUID()
+	ev := &SomeEvent{"Some data", 4}
+	em := NewEventMessage(id, ev, Int(0))
+	em.SetHeader("AggregateID", id)
+	s.addEntry(c, true, em)
+
+	n, err := s.relay.Dispatch()
+
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	c.Assert(s.handler.events, HasLen, 1)
+	c.Assert(s.handler.events[0].AggregateID(), Equals, id)
+	c.Assert(s.handler.events[0].Event(), DeepEquals, ev)
+	c.Assert(*s.handler.events[0].Version(), Equals, 0)
+	c.Assert(s.handler.events[0].GetHeaders()["AggregateID"], Equals, id)
+
+	pending, _ := s.outbox.Pending()
+	c.Assert(pending, HasLen, 0)
+}
+
+func (s *OutboxRelaySuite) TestDispatchStopsAtUncommittedEntry(c *C) {
+	s.addEntry(c, true, NewTestEventMessage(NewUUID()))
+	s.addEntry(c, false, NewTestEventMessage(NewUUID()))
+	s.addEntry(c, true, NewTestEventMessage(NewUUID()))
+
+	n, err := s.relay.Dispatch()
+
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	c.Assert(s.handler.events, HasLen, 1)
+
+	pending, _ := s.outbox.Pending()
+	c.Assert(pending, HasLen, 2)
+}
+
+func (s *OutboxRelaySuite) TestDispatchReconcilesStaleUncommittedEntries(c *C) {
+	found := s.addEntry(c, false, NewTestEventMessage(NewUUID()))
+	lost := s.addEntry(c, false, NewTestEventMessage(NewUUID()))
+	s.addEntry(c, true, NewTestEventMessage(NewUUID()))
+	verifier := &FakeOutboxVerifier{found: map[string]bool{found.ID: true}}
+	s.relay.SetVerifier(verifier, 0)
+
+	n, err := s.relay.Dispatch()
+
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+	c.Assert(s.handler.events, HasLen, 2)
+	c.Assert(verifier.verified, DeepEquals, []string{found.ID, lost.ID})
+	pending, _ := s.outbox.Pending()
+	c.Assert(pending, HasLen, 0)
+}
+
+func (s *OutboxRelaySuite) TestDispatchDoesNotVerifyRecentEntries(c *C) {
+	s.addEntry(c, false, NewTestEventMessage(NewUUID()))
+	verifier := &FakeOutboxVerifier{}
+	s.relay.SetVerifier(verifier, time.Hour)
+
+	n, err := s.relay.Dispatch()
+
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+	c.Assert(verifier.verified, HasLen, 0)
+	pending, _ := s.outbox.Pending()
+	c.Assert(pending, HasLen, 1)
+}
+
+func (s *OutboxRelaySuite) TestDispatchReturnsErrorForUnknownEventType(c *C) {
+	relay, _ := NewOutboxRelay(s.outbox, s.bus, NewDelegateEventFactory())
+	s.addEntry(c, true, NewTestEventMessage(NewUUID()))
+
+	n, err := relay.Dispatch()
+
+	c.Assert(n, Equals, 0)
+	c.Assert(err, ErrorMatches, "The outbox relay has no event factory delegate for event type: SomeEvent")
+	c.Assert(s.handler.events, HasLen, 0)
+}
+
+func (s *OutboxRelaySuite) TestRecoverCommitsEntriesFoundInTheStore(c *C) {
+	found := s.addEntry(c, false, NewTestEventMessage(NewUUID()))
+	lost := s.addEntry(c, false, NewTestEventMessage(NewUUID()))
+	verifier := &FakeOutboxVerifier{found: map[string]bool{found.ID: true}}
+
+	err := s.relay.Recover(verifier)
+
+	c.Assert(err, IsNil)
+	pending, _ := s.outbox.Pending()
+	c.Assert(pending, HasLen, 1)
+	c.Assert(pending[0].ID, Equals, found.ID)
+	c.Assert(pending[0].Committed, Equals, true)
+	c.Assert(verifier.verified, DeepEquals, []string{found.ID, lost.ID})
+}
+
+func (s *OutboxRelaySuite) TestRecoverIgnoresCommittedEntries(c *C) {
+	s.addEntry(c, true, NewTestEventMessage(NewUUID()))
+	verifier := &FakeOutboxVerifier{}
+
+	err := s.relay.Recover(verifier)
+
+	c.Assert(err, IsNil)
+	c.Assert(verifier.verified, HasLen, 0)
+}
+
+func (s *OutboxRelaySuite) TestRunDispatchesUntilStopped(c *C) {
+	s.addEntry(c, true, NewTestEventMessage(NewUUID()))
+	stop := make(chan struct{})
+	close(stop)
+
+	s.relay.Run(1, stop)
+
+	c.Assert(s.handler.events, HasLen, 1)
+}
+
+// Fakes
+
+type FakeOutboxVerifier struct {
+	found    map[string]bool
+	verified []string
+}
+
+func (v *FakeOutboxVerifier) VerifyOutboxEntry(entry *OutboxEntry) (bool, error) {
+	v.verified = append(v.verified, entry.ID)
+	return v.found[entry.ID], nil
+}
+
+func (s *OutboxRelaySuite) TestHeadersSurviveAFileOutbox(c *C) {
+	outbox, _ := NewFileOutbox(c.MkDir())
+	eventFactory := NewDelegateEventFactory()
+	eventFactory.RegisterDelegate(&SomeEvent{},
+		func() interface{} { return &SomeEvent{} })
+	relay, _ := NewOutboxRelay(outbox, s.bus, eventFactory)
+	registry := NewHeaderRegistry()
+	RegisterTypedHeaders(registry)
+	relay.SetHeaderRegistry(registry)
+
+	headers := TypedHeaders()
+	em := NewEventMessage(NewUUID(), &SomeEvent{"Some data", 4}, nil)
+	for k, v := range headers {
+		em.SetHeader(k, v)
+	}
+	entry, _ := newOutboxEntry("astream", nil, []EventMessage{em})
+	entry.Committed = true
+	c.Assert(outbox.Add(entry), IsNil)
+
+	_, err := relay.Dispatch()
+
+	c.Assert(err, IsNil)
+	c.Assert(s.handler.events, HasLen, 1)
+	c.Assert(s.handler.events[0].GetHeaders(), DeepEquals, headers)
+}
+
+// fakeOutboxDriver is a database/sql driver that understands only the
+// statements of the SQLOutbox. Like MySQL, it counts only the rows that an
+// UPDATE changes.
+type fakeOutboxDriver struct {
+	mu    sync.Mutex
+	conns map[string]*fakeOutboxConn
+}
+
+var outboxDriver = &fakeOutboxDriver{conns: make(map[string]*fakeOutboxConn)}
+
+func init() {
+	sql.Register("fakeoutbox", outboxDriver)
+}
+
+func openFakeOutboxDB(c *C) *sql.DB {
+	name := NewUUID()
+	outboxDriver.mu.Lock()
+	outboxDriver.conns[name] = &fakeOutboxConn{}
+	outboxDriver.mu.Unlock()
+
+	db, err := sql.Open("fakeoutbox", name)
+	c.Assert(err, IsNil)
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+func (d *fakeOutboxDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.conns[name], nil
+}
+
+type fakeOutboxRow struct {
+	seq       int64
+	id        string
+	committed int64
+	entry     string
+}
+
+type fakeOutboxConn struct {
+	rows []*fakeOutboxRow
+}
+
+func (c *fakeOutboxConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeOutboxStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeOutboxConn) Close() error              { return nil }
+func (c *fakeOutboxConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *fakeOutboxConn) Commit() error             { return nil }
+func (c *fakeOutboxConn) Rollback() error           { return nil }
+
+func (c *fakeOutboxConn) find(id string) int {
+	for k, v := range c.rows {
+		if v.id == id {
+			return k
+		}
+	}
+	return -1
+}
+
+type fakeOutboxStmt struct {
+	conn  *fakeOutboxConn
+	query string
+}
+
+func (s *fakeOutboxStmt) Close() error  { return nil }
+func (s *fakeOutboxStmt) NumInput() int { return -1 }
+
+func (s *fakeOutboxStmt) Exec(args []driver.Value) (driver.Result, error) {
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE"):
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(s.query, "INSERT"):
+		s.conn.rows = append(s.conn.rows, &fakeOutboxRow{
+			seq:       args[0].(int64),
+			id:        args[1].(string),
+			committed: args[2].(int64),
+			entry:     args[3].(string),
+		})
+		sort.Slice(s.conn.rows, func(i, j int) bool { return s.conn.rows[i].seq < s.conn.rows[j].seq })
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(s.query, "UPDATE"):
+		i := s.conn.find(args[0].(string))
+		if i < 0 || s.conn.rows[i].committed == 1 {
+			return driver.RowsAffected(0), nil
+		}
+		s.conn.rows[i].committed = 1
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(s.query, "DELETE"):
+		i := s.conn.find(args[0].(string))
+		if i < 0 {
+			return driver.RowsAffected(0), nil
+		}
+		s.conn.rows = append(s.conn.rows[:i], s.conn.rows[i+1:]...)
+		return driver.RowsAffected(1), nil
+	}
+	return nil, fmt.Errorf("unexpected statement %s", s.query)
+}
+
+func (s *fakeOutboxStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows := &fakeOutboxRows{}
+	switch {
+	case strings.HasPrefix(s.query, "SELECT COUNT(*)"):
+		count := int64(0)
+		if s.conn.find(args[0].(string)) >= 0 {
+			count = 1
+		}
+		rows.columns = []string{"count"}
+		rows.values = [][]driver.Value{{count}}
+	case strings.HasPrefix(s.query, "SELECT committed, entry"):
+		rows.columns = []string{"committed", "entry"}
+		for _, v := range s.conn.rows {
+			rows.values = append(rows.values, []driver.Value{v.committed, v.entry})
+		}
+	default:
+		return nil, fmt.Errorf("unexpected query %s", s.query)
+	}
+	return rows, nil
+}
+
+type fakeOutboxRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeOutboxRows) Columns() []string { return r.columns }
+func (r *fakeOutboxRows) Close() error      { return nil }
+
+func (r *fakeOutboxRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
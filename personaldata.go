@@ -0,0 +1,227 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// PersonalDataTag is the struct tag used to mark the fields of an event that
+// hold personal data.
+//
+// Fields tagged `personal:"data"` are encrypted with the key of the subject
+// of the event. The subject is given by the field tagged
+// `personal:"subject"`. If the event has no subject field, or it is empty,
+// the subject is the aggregate the event belongs to.
+//
+//	type CustomerRegistered struct {
+//		CustomerID string `personal:"subject"`
+//		Name       string `personal:"data"`
+//		Email      string `personal:"data"`
+//		Country    string
+//	}
+//
+// Only string fields of the event itself can hold personal data.
+const PersonalDataTag = "personal"
+
+// RedactedPlaceholder is the value read back from a personal data field
+// whose subject key has been deleted.
+const RedactedPlaceholder = "[redacted]"
+
+// encryptedPrefix marks a field value as encrypted. Values without it, for
+// instance those written before encryption was enabled, are read unchanged.
+const encryptedPrefix = "enc:"
+
+// PersonalDataEncryptor encrypts and decrypts the personal data fields of
+// events with a key per subject.
+//
+// Events are immutable, so to erase the personal data of a subject its key
+// is deleted from the KeyStore. The personal data fields of the events of
+// that subject are then read as the RedactedPlaceholder. Replaying the events
+// does not fail.
+type PersonalDataEncryptor struct {
+	keys KeyStore
+}
+
+// NewPersonalDataEncryptor constructs a new PersonalDataEncryptor that uses
+// the key store specified.
+func NewPersonalDataEncryptor(keys KeyStore) (*PersonalDataEncryptor, error) {
+	if keys == nil {
+		return nil, fmt.Errorf("Nil KeyStore injected into personal data encryptor.")
+	}
+
+	return &PersonalDataEncryptor{
+		keys: keys,
+	}, nil
+}
+
+// Encrypt returns a copy of the event with its personal data fields
+// encrypted. The event itself is not changed.
+//
+// The subject key is created if it does not exist. The aggregateID is the
+// subject of events without a subject field. If the key of the subject has
+// been deleted an *ErrKeyDeleted is returned, since personal data written
+// under a new key would be mistaken for data the old key can not decrypt.
+func (e *PersonalDataEncryptor) Encrypt(event interface{}, aggregateID string) (interface{}, error) {
+	return e.transform(event, aggregateID, true)
+}
+
+// Decrypt returns a copy of the event with its personal data fields
+// decrypted. The event itself is not changed.
+//
+// If the subject key has been deleted the fields are set to the
+// RedactedPlaceholder. Any other failure to decrypt a field, such as a value
+// that has been tampered with or a key that does not match, is returned as an
+// error.
+func (e *PersonalDataEncryptor) Decrypt(event interface{}, aggregateID string) (interface{}, error) {
+	return e.transform(event, aggregateID, false)
+}
+
+func (e *PersonalDataEncryptor) transform(event interface{}, aggregateID string, encrypt bool) (interface{}, error) {
+	v := reflect.ValueOf(event)
+	isPtr := v.Kind() == reflect.Ptr
+	if isPtr {
+		if v.IsNil() {
+			return event, nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return event, nil
+	}
+
+	fields, subject, err := personalDataFields(v)
+	if err != nil || len(fields) == 0 {
+		return event, err
+	}
+	if subject == "" {
+		subject = aggregateID
+	}
+
+	cp := reflect.New(v.Type()).Elem()
+	cp.Set(v)
+
+	if encrypt {
+		key, err := e.keys.CreateKey(subject)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range fields {
+			f := cp.Field(i)
+			s, err := encryptString(key, f.String())
+			if err != nil {
+				return nil, err
+			}
+			f.SetString(s)
+		}
+	} else {
+		key, err := e.keys.Key(subject)
+		_, deleted := err.(*ErrKeyNotFound)
+		if err != nil && !deleted {
+			return nil, err
+		}
+		for _, i := range fields {
+			f := cp.Field(i)
+			if !strings.HasPrefix(f.String(), encryptedPrefix) {
+				continue
+			}
+			if deleted {
+				f.SetString(RedactedPlaceholder)
+				continue
+			}
+			s, err := decryptString(key, f.String())
+			if err != nil {
+				return nil, fmt.Errorf("Field %s of event type %s can not be decrypted. %s",
+					v.Type().Field(i).Name, v.Type().Name(), err)
+			}
+			f.SetString(s)
+		}
+	}
+
+	if isPtr {
+		return cp.Addr().Interface(), nil
+	}
+	return cp.Interface(), nil
+}
+
+// personalDataFields returns the indexes of the personal data fields of an
+// event and the value of its subject field.
+func personalDataFields(v reflect.Value) ([]int, string, error) {
+	var fields []int
+	var subject string
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get(PersonalDataTag)
+		if tag == "" {
+			continue
+		}
+		if f.Type.Kind() != reflect.String || f.PkgPath != "" {
+			return nil, "", fmt.Errorf("Field %s of event type %s tagged as personal must be an exported string.",
+				f.Name, t.Name())
+		}
+		switch tag {
+		case "data":
+			fields = append(fields, i)
+		case "subject":
+			subject = v.Field(i).String()
+		default:
+			return nil, "", fmt.Errorf("Field %s of event type %s has an unknown personal tag: %s",
+				f.Name, t.Name(), tag)
+		}
+	}
+	return fields, subject, nil
+}
+
+func encryptString(key []byte, plaintext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptString(key []byte, ciphertext string) (string, error) {
+	if key == nil {
+		return "", &ErrKeyNotFound{}
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(ciphertext, encryptedPrefix))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("Encrypted value is too short.")
+	}
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,165 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"encoding/base64"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&PersonalDataEncryptorSuite{})
+
+type PersonalDataEncryptorSuite struct {
+	keys      *MemoryKeyStore
+	encryptor *PersonalDataEncryptor
+}
+
+func (s *PersonalDataEncryptorSuite) SetUpTest(c *C) {
+	s.keys = NewMemoryKeyStore()
+	s.encryptor, _ = NewPersonalDataEncryptor(s.keys)
+}
+
+func (s *PersonalDataEncryptorSuite) TestNewPersonalDataEncryptorWithNilKeyStoreReturnsAnError(c *C) {
+	encryptor, err := NewPersonalDataEncryptor(nil)
+
+	c.Assert(encryptor, IsNil)
+	c.Assert(err, ErrorMatches, "Nil KeyStore injected into personal data encryptor.")
+}
+
+func (s *PersonalDataEncryptorSuite) TestEncryptReturnsAnEncryptedCopy(c *C) {
+	ev := &CustomerRegistered{CustomerID: "c1", Name: "Jane", Country: "UK"}
+
+	got, err := s.encryptor.Encrypt(ev, "aggregate")
+
+	c.Assert(err, IsNil)
+	c.Assert(ev, DeepEquals, &CustomerRegistered{CustomerID: "c1", Name: "Jane", Country: "UK"})
+	enc := got.(*CustomerRegistered)
+	c.Assert(enc.CustomerID, Equals, "c1")
+	c.Assert(enc.Country, Equals, "UK")
+	c.Assert(strings.HasPrefix(enc.Name, "enc:"), Equals, true)
+	c.Assert(strings.Contains(enc.Name, "Jane"), Equals, false)
+
+	_, err = s.keys.Key("c1")
+	c.Assert(err, IsNil)
+}
+
+func (s *PersonalDataEncryptorSuite) TestDecryptReturnsTheOriginalValues(c *C) {
+	ev := &CustomerRegistered{CustomerID: "c1", Name: "Jane", Country: "UK"}
+	enc, _ := s.encryptor.Encrypt(ev, "aggregate")
+
+	got, err := s.encryptor.Decrypt(enc, "aggregate")
+
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, ev)
+}
+
+func (s *PersonalDataEncryptorSuite) TestDeletedKeyRedactsFields(c *C) {
+	enc, _ := s.encryptor.Encrypt(&CustomerRegistered{CustomerID: "c1", Name: "Jane", Country: "UK"}, "aggregate")
+	s.keys.DeleteKey("c1")
+
+	got, err := s.encryptor.Decrypt(enc, "aggregate")
+
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, &CustomerRegistered{CustomerID: "c1", Name: RedactedPlaceholder, Country: "UK"})
+}
+
+func (s *PersonalDataEncryptorSuite) TestTamperedValueReturnsAnError(c *C) {
+	enc, _ := s.encryptor.Encrypt(&CustomerRegistered{CustomerID: "c1", Name: "Jane", Country: "UK"}, "aggregate")
+	tampered := *enc.(*CustomerRegistered)
+	b, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(tampered.Name, "enc:"))
+	b[len(b)-1] ^= 1
+	tampered.Name = "enc:" + base64.StdEncoding.EncodeToString(b)
+
+	got, err := s.encryptor.Decrypt(&tampered, "aggregate")
+
+	c.Assert(got, IsNil)
+	c.Assert(err, ErrorMatches, "Field Name of event type CustomerRegistered can not be decrypted. .*")
+}
+
+func (s *PersonalDataEncryptorSuite) TestCorruptValueReturnsAnError(c *C) {
+	s.keys.CreateKey("c1")
+
+	got, err := s.encryptor.Decrypt(&CustomerRegistered{CustomerID: "c1", Name: "enc:!!!"}, "aggregate")
+
+	c.Assert(got, IsNil)
+	c.Assert(err, ErrorMatches, "Field Name of event type CustomerRegistered can not be decrypted. .*")
+}
+
+func (s *PersonalDataEncryptorSuite) TestShreddedSubjectCanNotBeWrittenAgain(c *C) {
+	old, _ := s.encryptor.Encrypt(&CustomerRegistered{CustomerID: "c1", Name: "Jane"}, "aggregate")
+	s.keys.DeleteKey("c1")
+
+	_, err := s.encryptor.Encrypt(&CustomerRegistered{CustomerID: "c1", Name: "Joan"}, "aggregate")
+	c.Assert(err, DeepEquals, &ErrKeyDeleted{SubjectID: "c1"})
+
+	got, err := s.encryptor.Decrypt(old, "aggregate")
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, &CustomerRegistered{CustomerID: "c1", Name: RedactedPlaceholder})
+}
+
+func (s *PersonalDataEncryptorSuite) TestWrongKeyReturnsAnError(c *C) {
+	enc, _ := s.encryptor.Encrypt(&CustomerRegistered{CustomerID: "c1", Name: "Jane"}, "aggregate")
+	keys := NewMemoryKeyStore()
+	keys.CreateKey("c1")
+	other, _ := NewPersonalDataEncryptor(keys)
+
+	got, err := other.Decrypt(enc, "aggregate")
+
+	c.Assert(got, IsNil)
+	c.Assert(err, ErrorMatches, "Field Name of event type CustomerRegistered can not be decrypted. .*")
+}
+
+func (s *PersonalDataEncryptorSuite) TestAggregateIsTheSubjectOfEventsWithoutASubjectField(c *C) {
+	enc, err := s.encryptor.Encrypt(CustomerRenamed{Name: "Jane"}, "aggregate")
+	c.Assert(err, IsNil)
+
+	_, err = s.keys.Key("aggregate")
+	c.Assert(err, IsNil)
+
+	s.keys.DeleteKey("aggregate")
+	got, err := s.encryptor.Decrypt(enc, "aggregate")
+
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, CustomerRenamed{Name: RedactedPlaceholder})
+}
+
+func (s *PersonalDataEncryptorSuite) TestUnencryptedValuesAreReadUnchanged(c *C) {
+	ev := &CustomerRegistered{CustomerID: "c1", Name: "Jane"}
+
+	got, err := s.encryptor.Decrypt(ev, "aggregate")
+
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, ev)
+}
+
+func (s *PersonalDataEncryptorSuite) TestEventsWithoutPersonalDataAreUnchanged(c *C) {
+	ev := &SomeEvent{Item: "Some Item", Count: 42}
+
+	got, err := s.encryptor.Encrypt(ev, "aggregate")
+
+	c.Assert(err, IsNil)
+	c.Assert(got, Equals, ev)
+}
+
+func (s *PersonalDataEncryptorSuite) TestNonStringPersonalDataReturnsAnError(c *C) {
+	_, err := s.encryptor.Encrypt(&struct {
+		Age int `personal:"data"`
+	}{42}, "aggregate")
+
+	c.Assert(err, NotNil)
+}
+
+type CustomerRegistered struct {
+	CustomerID string `personal:"subject"`
+	Name       string `personal:"data"`
+	Country    string
+}
+
+type CustomerRenamed struct {
+	Name string `personal:"data"`
+}
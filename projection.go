@@ -0,0 +1,222 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Projection feeds the events of an EventFeed through an EventHandler, such as
+// a read model, and records its progress in a CheckpointStore so that it
+// resumes where it left off when the process is restarted.
+//
+// Events are read in batches. The checkpoint is saved after each batch has
+// been handled, so after a failure the events of the last batch may be handled
+// again; read models should be idempotent. Batches of a projection are
+// handled one at a time and the events are handed to the handler in the order
+// of the feed.
+//
+//	projection, err := ycq.NewProjection("inventory-list", view, repo, checkpoints)
+//	...
+//	go projection.Run(stop)
+type Projection struct {
+	mu           sync.Mutex
+	name         string
+	handler      EventHandler
+	feed         EventFeed
+	checkpoints  CheckpointStore
+	batchSize    int
+	pollInterval time.Duration
+	position     int
+	loaded       bool
+}
+
+// NewProjection constructs a new Projection with the name specified. The name
+// identifies the checkpoint of the projection.
+func NewProjection(name string, handler EventHandler, feed EventFeed, checkpoints CheckpointStore) (*Projection, error) {
+	if name == "" {
+		return nil, fmt.Errorf("The projection has no name.")
+	}
+	if handler == nil {
+		return nil, fmt.Errorf("Nil EventHandler injected into projection.")
+	}
+	if feed == nil {
+		return nil, fmt.Errorf("Nil EventFeed injected into projection.")
+	}
+	if checkpoints == nil {
+		return nil, fmt.Errorf("Nil CheckpointStore injected into projection.")
+	}
+
+	return &Projection{
+		name:         name,
+		handler:      handler,
+		feed:         feed,
+		checkpoints:  checkpoints,
+		batchSize:    100,
+		pollInterval: time.Second,
+	}, nil
+}
+
+// SetBatchSize sets the number of positions of the feed read in each batch.
+// The default is 100.
+func (p *Projection) SetBatchSize(size int) {
+	if size > 0 {
+		p.batchSize = size
+	}
+}
+
+// SetPollInterval sets the time that Run waits before reading the feed again
+// once it has caught up. The default is one second.
+func (p *Projection) SetPollInterval(interval time.Duration) {
+	if interval > 0 {
+		p.pollInterval = interval
+	}
+}
+
+// Name returns the name of the projection.
+func (p *Projection) Name() string {
+	return p.name
+}
+
+// Position returns the position in the feed of the next event that the
+// projection will handle.
+func (p *Projection) Position() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.load(); err != nil {
+		return 0, err
+	}
+	return p.position, nil
+}
+
+// Reset sets the checkpoint of the projection back to the start of the feed,
+// so that all of the events are handled again by the next CatchUp.
+//
+// The read model is not changed; it should be cleared before the events are
+// handled again. To rebuild a read model while it continues to serve reads,
+// use a ProjectionRebuild.
+func (p *Projection) Reset() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.checkpoints.SaveCheckpoint(p.name, 0); err != nil {
+		return err
+	}
+	p.position = 0
+	p.loaded = true
+	return nil
+}
+
+// CatchUp handles the events of the feed from the checkpoint of the projection
+// to the end of the feed and returns the number of events handled.
+func (p *Projection) CatchUp() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.load(); err != nil {
+		return 0, err
+	}
+
+	handled := 0
+	for {
+		n, err := p.handleBatch()
+		handled += n
+		if err != nil || n == 0 {
+			return handled, err
+		}
+	}
+}
+
+// Run catches up with the feed and then polls it for new events until the stop
+// channel is closed. An error reading the feed or saving the checkpoint stops
+// the projection and is returned.
+func (p *Projection) Run(stop <-chan struct{}) error {
+	for {
+		if _, err := p.CatchUp(); err != nil {
+			return err
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(p.pollInterval):
+		}
+	}
+}
+
+// load reads the checkpoint of the projection the first time it is needed.
+func (p *Projection) load() error {
+	if p.loaded {
+		return nil
+	}
+	position, err := p.checkpoints.LoadCheckpoint(p.name)
+	if err != nil {
+		return err
+	}
+	p.position = position
+	p.loaded = true
+	return nil
+}
+
+// handleBatch handles a batch of events and saves the checkpoint. The number
+// of events handled is returned.
+func (p *Projection) handleBatch() (int, error) {
+	next, handled, err := readBatch(p.feed, p.name, p.position, p.batchSize, func(v *FeedEvent) error {
+		if err := payloadErr(v.Event); err != nil {
+			return err
+		}
+		p.handler.Handle(v.Event)
+		return nil
+	})
+	if next == p.position {
+		return handled, err
+	}
+	if serr := p.checkpoints.SaveCheckpoint(p.name, next); serr != nil {
+		return handled, serr
+	}
+	p.position = next
+	return handled, err
+}
+
+// readBatch reads a batch of events of a feed from the position specified and
+// passes them in order to fn. Events before the position, which a feed may
+// deliver again, are skipped. The position after the last event passed, or
+// from if there were none, and the number of events passed are returned.
+//
+// If fn returns an error the batch stops before the event for which it was
+// returned.
+func readBatch(feed EventFeed, projection string, from, size int, fn func(*FeedEvent) error) (int, int, error) {
+	events, err := feed.ReadFeed(from, size)
+	if err != nil {
+		return from, 0, err
+	}
+
+	n := 0
+	last := -1
+	for _, v := range events {
+		if v.Position < from {
+			continue
+		}
+		if v.Position < last {
+			err = fmt.Errorf("The feed of projection %s returned position %d after position %d.",
+				projection, v.Position, last)
+			break
+		}
+		if err = fn(v); err != nil {
+			break
+		}
+		n++
+		last = v.Position
+	}
+
+	if last < 0 {
+		return from, n, err
+	}
+	return last + 1, n, err
+}
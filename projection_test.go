@@ -0,0 +1,240 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"fmt"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&ProjectionSuite{})
+
+type ProjectionSuite struct {
+	repo        *InMemoryRepository
+	handler     *MockEventHandler
+	checkpoints *MemoryCheckpointStore
+}
+
+func (s *ProjectionSuite) SetUpTest(c *C) {
+	s.repo, _ = NewInMemoryRepository(NewInternalEventBus())
+	s.handler = NewMockEventHandler()
+	s.checkpoints = NewMemoryCheckpointStore()
+}
+
+func (s *ProjectionSuite) save(c *C, counts ...int) {
+	saveSomeEvents(c, s.repo, counts...)
+}
+
+// saveSomeEvents saves an aggregate with an event for each of the counts.
+func saveSomeEvents(c *C, repo *InMemoryRepository, counts ...int) {
+	agg := NewSomeAggregate(NewUUID())
+	for _, count := range counts {
+		agg.TrackChange(NewEventMessage(agg.AggregateID(), &SomeEvent{"Some data", count}, nil))
+	}
+	c.Assert(repo.Save(agg, nil), IsNil)
+}
+
+func counts(events []EventMessage) []int {
+	var counts []int
+	for _, v := range events {
+		counts = append(counts, v.Event().(*SomeEvent).Count)
+	}
+	return counts
+}
+
+func (s *ProjectionSuite) TestCatchUpHandlesAllEventsInOrder(c *C) {
+	s.save(c, 1, 2)
+	s.save(c, 3)
+	s.save(c, 4, 5, 6)
+	p, err := NewProjection("some-projection", s.handler, s.repo, s.checkpoints)
+	c.Assert(err, IsNil)
+	p.SetBatchSize(2)
+
+	handled, err := p.CatchUp()
+
+	c.Assert(err, IsNil)
+	c.Assert(handled, Equals, 6)
+	c.Assert(counts(s.handler.events), DeepEquals, []int{1, 2, 3, 4, 5, 6})
+	position, _ := s.checkpoints.LoadCheckpoint("some-projection")
+	c.Assert(position, Equals, 6)
+}
+
+func (s *ProjectionSuite) TestCatchUpHandlesOnlyNewEvents(c *C) {
+	s.save(c, 1, 2)
+	p, _ := NewProjection("some-projection", s.handler, s.repo, s.checkpoints)
+	p.CatchUp()
+	s.save(c, 3)
+
+	handled, err := p.CatchUp()
+
+	c.Assert(err, IsNil)
+	c.Assert(handled, Equals, 1)
+	c.Assert(counts(s.handler.events), DeepEquals, []int{1, 2, 3})
+}
+
+func (s *ProjectionSuite) TestProjectionsResumeFromTheirCheckpoint(c *C) {
+	s.save(c, 1, 2)
+	first, _ := NewProjection("some-projection", s.handler, s.repo, s.checkpoints)
+	first.CatchUp()
+	s.save(c, 3, 4)
+
+	// A new projection with the same name, as after a restart.
+	handler := NewMockEventHandler()
+	second, _ := NewProjection("some-projection", handler, s.repo, s.checkpoints)
+	position, err := second.Position()
+	c.Assert(err, IsNil)
+	c.Assert(position, Equals, 2)
+
+	second.CatchUp()
+
+	c.Assert(counts(handler.events), DeepEquals, []int{3, 4})
+}
+
+func (s *ProjectionSuite) TestProjectionsHaveTheirOwnCheckpoints(c *C) {
+	s.save(c, 1, 2)
+	first, _ := NewProjection("some-projection", s.handler, s.repo, s.checkpoints)
+	first.CatchUp()
+
+	handler := NewMockEventHandler()
+	second, _ := NewProjection("some-other-projection", handler, s.repo, s.checkpoints)
+	second.CatchUp()
+
+	c.Assert(counts(handler.events), DeepEquals, []int{1, 2})
+}
+
+func (s *ProjectionSuite) TestCheckpointsAreSavedAfterEachBatch(c *C) {
+	s.save(c, 1, 2, 3)
+	checkpoints := &RecordingCheckpointStore{MemoryCheckpointStore: NewMemoryCheckpointStore()}
+	p, _ := NewProjection("some-projection", s.handler, s.repo, checkpoints)
+	p.SetBatchSize(2)
+
+	p.CatchUp()
+
+	c.Assert(checkpoints.saved, DeepEquals, []int{2, 3})
+}
+
+func (s *ProjectionSuite) TestEventsBeforeTheCheckpointAreSkipped(c *C) {
+	feed := &FakeFeed{redeliver: true, events: []*FeedEvent{
+		{Position: 3, Event: NewEventMessage("", &SomeEvent{"Some data", 3}, nil)},
+		{Position: 4, Event: NewEventMessage("", &SomeEvent{"Some data", 4}, nil)},
+		{Position: 5, Event: NewEventMessage("", &SomeEvent{"Some data", 5}, nil)},
+	}}
+	s.checkpoints.SaveCheckpoint("some-projection", 4)
+	p, _ := NewProjection("some-projection", s.handler, feed, s.checkpoints)
+
+	p.CatchUp()
+
+	c.Assert(counts(s.handler.events), DeepEquals, []int{4, 5})
+}
+
+func (s *ProjectionSuite) TestEventsOutOfOrderStopTheProjection(c *C) {
+	feed := &FakeFeed{events: []*FeedEvent{
+		{Position: 0, Event: NewEventMessage("", &SomeEvent{"Some data", 0}, nil)},
+		{Position: 2, Event: NewEventMessage("", &SomeEvent{"Some data", 2}, nil)},
+		{Position: 1, Event: NewEventMessage("", &SomeEvent{"Some data", 1}, nil)},
+	}}
+	p, _ := NewProjection("some-projection", s.handler, feed, s.checkpoints)
+
+	handled, err := p.CatchUp()
+
+	c.Assert(err, ErrorMatches, "The feed of projection some-projection returned position 1 after position 2.")
+	c.Assert(handled, Equals, 2)
+	position, _ := s.checkpoints.LoadCheckpoint("some-projection")
+	c.Assert(position, Equals, 3)
+}
+
+func (s *ProjectionSuite) TestUnreadablePayloadsStopTheProjection(c *C) {
+	feed := &FakeFeed{events: []*FeedEvent{
+		{Position: 0, Event: NewEventMessage("", &SomeEvent{"Some data", 0}, nil)},
+		{Position: 1, Event: NewClaimCheckEventMessage("", "SomeEvent", nil, func() (interface{}, error) {
+			return nil, &ErrBlobIntegrity{Reference: &BlobReference{ID: "some-blob"}}
+		})},
+		{Position: 2, Event: NewEventMessage("", &SomeEvent{"Some data", 2}, nil)},
+	}}
+	p, _ := NewProjection("some-projection", s.handler, feed, s.checkpoints)
+
+	handled, err := p.CatchUp()
+
+	c.Assert(err, FitsTypeOf, &ErrBlobIntegrity{})
+	c.Assert(handled, Equals, 1)
+	position, _ := s.checkpoints.LoadCheckpoint("some-projection")
+	c.Assert(position, Equals, 1)
+}
+
+func (s *ProjectionSuite) TestFeedErrorsAreReturned(c *C) {
+	feed := &FakeFeed{err: fmt.Errorf("Some error")}
+	p, _ := NewProjection("some-projection", s.handler, feed, s.checkpoints)
+
+	_, err := p.CatchUp()
+
+	c.Assert(err, ErrorMatches, "Some error")
+}
+
+func (s *ProjectionSuite) TestRunHandlesNewEventsUntilStopped(c *C) {
+	s.save(c, 1)
+	p, _ := NewProjection("some-projection", s.handler, s.repo, s.checkpoints)
+	p.SetPollInterval(time.Millisecond)
+	stop := make(chan struct{})
+	done := make(chan error)
+
+	go func() { done <- p.Run(stop) }()
+	s.save(c, 2)
+	for {
+		if position, _ := s.checkpoints.LoadCheckpoint("some-projection"); position == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+
+	c.Assert(<-done, IsNil)
+	c.Assert(counts(s.handler.events), DeepEquals, []int{1, 2})
+}
+
+func (s *ProjectionSuite) TestNewProjectionChecksItsDependencies(c *C) {
+	_, err := NewProjection("", s.handler, s.repo, s.checkpoints)
+	c.Assert(err, ErrorMatches, "The projection has no name.")
+	_, err = NewProjection("p", nil, s.repo, s.checkpoints)
+	c.Assert(err, ErrorMatches, "Nil EventHandler injected into projection.")
+	_, err = NewProjection("p", s.handler, nil, s.checkpoints)
+	c.Assert(err, ErrorMatches, "Nil EventFeed injected into projection.")
+	_, err = NewProjection("p", s.handler, s.repo, nil)
+	c.Assert(err, ErrorMatches, "Nil CheckpointStore injected into projection.")
+}
+
+// FakeFeed is an EventFeed that returns the events it holds from the
+// position requested or, if it redelivers, all of its events.
+type FakeFeed struct {
+	events    []*FeedEvent
+	redeliver bool
+	err       error
+}
+
+func (f *FakeFeed) ReadFeed(from int, max int) ([]*FeedEvent, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var events []*FeedEvent
+	for _, v := range f.events {
+		if (f.redeliver || v.Position >= from) && len(events) < max {
+			events = append(events, v)
+		}
+	}
+	return events, nil
+}
+
+// RecordingCheckpointStore records the checkpoints saved.
+type RecordingCheckpointStore struct {
+	*MemoryCheckpointStore
+	saved []int
+}
+
+func (s *RecordingCheckpointStore) SaveCheckpoint(projection string, position int) error {
+	s.saved = append(s.saved, position)
+	return s.MemoryCheckpointStore.SaveCheckpoint(projection, position)
+}
@@ -0,0 +1,96 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"fmt"
+)
+
+// ResetProjection is a command to set the checkpoint of a projection back to
+// the start of its feed. The command is addressed to the projection by its
+// name in place of an aggregate id.
+type ResetProjection struct{}
+
+// RebuildProjection is a command to rebuild the read model of a projection
+// with a ProjectionRebuild. The command is addressed to the projection by its
+// name in place of an aggregate id.
+type RebuildProjection struct {
+
+	// EventTypes are the types of the events replayed. All events are
+	// replayed if there are none.
+	EventTypes []string
+}
+
+// ProjectionCommandHandler handles the ResetProjection and RebuildProjection
+// commands for a set of projections, so that projections can be reset and
+// rebuilt by dispatching commands, for instance from an administrative tool.
+//
+//	handler, err := ycq.NewProjectionCommandHandler(listProjection, detailProjection)
+//	dispatcher.RegisterHandler(handler, &ycq.ResetProjection{}, &ycq.RebuildProjection{})
+//	...
+//	dispatcher.Dispatch(ycq.NewCommandMessage("inventory-list", &ycq.RebuildProjection{}))
+//
+// Rebuilds run as the command is handled, so Dispatch returns once the
+// rebuilt read model is in use.
+type ProjectionCommandHandler struct {
+	projections map[string]*Projection
+	progress    func(RebuildProgress)
+}
+
+// NewProjectionCommandHandler constructs a new ProjectionCommandHandler for
+// the projections specified by the variadic projections parameter.
+func NewProjectionCommandHandler(projections ...*Projection) (*ProjectionCommandHandler, error) {
+	h := &ProjectionCommandHandler{
+		projections: make(map[string]*Projection),
+	}
+	for _, p := range projections {
+		if p == nil {
+			return nil, fmt.Errorf("Nil Projection injected into projection command handler.")
+		}
+		if _, ok := h.projections[p.name]; ok {
+			return nil, fmt.Errorf("Projection already registered with projection command handler: \"%s\"", p.name)
+		}
+		h.projections[p.name] = p
+	}
+	return h, nil
+}
+
+// SetProgressHook sets a function that is called with the progress of the
+// rebuilds run by the handler.
+func (h *ProjectionCommandHandler) SetProgressHook(hook func(RebuildProgress)) {
+	h.progress = hook
+}
+
+// Handle resets or rebuilds the projection named by the aggregate id of the
+// command.
+func (h *ProjectionCommandHandler) Handle(message CommandMessage) error {
+	p, ok := h.projections[message.AggregateID()]
+	if !ok {
+		return &ErrCommandExecution{Command: message,
+			Reason: fmt.Sprintf("There is no projection named %s.", message.AggregateID())}
+	}
+
+	switch cmd := message.Command().(type) {
+
+	case *ResetProjection:
+		return p.Reset()
+
+	case *RebuildProjection:
+		rebuild, err := NewProjectionRebuild(p)
+		if err != nil {
+			return &ErrCommandExecution{Command: message, Reason: err.Error()}
+		}
+		if len(cmd.EventTypes) > 0 {
+			rebuild.SetFilter(EventTypeFilter(cmd.EventTypes...))
+		}
+		rebuild.SetProgressHook(h.progress)
+		return rebuild.Run()
+
+	default:
+		return &ErrCommandExecution{Command: message,
+			Reason: fmt.Sprintf("The projection command handler can not handle commands of type %s.", message.CommandType())}
+	}
+}
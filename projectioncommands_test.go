@@ -0,0 +1,82 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&ProjectionCommandSuite{})
+
+type ProjectionCommandSuite struct {
+	repo       *InMemoryRepository
+	model      *SwappableEventHandler
+	projection *Projection
+	dispatcher *InMemoryDispatcher
+}
+
+func (s *ProjectionCommandSuite) SetUpTest(c *C) {
+	s.repo, _ = NewInMemoryRepository(NewInternalEventBus())
+	s.model, _ = NewSwappableEventHandler(func() EventHandler { return NewMockEventHandler() })
+	s.projection, _ = NewProjection("some-projection", s.model, s.repo, NewMemoryCheckpointStore())
+	handler, err := NewProjectionCommandHandler(s.projection)
+	c.Assert(err, IsNil)
+	s.dispatcher = NewInMemoryDispatcher()
+	c.Assert(s.dispatcher.RegisterHandler(handler, &ResetProjection{}, &RebuildProjection{}), IsNil)
+}
+
+func (s *ProjectionCommandSuite) save(c *C, counts ...int) {
+	saveSomeEvents(c, s.repo, counts...)
+}
+
+func (s *ProjectionCommandSuite) current() *MockEventHandler {
+	return s.model.Current().(*MockEventHandler)
+}
+
+func (s *ProjectionCommandSuite) TestResetProjectionCommand(c *C) {
+	s.save(c, 1)
+	s.projection.CatchUp()
+
+	err := s.dispatcher.Dispatch(NewCommandMessage("some-projection", &ResetProjection{}))
+
+	c.Assert(err, IsNil)
+	position, _ := s.projection.Position()
+	c.Assert(position, Equals, 0)
+}
+
+func (s *ProjectionCommandSuite) TestRebuildProjectionCommand(c *C) {
+	s.save(c, 1, 2)
+	live := s.current()
+
+	err := s.dispatcher.Dispatch(NewCommandMessage("some-projection", &RebuildProjection{}))
+
+	c.Assert(err, IsNil)
+	c.Assert(s.current(), Not(Equals), live)
+	c.Assert(counts(s.current().events), DeepEquals, []int{1, 2})
+}
+
+func (s *ProjectionCommandSuite) TestRebuildProjectionCommandWithEventTypes(c *C) {
+	s.save(c, 1, 2)
+
+	err := s.dispatcher.Dispatch(NewCommandMessage("some-projection",
+		&RebuildProjection{EventTypes: []string{"SomeOtherEvent"}}))
+
+	c.Assert(err, IsNil)
+	c.Assert(s.current().events, HasLen, 0)
+}
+
+func (s *ProjectionCommandSuite) TestCommandsForUnknownProjectionsFail(c *C) {
+	err := s.dispatcher.Dispatch(NewCommandMessage("some-other-projection", &ResetProjection{}))
+
+	c.Assert(err, FitsTypeOf, &ErrCommandExecution{})
+	c.Assert(err, ErrorMatches, ".*There is no projection named some-other-projection.")
+}
+
+func (s *ProjectionCommandSuite) TestProjectionsMayBeRegisteredOnce(c *C) {
+	_, err := NewProjectionCommandHandler(s.projection, s.projection)
+
+	c.Assert(err, ErrorMatches, "Projection already registered with projection command handler: \"some-projection\"")
+}
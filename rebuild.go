@@ -0,0 +1,273 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Rebuildable is implemented by read models that can be rebuilt into a shadow
+// copy while they continue to serve reads.
+//
+// Shadow returns a new, empty copy of the read model, such as a new table or
+// a new in memory model, into which the events are replayed. Swap replaces the
+// read model with the shadow atomically, so that reads see either the old or
+// the rebuilt read model and never a partly rebuilt one.
+type Rebuildable interface {
+	EventHandler
+	Shadow() (EventHandler, error)
+	Swap(shadow EventHandler) error
+}
+
+// SwappableEventHandler is a Rebuildable read model that delegates to an
+// EventHandler which can be replaced. A new instance of the read model is
+// made for each shadow.
+//
+// Reads should be made through Current, so that they see the read model that
+// is in use.
+type SwappableEventHandler struct {
+	mu         sync.RWMutex
+	current    EventHandler
+	newHandler func() EventHandler
+}
+
+// NewSwappableEventHandler constructs a new SwappableEventHandler with a
+// delegate that makes a new, empty instance of the read model.
+func NewSwappableEventHandler(newHandler func() EventHandler) (*SwappableEventHandler, error) {
+	if newHandler == nil {
+		return nil, fmt.Errorf("Nil delegate injected into swappable event handler.")
+	}
+	current := newHandler()
+	if current == nil {
+		return nil, fmt.Errorf("The delegate of the swappable event handler returned nil.")
+	}
+	return &SwappableEventHandler{
+		current:    current,
+		newHandler: newHandler,
+	}, nil
+}
+
+// Handle passes the event to the read model in use.
+func (h *SwappableEventHandler) Handle(em EventMessage) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	h.current.Handle(em)
+}
+
+// Current returns the read model in use.
+func (h *SwappableEventHandler) Current() EventHandler {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.current
+}
+
+// Shadow returns a new instance of the read model.
+func (h *SwappableEventHandler) Shadow() (EventHandler, error) {
+	shadow := h.newHandler()
+	if shadow == nil {
+		return nil, fmt.Errorf("The delegate of the swappable event handler returned nil.")
+	}
+	return shadow, nil
+}
+
+// Swap replaces the read model in use with the shadow. Events being handled
+// are handled by the old read model before the swap is made.
+func (h *SwappableEventHandler) Swap(shadow EventHandler) error {
+	if shadow == nil {
+		return fmt.Errorf("Can not swap a nil read model into the swappable event handler.")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.current = shadow
+	return nil
+}
+
+// RebuildProgress reports the progress of a ProjectionRebuild.
+type RebuildProgress struct {
+	Projection string
+
+	// Position is the position in the feed reached by the replay.
+	Position int
+
+	// Head is the position that followed the last event of the feed when
+	// the rebuild started, or -1 if the feed does not implement FeedHead.
+	Head int
+
+	// Replayed is the number of events replayed into the shadow so far.
+	// Events excluded by the filter are not counted.
+	Replayed int
+
+	Elapsed time.Duration
+
+	// ETA is an estimate of the time remaining to reach Head, or -1 if it
+	// can not be estimated.
+	ETA time.Duration
+
+	// Swapped is true for the report made once the rebuilt read model is in
+	// use.
+	Swapped bool
+}
+
+// ProjectionRebuild rebuilds the read model of a Projection from the whole of
+// its feed.
+//
+// The events are replayed into a shadow copy of the read model while the
+// projection continues to update the read model in use. Once the replay has
+// caught up, the projection is paused, the events that arrived since are
+// replayed into the shadow, the checkpoint is set to the end of the replay and
+// the shadow is swapped in. Events that arrive during the swap are held in the
+// feed and are handled by the rebuilt read model when the projection resumes,
+// so no event is missed.
+//
+// The handler of the projection must implement Rebuildable.
+type ProjectionRebuild struct {
+	projection *Projection
+	model      Rebuildable
+	filter     func(EventMessage) bool
+	progress   func(RebuildProgress)
+}
+
+// NewProjectionRebuild constructs a new ProjectionRebuild of the projection
+// specified.
+func NewProjectionRebuild(projection *Projection) (*ProjectionRebuild, error) {
+	if projection == nil {
+		return nil, fmt.Errorf("Nil Projection injected into rebuild.")
+	}
+	model, ok := projection.handler.(Rebuildable)
+	if !ok {
+		return nil, fmt.Errorf("The read model of projection %s of type %T can not be rebuilt.",
+			projection.name, projection.handler)
+	}
+	return &ProjectionRebuild{
+		projection: projection,
+		model:      model,
+	}, nil
+}
+
+// SetFilter sets a function that selects the events that are replayed. Only
+// events for which it returns true are replayed into the shadow.
+func (r *ProjectionRebuild) SetFilter(filter func(EventMessage) bool) {
+	r.filter = filter
+}
+
+// SetProgressHook sets a function that is called with the progress of the
+// rebuild after each batch of events is replayed and once the rebuilt read
+// model is in use.
+func (r *ProjectionRebuild) SetProgressHook(hook func(RebuildProgress)) {
+	r.progress = hook
+}
+
+// Run rebuilds the read model. If the rebuild fails the read model in use and
+// the checkpoint of the projection are not changed.
+func (r *ProjectionRebuild) Run() error {
+	p := r.projection
+	shadow, err := r.model.Shadow()
+	if err != nil {
+		return err
+	}
+
+	progress := RebuildProgress{Projection: p.name, Head: -1, ETA: -1}
+	if head, ok := p.feed.(FeedHead); ok {
+		if progress.Head, err = head.FeedHead(); err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	replay := func(v *FeedEvent) error {
+		if r.filter == nil || r.filter(v.Event) {
+			if err := payloadErr(v.Event); err != nil {
+				return err
+			}
+			shadow.Handle(v.Event)
+			progress.Replayed++
+		}
+		return nil
+	}
+
+	// The events are replayed while the projection continues to run.
+	for {
+		next, n, err := readBatch(p.feed, p.name, progress.Position, p.batchSize, replay)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		progress.Position = next
+		r.report(progress, start)
+	}
+
+	// The projection is paused while the shadow catches up with the events
+	// saved during the replay and is swapped in.
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		next, n, err := readBatch(p.feed, p.name, progress.Position, p.batchSize, replay)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		progress.Position = next
+	}
+
+	// The checkpoint is saved before the swap, so that the read model in use
+	// never runs ahead of its checkpoint. If the swap fails the checkpoint of
+	// the read model in use is restored.
+	if err := p.load(); err != nil {
+		return err
+	}
+	if err := p.checkpoints.SaveCheckpoint(p.name, progress.Position); err != nil {
+		return err
+	}
+	if err := r.model.Swap(shadow); err != nil {
+		if rerr := p.checkpoints.SaveCheckpoint(p.name, p.position); rerr != nil {
+			return fmt.Errorf("The read model of projection %s could not be swapped and its checkpoint could not be restored to %d. %s %s",
+				p.name, p.position, err, rerr)
+		}
+		return err
+	}
+	p.position = progress.Position
+	p.loaded = true
+
+	progress.Swapped = true
+	r.report(progress, start)
+	return nil
+}
+
+// report calls the progress hook with the elapsed time and an estimate of the
+// time remaining.
+func (r *ProjectionRebuild) report(progress RebuildProgress, start time.Time) {
+	if r.progress == nil {
+		return
+	}
+	progress.Elapsed = time.Since(start)
+	switch {
+	case progress.Swapped || (progress.Head >= 0 && progress.Position >= progress.Head):
+		progress.ETA = 0
+	case progress.Head > 0 && progress.Position > 0:
+		perPosition := progress.Elapsed / time.Duration(progress.Position)
+		progress.ETA = perPosition * time.Duration(progress.Head-progress.Position)
+	}
+	r.progress(progress)
+}
+
+// EventTypeFilter returns a filter for a ProjectionRebuild that selects the
+// events of the types specified.
+func EventTypeFilter(eventTypes ...string) func(EventMessage) bool {
+	types := make(map[string]bool)
+	for _, v := range eventTypes {
+		types[ResolveTypeName(v)] = true
+	}
+	return func(em EventMessage) bool {
+		return types[ResolveTypeName(em.EventType())]
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"fmt"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&RebuildSuite{})
+
+type RebuildSuite struct {
+	repo        *InMemoryRepository
+	handler     *MockEventHandler
+	checkpoints *MemoryCheckpointStore
+	model       *SwappableEventHandler
+	projection  *Projection
+}
+
+func (s *RebuildSuite) SetUpTest(c *C) {
+	s.repo, _ = NewInMemoryRepository(NewInternalEventBus())
+	s.handler = NewMockEventHandler()
+	s.checkpoints = NewMemoryCheckpointStore()
+	var err error
+	s.model, err = NewSwappableEventHandler(func() EventHandler { return NewMockEventHandler() })
+	c.Assert(err, IsNil)
+	s.projection, _ = NewProjection("some-projection", s.model, s.repo, s.checkpoints)
+}
+
+func (s *RebuildSuite) save(c *C, counts ...int) {
+	saveSomeEvents(c, s.repo, counts...)
+}
+
+func (s *RebuildSuite) current() *MockEventHandler {
+	return s.model.Current().(*MockEventHandler)
+}
+
+func (s *RebuildSuite) TestRebuildReplaysAllEventsIntoANewReadModel(c *C) {
+	s.save(c, 1, 2, 3)
+	s.projection.CatchUp()
+	live := s.current()
+	rebuild, err := NewProjectionRebuild(s.projection)
+	c.Assert(err, IsNil)
+
+	c.Assert(rebuild.Run(), IsNil)
+
+	c.Assert(s.current(), Not(Equals), live)
+	c.Assert(counts(s.current().events), DeepEquals, []int{1, 2, 3})
+	c.Assert(counts(live.events), DeepEquals, []int{1, 2, 3})
+	position, _ := s.projection.Position()
+	c.Assert(position, Equals, 3)
+}
+
+func (s *RebuildSuite) TestEventsSavedDuringTheRebuildAreNotMissed(c *C) {
+	s.save(c, 1, 2, 3)
+	s.projection.SetBatchSize(1)
+	rebuild, _ := NewProjectionRebuild(s.projection)
+	saved := false
+	rebuild.SetProgressHook(func(RebuildProgress) {
+		if !saved {
+			saved = true
+			s.save(c, 4)
+		}
+	})
+
+	c.Assert(rebuild.Run(), IsNil)
+	s.save(c, 5)
+	s.projection.CatchUp()
+
+	c.Assert(counts(s.current().events), DeepEquals, []int{1, 2, 3, 4, 5})
+}
+
+func (s *RebuildSuite) TestRebuildReportsProgress(c *C) {
+	s.save(c, 1, 2, 3, 4, 5)
+	s.projection.SetBatchSize(2)
+	rebuild, _ := NewProjectionRebuild(s.projection)
+	var reports []RebuildProgress
+	rebuild.SetProgressHook(func(p RebuildProgress) { reports = append(reports, p) })
+
+	c.Assert(rebuild.Run(), IsNil)
+
+	c.Assert(reports, HasLen, 4)
+	var positions []int
+	for _, v := range reports {
+		c.Assert(v.Projection, Equals, "some-projection")
+		c.Assert(v.Head, Equals, 5)
+		c.Assert(v.ETA >= 0, Equals, true)
+		positions = append(positions, v.Position)
+	}
+	c.Assert(positions, DeepEquals, []int{2, 4, 5, 5})
+	c.Assert(reports[2].Swapped, Equals, false)
+	c.Assert(reports[3].Swapped, Equals, true)
+	c.Assert(reports[3].Replayed, Equals, 5)
+	c.Assert(reports[3].ETA, Equals, reports[2].ETA)
+}
+
+func (s *RebuildSuite) TestProgressWithoutAFeedHeadHasNoETA(c *C) {
+	feed := &FakeFeed{events: []*FeedEvent{
+		{Position: 0, Event: NewEventMessage("", &SomeEvent{"Some data", 0}, nil)},
+		{Position: 1, Event: NewEventMessage("", &SomeEvent{"Some data", 1}, nil)},
+	}}
+	p, _ := NewProjection("some-projection", s.model, feed, s.checkpoints)
+	p.SetBatchSize(1)
+	rebuild, _ := NewProjectionRebuild(p)
+	var reports []RebuildProgress
+	rebuild.SetProgressHook(func(p RebuildProgress) { reports = append(reports, p) })
+
+	c.Assert(rebuild.Run(), IsNil)
+
+	c.Assert(reports[0].Head, Equals, -1)
+	c.Assert(reports[0].ETA < 0, Equals, true)
+}
+
+func (s *RebuildSuite) TestRebuildReplaysOnlyFilteredEvents(c *C) {
+	agg := NewSomeAggregate(NewUUID())
+	agg.TrackChange(NewEventMessage(agg.AggregateID(), &SomeEvent{"Some data", 1}, nil))
+	agg.TrackChange(NewEventMessage(agg.AggregateID(), &SomeOtherEvent{"Some order"}, nil))
+	agg.TrackChange(NewEventMessage(agg.AggregateID(), &SomeEvent{"Some data", 2}, nil))
+	c.Assert(s.repo.Save(agg, nil), IsNil)
+	rebuild, _ := NewProjectionRebuild(s.projection)
+	rebuild.SetFilter(EventTypeFilter("SomeOtherEvent"))
+
+	c.Assert(rebuild.Run(), IsNil)
+
+	c.Assert(s.current().events, HasLen, 1)
+	c.Assert(s.current().events[0].Event(), DeepEquals, &SomeOtherEvent{"Some order"})
+	position, _ := s.projection.Position()
+	c.Assert(position, Equals, 3)
+}
+
+func (s *RebuildSuite) TestFailedRebuildsLeaveTheReadModelInUse(c *C) {
+	s.checkpoints.SaveCheckpoint("some-projection", 7)
+	feed := &FakeFeed{err: fmt.Errorf("Some error")}
+	p, _ := NewProjection("some-projection", s.model, feed, s.checkpoints)
+	live := s.current()
+	rebuild, _ := NewProjectionRebuild(p)
+
+	err := rebuild.Run()
+
+	c.Assert(err, ErrorMatches, "Some error")
+	c.Assert(s.current(), Equals, live)
+	position, _ := s.checkpoints.LoadCheckpoint("some-projection")
+	c.Assert(position, Equals, 7)
+}
+
+func (s *RebuildSuite) TestRebuildsThatCanNotSaveTheCheckpointLeaveTheReadModelInUse(c *C) {
+	s.save(c, 1, 2, 3)
+	s.projection.CatchUp()
+	live := s.current()
+	checkpoints := &FailingCheckpointStore{MemoryCheckpointStore: s.checkpoints, err: fmt.Errorf("Some error")}
+	p, _ := NewProjection("some-projection", s.model, s.repo, checkpoints)
+	s.save(c, 4)
+	rebuild, _ := NewProjectionRebuild(p)
+
+	err := rebuild.Run()
+
+	c.Assert(err, ErrorMatches, "Some error")
+	c.Assert(s.current(), Equals, live)
+	position, _ := s.checkpoints.LoadCheckpoint("some-projection")
+	c.Assert(position, Equals, 3)
+}
+
+func (s *RebuildSuite) TestFailedSwapsRestoreTheCheckpoint(c *C) {
+	s.save(c, 1, 2, 3)
+	s.projection.CatchUp()
+	s.save(c, 4)
+	model := &FailingSwapReadModel{SwappableEventHandler: s.model}
+	p, _ := NewProjection("some-projection", model, s.repo, s.checkpoints)
+	rebuild, _ := NewProjectionRebuild(p)
+
+	err := rebuild.Run()
+
+	c.Assert(err, ErrorMatches, "Some swap error")
+	position, _ := p.Position()
+	c.Assert(position, Equals, 3)
+	position, _ = s.checkpoints.LoadCheckpoint("some-projection")
+	c.Assert(position, Equals, 3)
+}
+
+// FailingCheckpointStore is a CheckpointStore that loads checkpoints but
+// fails to save them.
+type FailingCheckpointStore struct {
+	*MemoryCheckpointStore
+	err error
+}
+
+func (s *FailingCheckpointStore) SaveCheckpoint(projection string, position int) error {
+	return s.err
+}
+
+// FailingSwapReadModel is a read model that can not swap in its shadow.
+type FailingSwapReadModel struct {
+	*SwappableEventHandler
+}
+
+func (m *FailingSwapReadModel) Swap(shadow EventHandler) error {
+	return fmt.Errorf("Some swap error")
+}
+
+func (s *RebuildSuite) TestReadModelsMustBeRebuildable(c *C) {
+	p, _ := NewProjection("some-projection", s.handler, s.repo, s.checkpoints)
+
+	_, err := NewProjectionRebuild(p)
+
+	c.Assert(err, ErrorMatches, "The read model of projection some-projection of type \\*ycq.MockEventHandler can not be rebuilt.")
+}
+
+func (s *RebuildSuite) TestResetReplaysAllEventsIntoTheReadModel(c *C) {
+	s.save(c, 1, 2)
+	s.projection.CatchUp()
+
+	c.Assert(s.projection.Reset(), IsNil)
+	s.projection.CatchUp()
+
+	c.Assert(counts(s.current().events), DeepEquals, []int{1, 2, 1, 2})
+}
+
+func (s *RebuildSuite) TestSwappableEventHandlerRequiresADelegate(c *C) {
+	_, err := NewSwappableEventHandler(nil)
+	c.Assert(err, ErrorMatches, "Nil delegate injected into swappable event handler.")
+
+	_, err = NewSwappableEventHandler(func() EventHandler { return nil })
+	c.Assert(err, ErrorMatches, "The delegate of the swappable event handler returned nil.")
+}
@@ -0,0 +1,377 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"fmt"
+)
+
+// TypeRegistry holds the aggregates of a bounded context together with their
+// events, commands and stream naming, so that they are declared once.
+//
+// The registry is validated for completeness and then builds the aggregate
+// factory, event factory and stream namer used by repositories and registers
+// command handlers with dispatchers and event handlers with event buses.
+//
+//	registry := ycq.NewTypeRegistry()
+//	registry.Aggregate(&InventoryItem{},
+//		func(id string) ycq.AggregateRoot { return NewInventoryItem(id) }).
+//		Event(&InventoryItemCreated{}, func() interface{} { return &InventoryItemCreated{} }).
+//		Commands(handlers, &CreateInventoryItem{}).
+//		StreamName(func(t, id string) string { return t + "-" + id })
+//	err := registry.ConfigureRepository(repo)
+type TypeRegistry struct {
+	aggregates    []*AggregateRegistration
+	eventHandlers []eventHandlerRegistration
+	streamName    func(string, string) string
+	errs          []error
+}
+
+// AggregateRegistration declares the events, commands and stream naming of an
+// aggregate type registered with a TypeRegistry.
+type AggregateRegistration struct {
+	registry   *TypeRegistry
+	aggregate  AggregateRoot
+	typeName   string
+	delegate   func(string) AggregateRoot
+	events     []eventRegistration
+	commands   []commandRegistration
+	streamName func(string, string) string
+}
+
+type eventRegistration struct {
+	typeName string
+	event    interface{}
+	delegate func() interface{}
+}
+
+type commandRegistration struct {
+	typeName string
+	command  interface{}
+	handler  CommandHandler
+}
+
+type eventHandlerRegistration struct {
+	handler EventHandler
+	events  []interface{}
+}
+
+// NewTypeRegistry constructs a new TypeRegistry.
+func NewTypeRegistry() *TypeRegistry {
+	return &TypeRegistry{}
+}
+
+// Aggregate registers an aggregate type with the delegate that instantiates
+// it and returns the registration on which its events, commands and stream
+// naming are declared.
+//
+// Mistakes in registration are reported by Validate.
+func (r *TypeRegistry) Aggregate(aggregate AggregateRoot, delegate func(string) AggregateRoot) *AggregateRegistration {
+	a := &AggregateRegistration{
+		registry:  r,
+		aggregate: aggregate,
+		delegate:  delegate,
+	}
+	a.typeName = r.typeName(aggregate)
+	if delegate == nil {
+		r.errs = append(r.errs, fmt.Errorf("Nil delegate registered for aggregate type: \"%s\"", a.typeName))
+	}
+	r.aggregates = append(r.aggregates, a)
+	return a
+}
+
+// SetDefaultStreamName sets the stream name delegate used for aggregates that
+// do not declare their own.
+func (r *TypeRegistry) SetDefaultStreamName(delegate func(string, string) string) {
+	r.streamName = delegate
+}
+
+// EventHandler registers an event handler, such as a read model, for the
+// events specified. The events must be registered with an aggregate.
+func (r *TypeRegistry) EventHandler(handler EventHandler, events ...interface{}) {
+	if handler == nil {
+		r.errs = append(r.errs, fmt.Errorf("Nil event handler registered."))
+		return
+	}
+	r.eventHandlers = append(r.eventHandlers, eventHandlerRegistration{handler: handler, events: events})
+}
+
+// Event registers an event of the aggregate with the delegate that
+// instantiates it.
+func (a *AggregateRegistration) Event(event interface{}, delegate func() interface{}) *AggregateRegistration {
+	typeName := a.registry.typeName(event)
+	if delegate == nil {
+		a.registry.errs = append(a.registry.errs, fmt.Errorf("Nil delegate registered for event type: \"%s\"", typeName))
+	}
+	a.events = append(a.events, eventRegistration{typeName: typeName, event: event, delegate: delegate})
+	return a
+}
+
+// Events registers the events of the aggregate specified by the variadic
+// events parameter. Instances are created by reflection as they are by
+// DelegateEventFactory.RegisterEvents, so events must be given as pointers.
+func (a *AggregateRegistration) Events(events ...interface{}) *AggregateRegistration {
+	for _, event := range events {
+		delegate, err := newEventDelegate(event)
+		if err != nil {
+			a.registry.errs = append(a.registry.errs, err)
+			continue
+		}
+		a.Event(event, delegate)
+	}
+	return a
+}
+
+// Commands registers the handler of the commands of the aggregate specified by
+// the variadic commands parameter.
+func (a *AggregateRegistration) Commands(handler CommandHandler, commands ...interface{}) *AggregateRegistration {
+	if handler == nil {
+		a.registry.errs = append(a.registry.errs, fmt.Errorf("Nil command handler registered for aggregate type: \"%s\"", a.typeName))
+	}
+	for _, command := range commands {
+		a.commands = append(a.commands, commandRegistration{
+			typeName: a.registry.typeName(command),
+			command:  command,
+			handler:  handler,
+		})
+	}
+	return a
+}
+
+// StreamName sets the stream name delegate of the aggregate.
+func (a *AggregateRegistration) StreamName(delegate func(string, string) string) *AggregateRegistration {
+	a.streamName = delegate
+	return a
+}
+
+// typeName names a registered type, recording an error if it can not be
+// named.
+func (r *TypeRegistry) typeName(v interface{}) string {
+	name, err := TypeNameOf(v)
+	if err != nil {
+		r.errs = append(r.errs, err)
+	}
+	return name
+}
+
+// Validate checks that the registrations are complete and consistent.
+//
+// Every aggregate must have a stream name and at least one event, no event
+// or command type may be registered more than once and every event handled
+// by a registered event handler must be registered with an aggregate.
+// Aggregates that embed RoutedAggregateBase must have a handler for each of
+// their events. The handlers of an aggregate registered as a zero value are
+// its On methods, so an aggregate that registers handlers with On in its
+// constructor should be registered as a constructed instance.
+//
+// All of the mistakes found are returned together in an *ErrRegistration.
+func (r *TypeRegistry) Validate() error {
+	errs := append([]error(nil), r.errs...)
+
+	aggregates := make(map[string]bool)
+	events := make(map[string]string)
+	commands := make(map[string]string)
+	for _, a := range r.aggregates {
+		if a.typeName == "" {
+			continue
+		}
+		if aggregates[a.typeName] {
+			errs = append(errs, fmt.Errorf("Aggregate type registered more than once: \"%s\"", a.typeName))
+		}
+		aggregates[a.typeName] = true
+
+		if a.streamName == nil && r.streamName == nil {
+			errs = append(errs, fmt.Errorf("Aggregate type \"%s\" has no stream name.", a.typeName))
+		}
+		if len(a.events) == 0 {
+			errs = append(errs, fmt.Errorf("Aggregate type \"%s\" has no events.", a.typeName))
+		}
+
+		// Aggregates that route events to handlers, such as those embedding
+		// RoutedAggregateBase, must have a handler for each of their events.
+		var router interface {
+			HandlesEvent(string) bool
+		}
+		if routed, ok := a.aggregate.(routedAggregate); ok {
+			router = routed
+			if !routed.routes() {
+				methods := NewEventRouter()
+				methods.RegisterMethods(a.aggregate)
+				router = methods
+			}
+		}
+
+		for _, e := range a.events {
+			if e.typeName == "" {
+				continue
+			}
+			if router != nil && !router.HandlesEvent(e.typeName) {
+				errs = append(errs, fmt.Errorf("Aggregate type \"%s\" has no handler for event type \"%s\".",
+					a.typeName, e.typeName))
+			}
+			if owner, ok := events[e.typeName]; ok {
+				errs = append(errs, fmt.Errorf("Event type \"%s\" of aggregate type \"%s\" is already registered with aggregate type \"%s\".",
+					e.typeName, a.typeName, owner))
+				continue
+			}
+			events[e.typeName] = a.typeName
+		}
+
+		for _, c := range a.commands {
+			if c.typeName == "" {
+				continue
+			}
+			if owner, ok := commands[c.typeName]; ok {
+				errs = append(errs, fmt.Errorf("Command type \"%s\" of aggregate type \"%s\" is already registered with aggregate type \"%s\".",
+					c.typeName, a.typeName, owner))
+				continue
+			}
+			commands[c.typeName] = a.typeName
+		}
+	}
+
+	for _, h := range r.eventHandlers {
+		for _, event := range h.events {
+			typeName, err := TypeNameOf(event)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if _, ok := events[typeName]; !ok {
+				errs = append(errs, fmt.Errorf("Event type \"%s\" handled by %T is not registered with an aggregate.",
+					typeName, h.handler))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ErrRegistration{Errors: errs}
+	}
+	return nil
+}
+
+// Events returns an instance of each registered event type, for registering
+// event handlers with an event bus.
+func (r *TypeRegistry) Events() []interface{} {
+	var events []interface{}
+	for _, a := range r.aggregates {
+		for _, e := range a.events {
+			events = append(events, e.event)
+		}
+	}
+	return events
+}
+
+// AggregateFactory returns an aggregate factory for the registered
+// aggregates.
+func (r *TypeRegistry) AggregateFactory() *DelegateAggregateFactory {
+	factory := NewDelegateAggregateFactory()
+	for _, a := range r.aggregates {
+		factory.RegisterDelegate(a.aggregate, a.delegate)
+	}
+	return factory
+}
+
+// EventFactory returns an event factory for the registered events.
+func (r *TypeRegistry) EventFactory() *DelegateEventFactory {
+	factory := NewDelegateEventFactory()
+	for _, a := range r.aggregates {
+		for _, e := range a.events {
+			factory.RegisterDelegate(e.event, e.delegate)
+		}
+	}
+	return factory
+}
+
+// StreamNamer returns a stream namer for the registered aggregates.
+func (r *TypeRegistry) StreamNamer() *DelegateStreamNamer {
+	namer := NewDelegateStreamNamer()
+	for _, a := range r.aggregates {
+		delegate := a.streamName
+		if delegate == nil {
+			delegate = r.streamName
+		}
+		if delegate != nil {
+			namer.RegisterDelegate(delegate, a.aggregate)
+		}
+	}
+	return namer
+}
+
+// ConfigureRepository validates the registry and sets the factories and
+// stream namer that the repository uses.
+//
+// Any repository with the SetAggregateFactory, SetEventFactory or
+// SetStreamNameDelegate methods of the GetEventStoreCommonDomainRepo can be
+// configured.
+func (r *TypeRegistry) ConfigureRepository(repo interface{}) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	configured := false
+	if v, ok := repo.(interface {
+		SetAggregateFactory(AggregateFactory)
+	}); ok {
+		v.SetAggregateFactory(r.AggregateFactory())
+		configured = true
+	}
+	if v, ok := repo.(interface {
+		SetEventFactory(EventFactory)
+	}); ok {
+		v.SetEventFactory(r.EventFactory())
+		configured = true
+	}
+	if v, ok := repo.(interface {
+		SetStreamNameDelegate(StreamNamer)
+	}); ok {
+		v.SetStreamNameDelegate(r.StreamNamer())
+		configured = true
+	}
+
+	if !configured {
+		return fmt.Errorf("The registry can not configure a repository of type %T.", repo)
+	}
+	return nil
+}
+
+// ConfigureDispatcher validates the registry and registers the command
+// handlers with the dispatcher.
+func (r *TypeRegistry) ConfigureDispatcher(dispatcher Dispatcher) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	for _, a := range r.aggregates {
+		for _, c := range a.commands {
+			if err := dispatcher.RegisterHandler(c.handler, c.command); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ConfigureEventBus validates the registry and adds the registered event
+// handlers to the event bus.
+func (r *TypeRegistry) ConfigureEventBus(bus EventBus) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	for _, h := range r.eventHandlers {
+		for _, event := range h.events {
+			if _, err := TypeNameOf(event); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, h := range r.eventHandlers {
+		bus.AddHandler(h.handler, h.events...)
+	}
+	return nil
+}
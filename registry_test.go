@@ -0,0 +1,146 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&TypeRegistrySuite{})
+
+type TypeRegistrySuite struct {
+	registry *TypeRegistry
+	handler  *TestCommandHandler
+}
+
+func (s *TypeRegistrySuite) SetUpTest(c *C) {
+	s.handler = &TestCommandHandler{}
+	s.registry = NewTypeRegistry()
+	s.registry.Aggregate(&SomeAggregate{},
+		func(id string) AggregateRoot { return NewSomeAggregate(id) }).
+		Event(&SomeEvent{}, func() interface{} { return &SomeEvent{} }).
+		Event(&SomeOtherEvent{}, func() interface{} { return &SomeOtherEvent{} }).
+		Commands(s.handler, &SomeCommand{}, &SomeOtherCommand{}).
+		StreamName(func(t, id string) string { return t + "-" + id })
+}
+
+func (s *TypeRegistrySuite) TestValidRegistryValidates(c *C) {
+	c.Assert(s.registry.Validate(), IsNil)
+}
+
+func (s *TypeRegistrySuite) TestFactoriesAndStreamNamer(c *C) {
+	id := NewUUID()
+
+	agg := s.registry.AggregateFactory().GetAggregate("SomeAggregate", id)
+	event := s.registry.EventFactory().GetEvent("SomeOtherEvent")
+	stream, err := s.registry.StreamNamer().GetStreamName("SomeAggregate", id)
+
+	c.Assert(agg, FitsTypeOf, &SomeAggregate{})
+	c.Assert(agg.AggregateID(), Equals, id)
+	c.Assert(event, DeepEquals, &SomeOtherEvent{})
+	c.Assert(err, IsNil)
+	c.Assert(stream, Equals, "SomeAggregate-"+id)
+}
+
+func (s *TypeRegistrySuite) TestDefaultStreamName(c *C) {
+	registry := NewTypeRegistry()
+	registry.SetDefaultStreamName(func(t, id string) string { return "context-" + id })
+	registry.Aggregate(&SomeOtherAggregate{},
+		func(id string) AggregateRoot { return NewSomeOtherAggregate(id) }).
+		Event(&SomeEvent{}, func() interface{} { return &SomeEvent{} })
+
+	c.Assert(registry.Validate(), IsNil)
+	stream, err := registry.StreamNamer().GetStreamName("SomeOtherAggregate", "1")
+	c.Assert(err, IsNil)
+	c.Assert(stream, Equals, "context-1")
+}
+
+func (s *TypeRegistrySuite) TestConfigureRepository(c *C) {
+	repo, _ := NewInMemoryRepository(NewInternalEventBus())
+	c.Assert(s.registry.ConfigureRepository(repo), IsNil)
+
+	id := NewUUID()
+	agg := NewSomeAggregate(id)
+	agg.TrackChange(NewEventMessage(id, &SomeEvent{"Some data", 4}, nil))
+	c.Assert(repo.Save(agg, nil), IsNil)
+
+	got, err := repo.Load("SomeAggregate", id)
+	c.Assert(err, IsNil)
+	c.Assert(got.OriginalVersion(), Equals, 0)
+}
+
+func (s *TypeRegistrySuite) TestConfigureRepositoryRejectsUnknownRepository(c *C) {
+	err := s.registry.ConfigureRepository(&MockRepository{})
+
+	c.Assert(err, ErrorMatches, "The registry can not configure a repository of type \\*ycq.MockRepository.")
+}
+
+func (s *TypeRegistrySuite) TestConfigureDispatcher(c *C) {
+	dispatcher := NewInMemoryDispatcher()
+	c.Assert(s.registry.ConfigureDispatcher(dispatcher), IsNil)
+
+	cmd := NewSomeOtherCommandMessage(NewUUID())
+	c.Assert(dispatcher.Dispatch(cmd), IsNil)
+	c.Assert(s.handler.command, Equals, cmd)
+}
+
+func (s *TypeRegistrySuite) TestConfigureEventBus(c *C) {
+	bus := NewInternalEventBus()
+	handler := NewMockEventHandler()
+	s.registry.EventHandler(handler, &SomeEvent{})
+	c.Assert(s.registry.ConfigureEventBus(bus), IsNil)
+
+	bus.PublishEvent(NewEventMessage(NewUUID(), &SomeEvent{"Some data", 4}, nil))
+
+	c.Assert(handler.events, HasLen, 1)
+	c.Assert(s.registry.Events(), HasLen, 2)
+}
+
+func (s *TypeRegistrySuite) TestValidateReportsAllMistakes(c *C) {
+	registry := NewTypeRegistry()
+	registry.Aggregate(&SomeAggregate{}, nil).
+		Commands(s.handler, &SomeCommand{})
+	registry.Aggregate(&SomeOtherAggregate{},
+		func(id string) AggregateRoot { return NewSomeOtherAggregate(id) }).
+		Event(&SomeEvent{}, func() interface{} { return &SomeEvent{} }).
+		Event(&SomeEvent{}, func() interface{} { return &SomeEvent{} }).
+		Commands(s.handler, &SomeCommand{}).
+		StreamName(func(t, id string) string { return id })
+	registry.EventHandler(NewMockEventHandler(), &SomeOtherEvent{})
+
+	err := registry.Validate()
+
+	c.Assert(err, FitsTypeOf, &ErrRegistration{})
+	errs := err.(*ErrRegistration).Errors
+	c.Assert(errs, HasLen, 6)
+	c.Assert(errs[0], ErrorMatches, "Nil delegate registered for aggregate type: \"SomeAggregate\"")
+	c.Assert(errs[1], ErrorMatches, "Aggregate type \"SomeAggregate\" has no stream name.")
+	c.Assert(errs[2], ErrorMatches, "Aggregate type \"SomeAggregate\" has no events.")
+	c.Assert(errs[3], ErrorMatches, "Event type \"SomeEvent\" of aggregate type \"SomeOtherAggregate\" is already registered with aggregate type \"SomeOtherAggregate\".")
+	c.Assert(errs[4], ErrorMatches, "Command type \"SomeCommand\" of aggregate type \"SomeOtherAggregate\" is already registered with aggregate type \"SomeAggregate\".")
+	c.Assert(errs[5], ErrorMatches, "Event type \"SomeOtherEvent\" handled by \\*ycq.MockEventHandler is not registered with an aggregate.")
+}
+
+func (s *TypeRegistrySuite) TestConfigureFailsIfRegistryIsInvalid(c *C) {
+	registry := NewTypeRegistry()
+	registry.Aggregate(&SomeAggregate{}, nil)
+
+	c.Assert(registry.ConfigureDispatcher(NewInMemoryDispatcher()), FitsTypeOf, &ErrRegistration{})
+}
+
+func (s *TypeRegistrySuite) TestEventsAreRegisteredByReflection(c *C) {
+	registry := NewTypeRegistry()
+	registry.Aggregate(&SomeAggregate{},
+		func(id string) AggregateRoot { return NewSomeAggregate(id) }).
+		Events(&SomeEvent{}, SomeOtherEvent{}).
+		StreamName(func(t, id string) string { return id })
+
+	err := registry.Validate()
+
+	c.Assert(err, FitsTypeOf, &ErrRegistration{})
+	c.Assert(err.(*ErrRegistration).Errors, HasLen, 1)
+	c.Assert(registry.EventFactory().GetEvent("SomeEvent"), DeepEquals, &SomeEvent{})
+}
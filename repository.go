@@ -6,6 +6,8 @@
 package ycq
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/url"
 
@@ -21,6 +23,21 @@ type DomainRepository interface {
 	Save(aggregate AggregateRoot, expectedVersion *int) error
 }
 
+// AggregateDeleter is implemented by repositories that can retire the
+// streams of aggregates.
+//
+// Delete soft deletes a stream. The events of the stream are no longer read
+// but the stream can be written to again, in which case its version numbers
+// continue from before it was deleted. Tombstone hard deletes a stream. A
+// tombstoned stream can never be written to again.
+//
+// Loading an aggregate whose stream has been deleted returns an
+// *ErrAggregateDeleted.
+type AggregateDeleter interface {
+	Delete(aggregateType, id string) error
+	Tombstone(aggregateType, id string) error
+}
+
 // GetEventStoreCommonDomainRepo is an implementation of the DomainRepository
 // that uses GetEventStore for persistence
 type GetEventStoreCommonDomainRepo struct {
@@ -29,6 +46,17 @@ type GetEventStoreCommonDomainRepo struct {
 	streamNameDelegate StreamNamer
 	aggregateFactory   AggregateFactory
 	eventFactory       EventFactory
+	outbox             Outbox
+	upcaster           Upcaster
+	serializers        *SerializerRegistry
+	encryptor          *PersonalDataEncryptor
+	compression        *Compression
+	claimCheck         *ClaimCheck
+	archive            Archive
+	headers            *HeaderRegistry
+	unknownEvents      UnknownEventPolicy
+	unknownEventHook   func(*ErrUnknownEvent)
+	feedStream         string
 }
 
 // NewCommonDomainRepository constructs a new CommonDomainRepository
@@ -42,8 +70,10 @@ func NewCommonDomainRepository(eventStore *goes.Client, eventBus EventBus) (*Get
 	}
 
 	d := &GetEventStoreCommonDomainRepo{
-		eventStore: eventStore,
-		eventBus:   eventBus,
+		eventStore:  eventStore,
+		eventBus:    eventBus,
+		serializers: NewSerializerRegistry(),
+		headers:     NewHeaderRegistry(),
 	}
 	return d, nil
 }
@@ -71,6 +101,105 @@ func (r *GetEventStoreCommonDomainRepo) SetStreamNameDelegate(delegate StreamNam
 	r.streamNameDelegate = delegate
 }
 
+// SetOutbox sets the outbox that the repository should use to hold saved
+// events for publishing.
+//
+// When an outbox is set the repository no longer publishes events on the
+// EventBus itself. Instead, events are recorded in the outbox as part of Save
+// and are published by an OutboxRelay. This ensures that events that have
+// been written to the store are published even if the process stops before
+// they could be published.
+func (r *GetEventStoreCommonDomainRepo) SetOutbox(outbox Outbox) {
+	r.outbox = outbox
+}
+
+// SetUpcaster sets the upcaster that should be used to transform events
+// persisted with an older type or schema as they are loaded.
+//
+// When an upcaster is set, events are saved with a SchemaVersion header
+// holding the current schema version of the event type.
+func (r *GetEventStoreCommonDomainRepo) SetUpcaster(upcaster Upcaster) {
+	r.upcaster = upcaster
+}
+
+// SetSerializers sets the registry that selects the serialiser used to write
+// each event type.
+//
+// By default all events are written as JSON. Events can always be read
+// whichever serialiser wrote them, provided the serialiser is registered.
+func (r *GetEventStoreCommonDomainRepo) SetSerializers(serializers *SerializerRegistry) {
+	r.serializers = serializers
+}
+
+// SetHeaderRegistry sets the registry that gives the headers of loaded events
+// their types.
+//
+// By default only the headers used by this package are registered. Headers
+// of other types should be registered so that they are loaded with the same
+// types with which they were saved.
+func (r *GetEventStoreCommonDomainRepo) SetHeaderRegistry(headers *HeaderRegistry) {
+	r.headers = headers
+}
+
+// SetPersonalDataEncryptor sets the encryptor used to encrypt the personal
+// data fields of events as they are saved and decrypt them as they are loaded.
+//
+// Events are published on the EventBus with their personal data decrypted.
+// Events held in an outbox are not encrypted, so a FileOutbox holds personal
+// data until its entries are dispatched.
+func (r *GetEventStoreCommonDomainRepo) SetPersonalDataEncryptor(encryptor *PersonalDataEncryptor) {
+	r.encryptor = encryptor
+}
+
+// SetCompression sets the compression used for the bodies of events as they
+// are saved and loaded.
+//
+// The codec used is recorded in the ContentEncoding header of each compressed
+// event. Events saved without compression are read unchanged.
+func (r *GetEventStoreCommonDomainRepo) SetCompression(compression *Compression) {
+	r.compression = compression
+}
+
+// SetClaimCheck sets the claim check used to hold oversized event payloads in
+// a blob store.
+//
+// The payloads of events loaded into an aggregate are read from the blob
+// store as the aggregate is loaded, and a payload that can not be read, or
+// does not match its hash, fails the Load. Events read from the feed are
+// ClaimCheckEventMessages whose payload is read when it is first used, unless
+// an upcaster is set. A Projection stops with the error if the payload of an
+// event it handles can not be read.
+func (r *GetEventStoreCommonDomainRepo) SetClaimCheck(claimCheck *ClaimCheck) {
+	r.claimCheck = claimCheck
+}
+
+// SetArchive sets the archive that the events of a stream are written to
+// before the stream is deleted.
+func (r *GetEventStoreCommonDomainRepo) SetArchive(archive Archive) {
+	r.archive = archive
+}
+
+// SetUnknownEventPolicy sets what the repository does when it reads an event
+// whose type has no delegate in the event factory. The default is
+// FailOnUnknownEvent.
+func (r *GetEventStoreCommonDomainRepo) SetUnknownEventPolicy(policy UnknownEventPolicy) {
+	r.unknownEvents = policy
+}
+
+// SetUnknownEventHook sets a function that is called with a description of
+// each unknown event that is skipped or delivered, for instance to log a
+// warning.
+func (r *GetEventStoreCommonDomainRepo) SetUnknownEventHook(hook func(*ErrUnknownEvent)) {
+	r.unknownEventHook = hook
+}
+
+// SetFeedStream sets the stream from which ReadFeed reads the events of all
+// aggregates. This is a stream of links to the events of other streams, such
+// as a $ce- category stream or a stream written by a projection in the store.
+func (r *GetEventStoreCommonDomainRepo) SetFeedStream(streamName string) {
+	r.feedStream = streamName
+}
+
 // Load will load all events from a stream and apply those events to an aggregate
 // of the type specified.
 //
@@ -100,55 +229,387 @@ func (r *GetEventStoreCommonDomainRepo) Load(aggregateType, id string) (Aggregat
 		return nil, err
 	}
 
+	if err := r.applyEvents(aggregate, aggregateType, streamName, 0); err != nil {
+		return nil, err
+	}
+
+	return aggregate, nil
+
+}
+
+// readRawEvent returns the current event of the stream as it is held in the
+// store together with its metadata.
+func (r *GetEventStoreCommonDomainRepo) readRawEvent(stream *goes.StreamReader) (*RawEvent, map[string]interface{}, error) {
+	var data, rawMeta json.RawMessage
+	stream.Scan(&data, &rawMeta)
+	if stream.Err() != nil {
+		return nil, nil, stream.Err()
+	}
+
+	meta, err := r.headers.Decode(rawMeta)
+	if err != nil {
+		return nil, nil, &ErrUnexpected{Err: err}
+	}
+
+	schemaVersion, ok := meta[SchemaVersionHeader].(int)
+	if !ok {
+		schemaVersion = 1
+	}
+
+	raw := &RawEvent{
+		EventType:     stream.EventResponse().Event.EventType,
+		SchemaVersion: schemaVersion,
+		ContentType:   stringHeader(meta, ContentTypeHeader),
+		Data:          data,
+	}
+
+	return raw, meta, nil
+}
+
+// readBody replaces the body of a raw event as it is held in the store with
+// the serialised event, reading it from the blob store and decompressing it
+// as necessary.
+func (r *GetEventStoreCommonDomainRepo) readBody(raw *RawEvent, meta map[string]interface{}) error {
+	encoding := stringHeader(meta, ContentEncodingHeader)
+
+	if blobID := stringHeader(meta, ClaimCheckHeader); blobID != "" {
+		if r.claimCheck == nil {
+			return fmt.Errorf("The common domain repository has no claim check to read blob: %s", blobID)
+		}
+		ref := &BlobReference{}
+		if err := json.Unmarshal(raw.Data, ref); err != nil {
+			return &ErrUnexpected{Err: err}
+		}
+		data, err := r.claimCheck.Claim(ref)
+		if err != nil {
+			return err
+		}
+		raw.Data = data
+	} else if encoding != "" || (raw.ContentType != "" && raw.ContentType != ContentTypeJSON) {
+		// Events that are not JSON, or are compressed, are held in the store
+		// as base64 encoded strings.
+		var b []byte
+		if err := json.Unmarshal(raw.Data, &b); err != nil {
+			return &ErrUnexpected{Err: err}
+		}
+		raw.Data = b
+	}
+
+	if encoding != "" {
+		if r.compression == nil {
+			return fmt.Errorf("The common domain repository has no compression to read events with content encoding: %s", encoding)
+		}
+		b, err := r.compression.Decompress(raw.Data, encoding)
+		if err != nil {
+			return &ErrUnexpected{Err: err}
+		}
+		raw.Data = b
+	}
+
+	return nil
+}
+
+// decodeEvent instantiates an event of an aggregate from its serialised form
+// and decrypts its personal data.
+//
+// If the event factory has no delegate for the event type, nil is returned.
+func (r *GetEventStoreCommonDomainRepo) decodeEvent(aggregateID string, raw *RawEvent) (interface{}, error) {
+	event := r.eventFactory.GetEvent(raw.EventType)
+	if event == nil {
+		return nil, nil
+	}
+
+	serializer, err := r.serializers.SerializerByContentType(raw.ContentType)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := serializer.Unmarshal(raw.Data, event); err != nil {
+		return nil, &ErrUnexpected{Err: err}
+	}
+
+	if r.encryptor != nil {
+		return r.encryptor.Decrypt(event, aggregateID)
+	}
+	return event, nil
+}
+
+// unknownEvent applies the unknown event policy to an event whose type has no
+// delegate in the event factory. It returns the payload to deliver, or nil if
+// the event is to be skipped.
+func (r *GetEventStoreCommonDomainRepo) unknownEvent(streamName string, eventNumber int, raw *RawEvent) (interface{}, error) {
+	err := &ErrUnknownEvent{
+		StreamName:  streamName,
+		EventNumber: eventNumber,
+		EventType:   raw.EventType,
+	}
+
+	switch r.unknownEvents {
+	case SkipUnknownEvent:
+		if r.unknownEventHook != nil {
+			r.unknownEventHook(err)
+		}
+		return nil, nil
+	case DeliverUnknownEvent:
+		if r.unknownEventHook != nil {
+			r.unknownEventHook(err)
+		}
+		return newUnknownEvent(raw), nil
+	default:
+		return nil, err
+	}
+}
+
+// newEvent returns an event in the form in which it is written to the store.
+//
+// The personal data of the event is encrypted, its body compressed and an
+// oversized body moved to the blob store in the form written. The event
+// payload itself is not changed.
+func (r *GetEventStoreCommonDomainRepo) newEvent(aggregate AggregateRoot, streamName, eventID string, event EventMessage) (*goes.Event, error) {
+	payload := event.Event()
+	if r.encryptor != nil {
+		var err error
+		if payload, err = r.encryptor.Encrypt(payload, aggregate.AggregateID()); err != nil {
+			return nil, err
+		}
+	}
+
+	serializer := r.serializers.SerializerFor(event.EventType())
+	if serializer.ContentType() == ContentTypeJSON && r.compression == nil && r.claimCheck == nil {
+		return goes.NewEvent(eventID, event.EventType(), payload, event.GetHeaders()), nil
+	}
+
+	data, err := serializer.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var body interface{} = data
+	if serializer.ContentType() == ContentTypeJSON {
+		body = json.RawMessage(data)
+	} else {
+		event.SetHeader(ContentTypeHeader, serializer.ContentType())
+	}
+
+	if r.compression != nil {
+		compressed, encoding, err := r.compression.Compress(data)
+		if err != nil {
+			return nil, err
+		}
+		if encoding != "" {
+			event.SetHeader(ContentEncodingHeader, encoding)
+			data, body = compressed, compressed
+		}
+	}
+
+	if r.claimCheck != nil {
+		ref, err := r.claimCheck.Check(streamName, data)
+		if err != nil {
+			return nil, err
+		}
+		if ref != nil {
+			event.SetHeader(ClaimCheckHeader, ref.ID)
+			body = ref
+		}
+	}
+
+	return goes.NewEvent(eventID, event.EventType(), body, event.GetHeaders()), nil
+}
+
+// CatchUp applies to an aggregate the events that have been appended to its
+// stream since the aggregate was loaded.
+func (r *GetEventStoreCommonDomainRepo) CatchUp(aggregate AggregateRoot) error {
+
+	if r.streamNameDelegate == nil {
+		return fmt.Errorf("The common domain repository has no stream name delegate.")
+	}
+
+	if r.eventFactory == nil {
+		return fmt.Errorf("The common domain has no Event Factory.")
+	}
+
+	aggregateType := typeOf(aggregate)
+	streamName, err := r.streamNameDelegate.GetStreamName(aggregateType, aggregate.AggregateID())
+	if err != nil {
+		return err
+	}
+
+	return r.applyEvents(aggregate, aggregateType, streamName, aggregate.OriginalVersion()+1)
+}
+
+// applyEvents reads the events of a stream from the version specified and
+// applies them to the aggregate.
+func (r *GetEventStoreCommonDomainRepo) applyEvents(aggregate AggregateRoot, aggregateType, streamName string, fromVersion int) error {
+	id := aggregate.AggregateID()
+
 	stream := r.eventStore.NewStreamReader(streamName)
+	if fromVersion > 0 {
+		stream.NextVersion(fromVersion)
+	}
 	for stream.Next() {
 		switch err := stream.Err().(type) {
 		case nil:
 			break
 		case *url.Error, *goes.ErrTemporarilyUnavailable:
-			return nil, &ErrRepositoryUnavailable{}
+			return &ErrRepositoryUnavailable{}
 		case *goes.ErrNoMoreEvents:
-			return aggregate, nil
+			return nil
 		case *goes.ErrUnauthorized:
-			return nil, &ErrUnauthorized{}
+			return &ErrUnauthorized{}
 		case *goes.ErrNotFound:
-			return nil, &ErrAggregateNotFound{AggregateType: aggregateType, AggregateID: id}
+			deleted, derr := r.softDeleted(streamName)
+			if derr != nil {
+				return derr
+			}
+			if deleted {
+				return &ErrAggregateDeleted{AggregateType: aggregateType, AggregateID: id}
+			}
+			return &ErrAggregateNotFound{AggregateType: aggregateType, AggregateID: id}
+		case *goes.ErrDeleted:
+			return &ErrAggregateDeleted{AggregateType: aggregateType, AggregateID: id}
 		default:
-			return nil, &ErrUnexpected{Err: err}
+			return &ErrUnexpected{Err: err}
 		}
 
-		event := r.eventFactory.GetEvent(stream.EventResponse().Event.EventType)
+		raw, meta, err := r.readRawEvent(stream)
+		if err != nil {
+			return err
+		}
+		version := Int(stream.EventResponse().Event.EventNumber)
 
-		//TODO: No test for meta
-		meta := make(map[string]string)
-		stream.Scan(event, &meta)
-		if stream.Err() != nil {
-			return nil, stream.Err()
+		// The version of a stream that has been deleted and written to again
+		// continues from before it was deleted, so reading starts part way
+		// through the stream.
+		for aggregate.OriginalVersion() < *version-1 {
+			aggregate.IncrementVersion()
 		}
-		em := NewEventMessage(id, event, Int(stream.EventResponse().Event.EventNumber))
-		for k, v := range meta {
-			em.SetHeader(k, v)
+
+		if err := r.readBody(raw, meta); err != nil {
+			return err
+		}
+
+		upcasted := []*RawEvent{raw}
+		if r.upcaster != nil {
+			upcasted, err = r.upcaster.Upcast(raw)
+			if err != nil {
+				return err
+			}
+		}
+
+		// An upcaster may turn one persisted event into several, or none, but
+		// the aggregate version always follows the events in the stream.
+		for k, rawEvent := range upcasted {
+			event, err := r.decodeEvent(id, rawEvent)
+			if err != nil {
+				return err
+			}
+			if event == nil {
+				if event, err = r.unknownEvent(streamName, *version, rawEvent); err != nil {
+					return err
+				}
+				if event == nil {
+					continue
+				}
+			}
+
+			em := NewEventMessage(id, event, version)
+			for k, v := range meta {
+				em.SetHeader(k, v)
+			}
+			r.setEventID(em, stream)
+			em.SetHeader(EventIDHeader, splitEventID(EventIDOf(em), k))
+			if r.upcaster != nil {
+				em.SetHeader(SchemaVersionHeader, rawEvent.SchemaVersion)
+			}
+			aggregate.Apply(em, false)
 		}
-		aggregate.Apply(em, false)
 		aggregate.IncrementVersion()
 	}
 
-	return aggregate, nil
+	return nil
+}
 
+// setEventID sets the id of a loaded event that was saved without an EventID
+// header to the id of the event in the store.
+func (r *GetEventStoreCommonDomainRepo) setEventID(em EventMessage, stream *goes.StreamReader) {
+	if EventIDOf(em) == "" {
+		em.SetHeader(EventIDHeader, stream.EventResponse().Event.EventID)
+	}
 }
 
 // Save persists an aggregate
+//
+// If the aggregate breaks any of its invariants its changes are discarded and
+// an *ErrInvariantViolation is returned.
 func (r *GetEventStoreCommonDomainRepo) Save(aggregate AggregateRoot, expectedVersion *int) error {
+	if err := checkInvariants(aggregate); err != nil {
+		return err
+	}
+
+	published, err := r.save(aggregate, expectedVersion)
+	if err != nil {
+		return err
+	}
+
+	r.publish(published)
+	return nil
+}
+
+// SaveAll persists the changes of several aggregates, publishing the
+// resulting events only once all of the aggregates have been saved.
+//
+// GetEventStore does not support transactions across streams so each
+// aggregate is written to its stream in turn. If saving an aggregate fails
+// after earlier aggregates have been written, an *ErrPartialCommit is
+// returned listing the aggregates that were committed. The events of those
+// aggregates are in the store and so are still published.
+//
+// The invariants of all of the aggregates are checked before any are saved.
+// If any are broken nothing is saved and an *ErrInvariantViolations is
+// returned.
+func (r *GetEventStoreCommonDomainRepo) SaveAll(aggregates []AggregateRoot, expectedVersions []*int) error {
+	if len(aggregates) != len(expectedVersions) {
+		return fmt.Errorf("The number of expected versions does not match the number of aggregates.")
+	}
+
+	if err := checkAllInvariants(aggregates); err != nil {
+		return err
+	}
+
+	var published []EventMessage
+	for k, aggregate := range aggregates {
+		events, err := r.save(aggregate, expectedVersions[k])
+		if err != nil {
+			if k == 0 {
+				return err
+			}
+			r.publish(published)
+			return &ErrPartialCommit{Committed: aggregates[:k], Failed: aggregate, Err: err}
+		}
+		published = append(published, events...)
+	}
+
+	r.publish(published)
+	return nil
+}
+
+// save writes the changes of an aggregate to its stream and returns the
+// events that should be published.
+func (r *GetEventStoreCommonDomainRepo) save(aggregate AggregateRoot, expectedVersion *int) ([]EventMessage, error) {
 
 	if r.streamNameDelegate == nil {
-		return fmt.Errorf("The common domain repository has no stream name delagate.")
+		return nil, fmt.Errorf("The common domain repository has no stream name delagate.")
 	}
 
 	resultEvents := aggregate.GetChanges()
 
 	streamName, err := r.streamNameDelegate.GetStreamName(typeOf(aggregate), aggregate.AggregateID())
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if r.outbox != nil {
+		return nil, r.saveToOutbox(aggregate, streamName, expectedVersion, resultEvents)
 	}
 
 	if len(resultEvents) > 0 {
@@ -157,36 +618,501 @@ func (r *GetEventStoreCommonDomainRepo) Save(aggregate AggregateRoot, expectedVe
 
 		for k, v := range resultEvents {
 			//TODO: There is no test for this code
-			v.SetHeader("AggregateID", aggregate.AggregateID())
-			evs[k] = goes.NewEvent("", v.EventType(), v.Event(), v.GetHeaders())
+			r.setHeaders(aggregate, v)
+			ev, err := r.newEvent(aggregate, streamName, EventIDOf(v), v)
+			if err != nil {
+				return nil, err
+			}
+			evs[k] = ev
 		}
 
-		streamWriter := r.eventStore.NewStreamWriter(streamName)
-		err := streamWriter.Append(expectedVersion, evs...)
-		switch e := err.(type) {
-		case nil:
-			break
-		case *goes.ErrConcurrencyViolation:
-			return &ErrConcurrencyViolation{Aggregate: aggregate, ExpectedVersion: expectedVersion, StreamName: streamName}
-		case *goes.ErrUnauthorized:
-			return &ErrUnauthorized{}
-		case *goes.ErrTemporarilyUnavailable:
-			return &ErrRepositoryUnavailable{}
-		default:
-			return &ErrUnexpected{Err: e}
+		if err := r.append(aggregate, streamName, expectedVersion, evs); err != nil {
+			return nil, err
 		}
 	}
 
 	aggregate.ClearChanges()
 
+	published := make([]EventMessage, len(resultEvents))
 	for k, v := range resultEvents {
 		if expectedVersion == nil {
-			r.eventBus.PublishEvent(v)
+			published[k] = v
 		} else {
 			em := NewEventMessage(v.AggregateID(), v.Event(), Int(*expectedVersion+k+1))
-			r.eventBus.PublishEvent(em)
+			for h, value := range v.GetHeaders() {
+				em.SetHeader(h, value)
+			}
+			published[k] = em
+		}
+	}
+
+	return published, nil
+}
+
+// setHeaders sets the headers that the repository persists with each event.
+//
+// Events that do not have an event id and occurred at time are given them
+// here.
+func (r *GetEventStoreCommonDomainRepo) setHeaders(aggregate AggregateRoot, event EventMessage) {
+	stampEvent(event)
+	event.SetHeader(AggregateIDHeader, aggregate.AggregateID())
+	if r.upcaster != nil {
+		event.SetHeader(SchemaVersionHeader, r.upcaster.SchemaVersion(event.EventType()))
+	}
+}
+
+func (r *GetEventStoreCommonDomainRepo) publish(events []EventMessage) {
+	for _, v := range events {
+		r.eventBus.PublishEvent(v)
+	}
+}
+
+// saveToOutbox persists the changes of an aggregate and records them in the
+// outbox for publishing by an OutboxRelay.
+//
+// The outbox entry is added before the events are appended to the store so
+// that a process stopping part way through can be reconciled by
+// OutboxRelay.Recover.
+//
+// The entry is discarded only if the store rejected the events. If the
+// outcome of the append is not known, as when the store could not be reached,
+// the events may have been written, so the entry is left uncommitted for the
+// OutboxRelay to verify.
+func (r *GetEventStoreCommonDomainRepo) saveToOutbox(aggregate AggregateRoot, streamName string, expectedVersion *int, resultEvents []EventMessage) error {
+
+	if len(resultEvents) == 0 {
+		aggregate.ClearChanges()
+		return nil
+	}
+
+	for _, v := range resultEvents {
+		r.setHeaders(aggregate, v)
+	}
+
+	entry, err := newOutboxEntry(streamName, expectedVersion, resultEvents)
+	if err != nil {
+		return err
+	}
+
+	evs := make([]*goes.Event, len(resultEvents))
+	for k, v := range resultEvents {
+		ev, err := r.newEvent(aggregate, streamName, entry.Events[k].EventID, v)
+		if err != nil {
+			return err
+		}
+		evs[k] = ev
+	}
+
+	if err := r.outbox.Add(entry); err != nil {
+		return err
+	}
+
+	if err := r.append(aggregate, streamName, expectedVersion, evs); err != nil {
+		switch err.(type) {
+		case *ErrConcurrencyViolation, *ErrAggregateDeleted, *ErrUnauthorized:
+			if e := r.outbox.Discard(entry.ID); e != nil {
+				return &ErrUnexpected{Err: e}
+			}
 		}
+		return err
+	}
+
+	if err := r.outbox.Commit(entry.ID); err != nil {
+		return &ErrUnexpected{Err: err}
 	}
 
+	aggregate.ClearChanges()
+
 	return nil
 }
+
+// append writes events to a stream and translates the errors returned by the
+// event store.
+func (r *GetEventStoreCommonDomainRepo) append(aggregate AggregateRoot, streamName string, expectedVersion *int, evs []*goes.Event) error {
+	streamWriter := r.eventStore.NewStreamWriter(streamName)
+	err := streamWriter.Append(expectedVersion, evs...)
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *goes.ErrConcurrencyViolation:
+		return &ErrConcurrencyViolation{Aggregate: aggregate, ExpectedVersion: expectedVersion, StreamName: streamName}
+	case *goes.ErrDeleted:
+		return &ErrAggregateDeleted{AggregateType: typeOf(aggregate), AggregateID: aggregate.AggregateID()}
+	case *goes.ErrUnauthorized:
+		return &ErrUnauthorized{}
+	case *goes.ErrTemporarilyUnavailable:
+		return &ErrRepositoryUnavailable{}
+	default:
+		return &ErrUnexpected{Err: e}
+	}
+}
+
+// VerifyOutboxEntry reports whether the events of an outbox entry were
+// written to the store.
+//
+// The stream is searched for the id of the first event of the entry.
+func (r *GetEventStoreCommonDomainRepo) VerifyOutboxEntry(entry *OutboxEntry) (bool, error) {
+	if len(entry.Events) == 0 {
+		return false, nil
+	}
+
+	stream := r.eventStore.NewStreamReader(entry.StreamName)
+	for stream.Next() {
+		switch err := stream.Err().(type) {
+		case nil:
+			break
+		case *url.Error, *goes.ErrTemporarilyUnavailable:
+			return false, &ErrRepositoryUnavailable{}
+		case *goes.ErrNoMoreEvents, *goes.ErrNotFound, *goes.ErrDeleted:
+			return false, nil
+		case *goes.ErrUnauthorized:
+			return false, &ErrUnauthorized{}
+		default:
+			return false, &ErrUnexpected{Err: err}
+		}
+
+		if stream.EventResponse().Event.EventID == entry.Events[0].EventID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ReferencedBlobs returns the ids of the blobs that the claim checked events
+// of a stream refer to. A stream that does not exist, or has been deleted,
+// refers to none.
+//
+// The repository should be passed to CollectBlobs so that the blobs of live
+// streams are kept.
+func (r *GetEventStoreCommonDomainRepo) ReferencedBlobs(streamName string) ([]string, error) {
+	var ids []string
+
+	stream := r.eventStore.NewStreamReader(streamName)
+	for stream.Next() {
+		switch err := stream.Err().(type) {
+		case nil:
+			break
+		case *goes.ErrNoMoreEvents, *goes.ErrNotFound, *goes.ErrDeleted:
+			return ids, nil
+		case *url.Error, *goes.ErrTemporarilyUnavailable:
+			return nil, &ErrRepositoryUnavailable{}
+		case *goes.ErrUnauthorized:
+			return nil, &ErrUnauthorized{}
+		default:
+			return nil, &ErrUnexpected{Err: err}
+		}
+
+		_, meta, err := r.readRawEvent(stream)
+		if err != nil {
+			return nil, err
+		}
+		if id := stringHeader(meta, ClaimCheckHeader); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// Delete soft deletes the stream of an aggregate.
+//
+// GetEventStore implements a soft delete by truncating the stream before its
+// next version, which it records in the $tb metadata of the stream. If an
+// archive is set the events of the stream are archived first.
+func (r *GetEventStoreCommonDomainRepo) Delete(aggregateType, id string) error {
+	return r.deleteStream(aggregateType, id, false)
+}
+
+// Tombstone hard deletes the stream of an aggregate. The stream can not be
+// written to again. If an archive is set the events of the stream are
+// archived first.
+func (r *GetEventStoreCommonDomainRepo) Tombstone(aggregateType, id string) error {
+	return r.deleteStream(aggregateType, id, true)
+}
+
+func (r *GetEventStoreCommonDomainRepo) deleteStream(aggregateType, id string, hard bool) error {
+
+	if r.streamNameDelegate == nil {
+		return fmt.Errorf("The common domain repository has no stream name delegate.")
+	}
+
+	streamName, err := r.streamNameDelegate.GetStreamName(aggregateType, id)
+	if err != nil {
+		return err
+	}
+
+	if r.archive != nil {
+		events, err := r.readArchivedEvents(aggregateType, id, streamName)
+		if err != nil {
+			return err
+		}
+		if err := r.archive.Archive(streamName, events); err != nil {
+			return err
+		}
+	}
+
+	req, err := r.eventStore.NewRequest("DELETE", "/streams/"+url.PathEscape(streamName), nil)
+	if err != nil {
+		return &ErrUnexpected{Err: err}
+	}
+	if hard {
+		req.Header.Set("ES-HardDelete", "true")
+	}
+
+	_, err = r.eventStore.Do(req, nil)
+	switch err := err.(type) {
+	case nil:
+		return nil
+	case *url.Error, *goes.ErrTemporarilyUnavailable:
+		return &ErrRepositoryUnavailable{}
+	case *goes.ErrUnauthorized:
+		return &ErrUnauthorized{}
+	case *goes.ErrNotFound:
+		return &ErrAggregateNotFound{AggregateType: aggregateType, AggregateID: id}
+	case *goes.ErrDeleted:
+		return &ErrAggregateDeleted{AggregateType: aggregateType, AggregateID: id}
+	default:
+		return &ErrUnexpected{Err: err}
+	}
+}
+
+// softDeleted reports whether a stream that has no events has been soft
+// deleted, which GetEventStore records by setting the truncate before ($tb)
+// metadata of the stream.
+func (r *GetEventStoreCommonDomainRepo) softDeleted(streamName string) (bool, error) {
+	req, err := r.eventStore.NewRequest("GET", "/streams/"+url.PathEscape(streamName)+"/metadata", nil)
+	if err != nil {
+		return false, &ErrUnexpected{Err: err}
+	}
+	req.Header.Set("Accept", "application/json")
+
+	var body bytes.Buffer
+	_, err = r.eventStore.Do(req, &body)
+	switch err := err.(type) {
+	case nil:
+		break
+	case *goes.ErrNotFound:
+		return false, nil
+	case *goes.ErrDeleted:
+		return true, nil
+	case *url.Error, *goes.ErrTemporarilyUnavailable:
+		return false, &ErrRepositoryUnavailable{}
+	case *goes.ErrUnauthorized:
+		return false, &ErrUnauthorized{}
+	default:
+		return false, &ErrUnexpected{Err: err}
+	}
+
+	if body.Len() == 0 {
+		return false, nil
+	}
+	meta := struct {
+		TruncateBefore *int `json:"$tb"`
+	}{}
+	if err := json.Unmarshal(body.Bytes(), &meta); err != nil {
+		return false, &ErrUnexpected{Err: err}
+	}
+	return meta.TruncateBefore != nil, nil
+}
+
+// EventsByCorrelationID returns the events that have the correlation id
+// specified.
+//
+// Events are read from the $bc-<correlation id> stream maintained by the
+// $by_correlation_id system projection, which must be enabled and configured
+// with CorrelationID as its correlation id property. Events are returned in
+// the order in which they were written and without versions.
+func (r *GetEventStoreCommonDomainRepo) EventsByCorrelationID(correlationID string) ([]EventMessage, error) {
+	if r.eventFactory == nil {
+		return nil, fmt.Errorf("The common domain has no Event Factory.")
+	}
+
+	var events []EventMessage
+
+	stream := r.eventStore.NewStreamReader("$bc-" + correlationID)
+	for stream.Next() {
+		switch err := stream.Err().(type) {
+		case nil:
+			break
+		case *url.Error, *goes.ErrTemporarilyUnavailable:
+			return nil, &ErrRepositoryUnavailable{}
+		case *goes.ErrNoMoreEvents:
+			return events, nil
+		case *goes.ErrUnauthorized:
+			return nil, &ErrUnauthorized{}
+		case *goes.ErrNotFound, *goes.ErrDeleted:
+			return events, nil
+		default:
+			return nil, &ErrUnexpected{Err: err}
+		}
+
+		decoded, err := r.readStreamEvent("$bc-"+correlationID, stream)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, decoded...)
+	}
+
+	return events, nil
+}
+
+// readStreamEvent decodes the event at the current position of a stream
+// reader, which may be a link to an event of another stream. An upcaster may
+// turn the event into several events, and events of unknown types may be
+// skipped, so any number of events may be returned.
+func (r *GetEventStoreCommonDomainRepo) readStreamEvent(streamName string, stream *goes.StreamReader) ([]EventMessage, error) {
+	raw, meta, err := r.readRawEvent(stream)
+	if err != nil {
+		return nil, err
+	}
+	id := stringHeader(meta, AggregateIDHeader)
+
+	// The event read through a link is the event of the aggregate stream, so
+	// its number is its version.
+	version := Int(stream.EventResponse().Event.EventNumber)
+
+	// The payloads of claim checked events are read from the blob store when
+	// they are first used, so that events a projection skips, such as those
+	// excluded from a rebuild, are not read. Events that must be upcast or are
+	// of an unknown type are read now.
+	if stringHeader(meta, ClaimCheckHeader) != "" && r.upcaster == nil && r.eventFactory.GetEvent(raw.EventType) != nil {
+		em := NewClaimCheckEventMessage(id, raw.EventType, version, func() (interface{}, error) {
+			if err := r.readBody(raw, meta); err != nil {
+				return nil, err
+			}
+			return r.decodeEvent(id, raw)
+		})
+		for k, v := range meta {
+			em.SetHeader(k, v)
+		}
+		r.setEventID(em, stream)
+		return []EventMessage{em}, nil
+	}
+
+	if err := r.readBody(raw, meta); err != nil {
+		return nil, err
+	}
+
+	upcasted := []*RawEvent{raw}
+	if r.upcaster != nil {
+		upcasted, err = r.upcaster.Upcast(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var events []EventMessage
+	for k, rawEvent := range upcasted {
+		event, err := r.decodeEvent(id, rawEvent)
+		if err != nil {
+			return nil, err
+		}
+		if event == nil {
+			if event, err = r.unknownEvent(streamName, stream.EventResponse().Event.EventNumber, rawEvent); err != nil {
+				return nil, err
+			}
+			if event == nil {
+				continue
+			}
+		}
+
+		em := NewEventMessage(id, event, version)
+		for k, v := range meta {
+			em.SetHeader(k, v)
+		}
+		r.setEventID(em, stream)
+		em.SetHeader(EventIDHeader, splitEventID(EventIDOf(em), k))
+		if r.upcaster != nil {
+			em.SetHeader(SchemaVersionHeader, rawEvent.SchemaVersion)
+		}
+		events = append(events, em)
+	}
+	return events, nil
+}
+
+// ReadFeed reads the events at up to max positions of the feed stream, starting
+// at the position specified. The position of an event is its number in the
+// feed stream and its version is its number in the stream of its aggregate.
+func (r *GetEventStoreCommonDomainRepo) ReadFeed(from int, max int) ([]*FeedEvent, error) {
+	if r.eventFactory == nil {
+		return nil, fmt.Errorf("The common domain has no Event Factory.")
+	}
+	if r.feedStream == "" {
+		return nil, fmt.Errorf("The common domain has no feed stream.")
+	}
+
+	var events []*FeedEvent
+
+	stream := r.eventStore.NewStreamReader(r.feedStream)
+	stream.NextVersion(from)
+	for read := 0; read < max && stream.Next(); read++ {
+		switch err := stream.Err().(type) {
+		case nil:
+			break
+		case *url.Error, *goes.ErrTemporarilyUnavailable:
+			return nil, &ErrRepositoryUnavailable{}
+		case *goes.ErrNoMoreEvents, *goes.ErrNotFound:
+			return events, nil
+		case *goes.ErrUnauthorized:
+			return nil, &ErrUnauthorized{}
+		case *goes.ErrDeleted:
+			return nil, fmt.Errorf("The feed stream %s has been deleted.", r.feedStream)
+		default:
+			return nil, &ErrUnexpected{Err: err}
+		}
+
+		decoded, err := r.readStreamEvent(r.feedStream, stream)
+		if err != nil {
+			return nil, err
+		}
+		for _, em := range decoded {
+			events = append(events, &FeedEvent{Position: stream.Version(), Event: em})
+		}
+	}
+
+	return events, nil
+}
+
+// readArchivedEvents reads all of the events of a stream as they are held in
+// the store.
+func (r *GetEventStoreCommonDomainRepo) readArchivedEvents(aggregateType, id, streamName string) ([]*ArchivedEvent, error) {
+	var events []*ArchivedEvent
+
+	stream := r.eventStore.NewStreamReader(streamName)
+	for stream.Next() {
+		switch err := stream.Err().(type) {
+		case nil:
+			break
+		case *url.Error, *goes.ErrTemporarilyUnavailable:
+			return nil, &ErrRepositoryUnavailable{}
+		case *goes.ErrNoMoreEvents:
+			return events, nil
+		case *goes.ErrUnauthorized:
+			return nil, &ErrUnauthorized{}
+		case *goes.ErrNotFound:
+			return nil, &ErrAggregateNotFound{AggregateType: aggregateType, AggregateID: id}
+		case *goes.ErrDeleted:
+			return nil, &ErrAggregateDeleted{AggregateType: aggregateType, AggregateID: id}
+		default:
+			return nil, &ErrUnexpected{Err: err}
+		}
+
+		var data, meta json.RawMessage
+		stream.Scan(&data, &meta)
+		if stream.Err() != nil {
+			return nil, &ErrUnexpected{Err: stream.Err()}
+		}
+
+		ev := stream.EventResponse().Event
+		events = append(events, &ArchivedEvent{
+			StreamName:  streamName,
+			EventID:     ev.EventID,
+			EventType:   ev.EventType,
+			EventNumber: ev.EventNumber,
+			Data:        data,
+			MetaData:    meta,
+		})
+	}
+
+	return events, nil
+}
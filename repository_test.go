@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 
 	"github.com/jetbasrawi/go.geteventstore"
 	"github.com/jetbasrawi/go.geteventstore.testfeed"
@@ -408,6 +409,13 @@ func (s *ComDomRepoSuite) TestReturnsErrorOnLoadIfStreamNameDelegateIsNil(c *C)
 	c.Assert(err, DeepEquals, fmt.Errorf("The common domain repository has no stream name delegate."))
 }
 
+func (s *ComDomRepoSuite) TestReadFeedReturnsErrorIfNoFeedStreamIsSet(c *C) {
+	events, err := s.repo.ReadFeed(0, 10)
+
+	c.Assert(err, DeepEquals, fmt.Errorf("The common domain has no feed stream."))
+	c.Assert(events, IsNil)
+}
+
 func (s *ComDomRepoSuite) TestReturnsErrorOnLoadIfEventFactoryNotRegistered(c *C) {
 	s.repo.eventFactory = nil
 
@@ -425,6 +433,25 @@ func (s *ComDomRepoSuite) TestCanSetEventFactory(c *C) {
 	c.Assert(s.repo.eventFactory, Equals, eventFactory)
 }
 
+func (s *ComDomRepoSuite) TestReadFeedGivesEventsTheirVersions(c *C) {
+	s.repo.SetFeedStream("$ce-astream")
+	var events []*mock.Event
+	for k := 0; k < 2; k++ {
+		events = append(events, mock.CreateTestEventFromData("astream", s.server.URL, k,
+			&SomeEvent{Item: "Some Item", Count: k}, nil))
+	}
+	s.SetupSimulator(events, nil)
+
+	got, err := s.repo.ReadFeed(0, 10)
+
+	c.Assert(err, IsNil)
+	c.Assert(got, HasLen, 2)
+	for k, v := range got {
+		c.Assert(v.Event.Version(), NotNil)
+		c.Assert(*v.Event.Version(), Equals, k)
+	}
+}
+
 func (s *ComDomRepoSuite) TestAggregateNotFoundError(c *C) {
 
 	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -440,6 +467,36 @@ func (s *ComDomRepoSuite) TestAggregateNotFoundError(c *C) {
 	c.Assert(err, FitsTypeOf, &ErrAggregateNotFound{AggregateID: id, AggregateType: typeOf(&SomeAggregate{})})
 }
 
+func (s *ComDomRepoSuite) TestLoadReturnsErrAggregateDeletedForASoftDeletedStream(c *C) {
+
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.Method, Equals, http.MethodGet)
+		if strings.HasSuffix(r.URL.Path, "/metadata") {
+			fmt.Fprint(w, `{"$tb": 3}`)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "")
+	})
+
+	id := NewUUID()
+	agg, err := s.repo.Load(typeOf(&SomeAggregate{}), id)
+	c.Assert(agg, IsNil)
+	c.Assert(err, FitsTypeOf, &ErrAggregateDeleted{})
+}
+
+func (s *ComDomRepoSuite) TestLoadAfterASoftDeleteContinuesTheStreamVersion(c *C) {
+	ev1 := mock.CreateTestEventFromData(s.streamName, s.server.URL, 4, &SomeEvent{Item: "Some Item", Count: 4}, nil)
+	ev2 := mock.CreateTestEventFromData(s.streamName, s.server.URL, 5, &SomeEvent{Item: "Other Item", Count: 5}, nil)
+	s.SetupSimulator([]*mock.Event{ev1, ev2}, nil)
+
+	got, err := s.repo.Load(typeOf(&SomeAggregate{}), NewUUID())
+
+	c.Assert(err, IsNil)
+	c.Assert(got.(*SomeAggregate).events, HasLen, 2)
+	c.Assert(got.OriginalVersion(), Equals, 5)
+}
+
 func (s *ComDomRepoSuite) TestSaveReturnsConncurrencyException(c *C) {
 
 	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -506,9 +563,346 @@ func (s *ComDomRepoSuite) TestNewEventsArePublishedOnSave(c *C) {
 
 }
 
+func (s *ComDomRepoSuite) TestSaveWithOutboxRecordsEventsInsteadOfPublishing(c *C) {
+	fakeHandler := &FakeEventHandler{}
+	s.eventBus.AddHandler(fakeHandler, &SomeEvent{})
+	outbox := NewMemoryOutbox()
+	s.repo.SetOutbox(outbox)
+
+	agg := NewSomeAggregate(NewUUID())
+	agg.TrackChange(NewEventMessage(agg.AggregateID(), &SomeEvent{"Some data", 4}, nil))
+
+	var eventID string
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.Method, Equals, http.MethodPost)
+
+		pending, _ := outbox.Pending()
+		c.Assert(pending, HasLen, 1)
+		c.Assert(pending[0].Committed, Equals, false)
+
+		es := []*goes.Event{}
+		err := json.NewDecoder(r.Body).Decode(&es)
+		c.Assert(err, IsNil)
+		eventID = es[0].EventID
+
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	err := s.repo.Save(agg, Int(agg.OriginalVersion()))
+
+	c.Assert(err, IsNil)
+	c.Assert(fakeHandler.Events, HasLen, 0)
+	c.Assert(agg.GetChanges(), HasLen, 0)
+
+	pending, _ := outbox.Pending()
+	c.Assert(pending, HasLen, 1)
+	c.Assert(pending[0].Committed, Equals, true)
+	c.Assert(pending[0].Events[0].EventID, Equals, eventID)
+	c.Assert(*pending[0].Events[0].Version, Equals, 0)
+}
+
+func (s *ComDomRepoSuite) TestSaveWithOutboxDiscardsEntryWhenAppendFails(c *C) {
+	outbox := NewMemoryOutbox()
+	s.repo.SetOutbox(outbox)
+
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.Method, Equals, http.MethodPost)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "")
+	})
+
+	agg := NewSomeAggregate(NewUUID())
+	agg.TrackChange(NewEventMessage(agg.AggregateID(), &SomeEvent{"Some data", 4}, nil))
+
+	err := s.repo.Save(agg, Int(-1))
+
+	c.Assert(err, FitsTypeOf, &ErrConcurrencyViolation{})
+	pending, _ := outbox.Pending()
+	c.Assert(pending, HasLen, 0)
+}
+
+func (s *ComDomRepoSuite) TestSaveWithOutboxKeepsEntryWhenAppendOutcomeIsUnknown(c *C) {
+	outbox := NewMemoryOutbox()
+	s.repo.SetOutbox(outbox)
+
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.Method, Equals, http.MethodPost)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "")
+	})
+
+	agg := NewSomeAggregate(NewUUID())
+	agg.TrackChange(NewEventMessage(agg.AggregateID(), &SomeEvent{"Some data", 4}, nil))
+
+	err := s.repo.Save(agg, Int(-1))
+
+	c.Assert(err, FitsTypeOf, &ErrRepositoryUnavailable{})
+	pending, _ := outbox.Pending()
+	c.Assert(pending, HasLen, 1)
+	c.Assert(pending[0].Committed, Equals, false)
+}
+
+func (s *ComDomRepoSuite) TestSaveAllPublishesEventsAfterAllAggregatesAreSaved(c *C) {
+	fakeHandler := &FakeEventHandler{}
+	s.eventBus.AddHandler(fakeHandler, &SomeEvent{})
+
+	first := NewSomeAggregate(NewUUID())
+	first.TrackChange(NewEventMessage(first.AggregateID(), &SomeEvent{"first", 1}, nil))
+	second := NewSomeAggregate(NewUUID())
+	second.TrackChange(NewEventMessage(second.AggregateID(), &SomeEvent{"second", 2}, nil))
+
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.Method, Equals, http.MethodPost)
+		c.Assert(fakeHandler.Events, HasLen, 0)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	err := s.repo.SaveAll([]AggregateRoot{first, second}, []*int{Int(-1), Int(-1)})
+
+	c.Assert(err, IsNil)
+	c.Assert(fakeHandler.Events, HasLen, 2)
+}
+
+func (s *ComDomRepoSuite) TestSaveAllReturnsErrPartialCommit(c *C) {
+	first := NewSomeAggregate(NewUUID())
+	first.TrackChange(NewEventMessage(first.AggregateID(), &SomeEvent{"first", 1}, nil))
+	second := NewSomeAggregate(NewUUID())
+	second.TrackChange(NewEventMessage(second.AggregateID(), &SomeEvent{"second", 2}, nil))
+	secondStream, _ := s.repo.streamNameDelegate.GetStreamName(typeOf(second), second.AggregateID())
+
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.Method, Equals, http.MethodPost)
+		if r.URL.String() == fmt.Sprintf("/streams/%s", secondStream) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	err := s.repo.SaveAll([]AggregateRoot{first, second}, []*int{Int(-1), Int(-1)})
+
+	c.Assert(err, FitsTypeOf, &ErrPartialCommit{})
+	c.Assert(err.(*ErrPartialCommit).Committed, DeepEquals, []AggregateRoot{first})
+	c.Assert(err.(*ErrPartialCommit).Failed, Equals, second)
+	c.Assert(err.(*ErrPartialCommit).Err, FitsTypeOf, &ErrConcurrencyViolation{})
+}
+
+func (s *ComDomRepoSuite) TestLoadUpcastsEvents(c *C) {
+	ev1 := mock.CreateTestEventFromData(s.streamName, s.server.URL, 0, &ItemAdded{Item: "Some Item"}, nil)
+	ev2 := mock.CreateTestEventFromData(s.streamName, s.server.URL, 1, &SomeEvent{Item: "Other Item", Count: 1}, nil)
+	s.SetupSimulator([]*mock.Event{ev1, ev2}, nil)
+
+	upcasters := NewUpcasterRegistry()
+	upcasters.Register("ItemAdded", 1, TransformJSON(func(data map[string]interface{}) error {
+		data["Count"] = 0
+		return nil
+	}))
+	upcasters.Register("ItemAdded", 2, Rename("SomeEvent"))
+	s.repo.SetUpcaster(upcasters)
+
+	id := NewUUID()
+	aggregateFactory := NewDelegateAggregateFactory()
+	aggregateFactory.RegisterDelegate(&StubAggregate{},
+		func(id string) AggregateRoot { return NewStubAggregate(id) })
+	s.repo.SetAggregateFactory(aggregateFactory)
+
+	got, err := s.repo.Load(typeOf(&StubAggregate{}), id)
+
+	c.Assert(err, IsNil)
+	events := got.(*StubAggregate).events
+	c.Assert(events, HasLen, 2)
+	c.Assert(events[0].Event(), DeepEquals, &SomeEvent{Item: "Some Item", Count: 0})
+	c.Assert(events[0].GetHeaders()[SchemaVersionHeader], Equals, 2)
+	c.Assert(got.OriginalVersion(), Equals, 1)
+}
+
+func (s *ComDomRepoSuite) TestLoadGivesEventsSplitByAnUpcasterTheirOwnIDs(c *C) {
+	ev := mock.CreateTestEventFromData(s.streamName, s.server.URL, 0, &ItemAdded{Item: "Some Item"}, nil)
+	s.SetupSimulator([]*mock.Event{ev}, nil)
+
+	upcasters := NewUpcasterRegistry()
+	upcasters.Register("ItemAdded", 1, func(ev *RawEvent) ([]*RawEvent, error) {
+		first, _ := NewRawEvent("SomeEvent", 2, &SomeEvent{Item: "Some Item"})
+		second, _ := NewRawEvent("SomeOtherEvent", 2, &SomeOtherEvent{OrderID: "Some Order"})
+		return []*RawEvent{first, second}, nil
+	})
+	s.repo.SetUpcaster(upcasters)
+
+	got, err := s.repo.Load(typeOf(&SomeAggregate{}), NewUUID())
+
+	c.Assert(err, IsNil)
+	events := got.(*SomeAggregate).events
+	c.Assert(events, HasLen, 2)
+	c.Assert(EventIDOf(events[0]), Not(Equals), "")
+	c.Assert(EventIDOf(events[1]), Not(Equals), EventIDOf(events[0]))
+	c.Assert(got.OriginalVersion(), Equals, 0)
+}
+
+func (s *ComDomRepoSuite) TestLoadVersionFollowsStreamWhenUpcasterDropsEvents(c *C) {
+	ev1 := mock.CreateTestEventFromData(s.streamName, s.server.URL, 0, &ItemAdded{Item: "Some Item"}, nil)
+	ev2 := mock.CreateTestEventFromData(s.streamName, s.server.URL, 1, &SomeEvent{Item: "Other Item", Count: 1}, nil)
+	s.SetupSimulator([]*mock.Event{ev1, ev2}, nil)
+
+	upcasters := NewUpcasterRegistry()
+	upcasters.Register("ItemAdded", 1, Drop())
+	s.repo.SetUpcaster(upcasters)
+
+	got, err := s.repo.Load(typeOf(&SomeAggregate{}), NewUUID())
+
+	c.Assert(err, IsNil)
+	c.Assert(got.(*SomeAggregate).events, HasLen, 1)
+	c.Assert(got.OriginalVersion(), Equals, 1)
+}
+
+func (s *ComDomRepoSuite) TestLoadDecompressesEvents(c *C) {
+	compression, _ := NewCompression(&GzipCompressor{}, 0)
+	s.repo.SetCompression(compression)
+
+	data, _ := json.Marshal(&SomeEvent{Item: "Some Item", Count: 1})
+	compressed, _, _ := compression.Compress(data)
+	ev1 := mock.CreateTestEventFromData(s.streamName, s.server.URL, 0, compressed,
+		map[string]string{ContentEncodingHeader: "gzip"})
+	ev2 := mock.CreateTestEventFromData(s.streamName, s.server.URL, 1, &SomeEvent{Item: "Other Item", Count: 2}, nil)
+	s.SetupSimulator([]*mock.Event{ev1, ev2}, nil)
+
+	got, err := s.repo.Load(typeOf(&SomeAggregate{}), NewUUID())
+
+	c.Assert(err, IsNil)
+	events := got.(*SomeAggregate).events
+	c.Assert(events, HasLen, 2)
+	c.Assert(events[0].Event(), DeepEquals, &SomeEvent{Item: "Some Item", Count: 1})
+	c.Assert(events[1].Event(), DeepEquals, &SomeEvent{Item: "Other Item", Count: 2})
+}
+
+func (s *ComDomRepoSuite) TestLoadResolvesClaimCheckedEvents(c *C) {
+	store, _ := NewFileBlobStore(c.MkDir())
+	claimCheck, _ := NewClaimCheck(store, 0)
+	s.repo.SetClaimCheck(claimCheck)
+
+	data, _ := json.Marshal(&SomeEvent{Item: "Some Item", Count: 1})
+	ref, _ := claimCheck.Check(s.streamName, data)
+	ev := mock.CreateTestEventFromData(s.streamName, s.server.URL, 0, ref,
+		map[string]string{ClaimCheckHeader: ref.ID})
+	s.SetupSimulator([]*mock.Event{ev}, nil)
+
+	got, err := s.repo.Load(typeOf(&SomeAggregate{}), NewUUID())
+
+	c.Assert(err, IsNil)
+	events := got.(*SomeAggregate).events
+	c.Assert(events, HasLen, 1)
+	c.Assert(events[0].EventType(), Equals, "SomeEvent")
+	c.Assert(events[0].Event(), DeepEquals, &SomeEvent{Item: "Some Item", Count: 1})
+}
+
+func (s *ComDomRepoSuite) TestLoadReturnsErrBlobIntegrityForATamperedBlob(c *C) {
+	store, _ := NewFileBlobStore(c.MkDir())
+	claimCheck, _ := NewClaimCheck(store, 0)
+	s.repo.SetClaimCheck(claimCheck)
+
+	data, _ := json.Marshal(&SomeEvent{Item: "Some Item", Count: 1})
+	ref, _ := claimCheck.Check(s.streamName, data)
+	tampered := *ref
+	tampered.SHA256 = blobHash([]byte("other data"))
+	ev := mock.CreateTestEventFromData(s.streamName, s.server.URL, 0, &tampered,
+		map[string]string{ClaimCheckHeader: ref.ID})
+	s.SetupSimulator([]*mock.Event{ev}, nil)
+
+	got, err := s.repo.Load(typeOf(&SomeAggregate{}), NewUUID())
+
+	c.Assert(got, IsNil)
+	c.Assert(err, FitsTypeOf, &ErrBlobIntegrity{})
+}
+
+func (s *ComDomRepoSuite) TestLoadKeepsTheTypesOfHeaders(c *C) {
+	registry := NewHeaderRegistry()
+	RegisterTypedHeaders(registry)
+	s.repo.SetHeaderRegistry(registry)
+
+	headers := TypedHeaders()
+	ev := mock.CreateTestEventFromData(s.streamName, s.server.URL, 0, &SomeEvent{Item: "Some Item", Count: 1}, headers)
+	s.SetupSimulator([]*mock.Event{ev}, nil)
+
+	got, err := s.repo.Load(typeOf(&SomeAggregate{}), NewUUID())
+
+	c.Assert(err, IsNil)
+	c.Assert(got.(*SomeAggregate).events[0].GetHeaders(), DeepEquals, headers)
+}
+
+// SomeNewEvent is an event type that the repository's event factory does not
+// know, as if it had been written by a newer service.
+type SomeNewEvent struct {
+	Item string
+}
+
+func (s *ComDomRepoSuite) setupStreamWithUnknownEvent() {
+	ev1 := mock.CreateTestEventFromData(s.streamName, s.server.URL, 0, &SomeEvent{Item: "Some Item", Count: 1}, nil)
+	ev2 := mock.CreateTestEventFromData(s.streamName, s.server.URL, 1, &SomeNewEvent{Item: "New Item"}, nil)
+	ev3 := mock.CreateTestEventFromData(s.streamName, s.server.URL, 2, &SomeEvent{Item: "Other Item", Count: 2}, nil)
+	s.SetupSimulator([]*mock.Event{ev1, ev2, ev3}, nil)
+}
+
+func (s *ComDomRepoSuite) TestLoadFailsOnUnknownEventByDefault(c *C) {
+	s.setupStreamWithUnknownEvent()
+	id := NewUUID()
+
+	_, err := s.repo.Load(typeOf(&SomeAggregate{}), id)
+
+	c.Assert(err, DeepEquals, &ErrUnknownEvent{
+		StreamName:  typeOf(&SomeAggregate{}) + "-" + id,
+		EventNumber: 1,
+		EventType:   "SomeNewEvent",
+	})
+}
+
+func (s *ComDomRepoSuite) TestLoadSkipsUnknownEvents(c *C) {
+	s.setupStreamWithUnknownEvent()
+	s.repo.SetUnknownEventPolicy(SkipUnknownEvent)
+	var warnings []*ErrUnknownEvent
+	s.repo.SetUnknownEventHook(func(err *ErrUnknownEvent) { warnings = append(warnings, err) })
+
+	got, err := s.repo.Load(typeOf(&SomeAggregate{}), NewUUID())
+
+	c.Assert(err, IsNil)
+	c.Assert(got.(*SomeAggregate).events, HasLen, 2)
+	c.Assert(got.OriginalVersion(), Equals, 2)
+	c.Assert(warnings, HasLen, 1)
+	c.Assert(warnings[0].EventType, Equals, "SomeNewEvent")
+}
+
+func (s *ComDomRepoSuite) TestLoadDeliversUnknownEventsWithTheirJSON(c *C) {
+	s.setupStreamWithUnknownEvent()
+	s.repo.SetUnknownEventPolicy(DeliverUnknownEvent)
+
+	got, err := s.repo.Load(typeOf(&SomeAggregate{}), NewUUID())
+
+	c.Assert(err, IsNil)
+	events := got.(*SomeAggregate).events
+	c.Assert(events, HasLen, 3)
+	unknown, ok := events[1].Event().(*UnknownEvent)
+	c.Assert(ok, Equals, true)
+	c.Assert(unknown.EventType, Equals, "SomeNewEvent")
+	c.Assert(string(unknown.Data), Equals, `{"Item":"New Item"}`)
+	c.Assert(*events[1].Version(), Equals, 1)
+}
+
+func (s *ComDomRepoSuite) TestLoadSetsEventIDOfEventsSavedWithoutOne(c *C) {
+	ev := mock.CreateTestEventFromData(s.streamName, s.server.URL, 0, &SomeEvent{Item: "Some Item", Count: 1}, nil)
+	s.SetupSimulator([]*mock.Event{ev}, nil)
+
+	got, err := s.repo.Load(typeOf(&SomeAggregate{}), NewUUID())
+
+	c.Assert(err, IsNil)
+	c.Assert(EventIDOf(got.(*SomeAggregate).events[0]), Equals, ev.EventID)
+}
+
 //////////////////////////////////////////////////////////////////////////////
 // Fakes
 
+// ItemAdded is an old version of SomeEvent used to test upcasting.
+type ItemAdded struct {
+	Item string
+}
+
 type FakeEventHandler struct {
 	Events []EventMessage
 }
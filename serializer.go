@@ -0,0 +1,224 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// ContentTypeHeader is the key of the header that records the content type of
+// the serialiser used to write an event.
+//
+// Events persisted without this header are JSON.
+const ContentTypeHeader = "ContentType"
+
+// Content types of the serialisers provided.
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeGob      = "application/x-gob"
+	ContentTypeMsgpack  = "application/x-msgpack"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// Serializer is the interface that an event serialiser must implement.
+type Serializer interface {
+
+	// ContentType returns the content type recorded with events written by
+	// the serialiser.
+	ContentType() string
+
+	// Marshal returns the serialised form of an event.
+	Marshal(interface{}) ([]byte, error)
+
+	// Unmarshal deserialises data into the event instance provided.
+	Unmarshal([]byte, interface{}) error
+}
+
+// JSONSerializer serialises events as JSON.
+type JSONSerializer struct{}
+
+// ContentType returns application/json.
+func (s *JSONSerializer) ContentType() string { return ContentTypeJSON }
+
+// Marshal returns the JSON encoding of the event.
+func (s *JSONSerializer) Marshal(event interface{}) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+// Unmarshal decodes JSON into the event.
+func (s *JSONSerializer) Unmarshal(data []byte, event interface{}) error {
+	return json.Unmarshal(data, event)
+}
+
+// GobSerializer serialises events with encoding/gob.
+type GobSerializer struct{}
+
+// ContentType returns application/x-gob.
+func (s *GobSerializer) ContentType() string { return ContentTypeGob }
+
+// Marshal returns the gob encoding of the event.
+func (s *GobSerializer) Marshal(event interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(event); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes gob data into the event.
+func (s *GobSerializer) Unmarshal(data []byte, event interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(event)
+}
+
+// MsgpackSerializer serialises events with MessagePack.
+//
+// Events must implement the methods generated for a type by the msgp code
+// generator, github.com/tinylib/msgp:
+//
+//	MarshalMsg([]byte) ([]byte, error)
+//	UnmarshalMsg([]byte) ([]byte, error)
+type MsgpackSerializer struct{}
+
+type msgpackMarshaler interface {
+	MarshalMsg([]byte) ([]byte, error)
+}
+
+type msgpackUnmarshaler interface {
+	UnmarshalMsg([]byte) ([]byte, error)
+}
+
+// ContentType returns application/x-msgpack.
+func (s *MsgpackSerializer) ContentType() string { return ContentTypeMsgpack }
+
+// Marshal returns the MessagePack encoding of the event.
+func (s *MsgpackSerializer) Marshal(event interface{}) ([]byte, error) {
+	m, ok := event.(msgpackMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("Event of type %s can not be serialised as msgpack.", typeOf(event))
+	}
+	return m.MarshalMsg(nil)
+}
+
+// Unmarshal decodes MessagePack data into the event.
+func (s *MsgpackSerializer) Unmarshal(data []byte, event interface{}) error {
+	u, ok := event.(msgpackUnmarshaler)
+	if !ok {
+		return fmt.Errorf("Event of type %s can not be deserialised from msgpack.", typeOf(event))
+	}
+	_, err := u.UnmarshalMsg(data)
+	return err
+}
+
+// ProtobufSerializer serialises events with Protocol Buffers.
+//
+// Events must implement the methods generated for a message by the gogo
+// protobuf code generator, github.com/gogo/protobuf:
+//
+//	Marshal() ([]byte, error)
+//	Unmarshal([]byte) error
+type ProtobufSerializer struct{}
+
+type protobufMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+type protobufUnmarshaler interface {
+	Unmarshal([]byte) error
+}
+
+// ContentType returns application/x-protobuf.
+func (s *ProtobufSerializer) ContentType() string { return ContentTypeProtobuf }
+
+// Marshal returns the protobuf encoding of the event.
+func (s *ProtobufSerializer) Marshal(event interface{}) ([]byte, error) {
+	m, ok := event.(protobufMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("Event of type %s can not be serialised as protobuf.", typeOf(event))
+	}
+	return m.Marshal()
+}
+
+// Unmarshal decodes protobuf data into the event.
+func (s *ProtobufSerializer) Unmarshal(data []byte, event interface{}) error {
+	u, ok := event.(protobufUnmarshaler)
+	if !ok {
+		return fmt.Errorf("Event of type %s can not be deserialised from protobuf.", typeOf(event))
+	}
+	return u.Unmarshal(data)
+}
+
+// SerializerRegistry selects the serialiser used to write each event type and
+// finds the serialiser needed to read an event by its content type.
+//
+// A new registry can read events written by any of the serialisers provided
+// by this package, and writes all events as JSON until another serialiser is
+// selected for an event type.
+type SerializerRegistry struct {
+	byContentType map[string]Serializer
+	byEventType   map[string]Serializer
+	json          Serializer
+}
+
+// NewSerializerRegistry constructs a new SerializerRegistry.
+func NewSerializerRegistry() *SerializerRegistry {
+	r := &SerializerRegistry{
+		byContentType: make(map[string]Serializer),
+		byEventType:   make(map[string]Serializer),
+		json:          &JSONSerializer{},
+	}
+	for _, s := range []Serializer{r.json, &GobSerializer{}, &MsgpackSerializer{}, &ProtobufSerializer{}} {
+		r.byContentType[s.ContentType()] = s
+	}
+	return r
+}
+
+// Register makes a serialiser available for reading events of its content
+// type. Registering a serialiser replaces any serialiser previously
+// registered for the same content type.
+func (r *SerializerRegistry) Register(serializer Serializer) {
+	r.byContentType[serializer.ContentType()] = serializer
+}
+
+// Use selects the serialiser used to write the events specified in the
+// variadic events parameter. The serialiser is also registered for reading.
+func (r *SerializerRegistry) Use(serializer Serializer, events ...interface{}) error {
+	for _, event := range events {
+		typeName, err := TypeNameOf(event)
+		if err != nil {
+			return err
+		}
+		if _, ok := r.byEventType[typeName]; ok {
+			return fmt.Errorf("Serializer already selected for type: \"%s\"", typeName)
+		}
+		r.byEventType[typeName] = serializer
+	}
+	r.Register(serializer)
+	return nil
+}
+
+// SerializerFor returns the serialiser used to write events of the type
+// specified.
+func (r *SerializerRegistry) SerializerFor(eventType string) Serializer {
+	if s, ok := r.byEventType[ResolveTypeName(eventType)]; ok {
+		return s
+	}
+	return r.json
+}
+
+// SerializerByContentType returns the serialiser for reading events of the
+// content type specified. An empty content type is JSON.
+func (r *SerializerRegistry) SerializerByContentType(contentType string) (Serializer, error) {
+	if contentType == "" {
+		return r.json, nil
+	}
+	if s, ok := r.byContentType[contentType]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("There is no serializer registered for content type: %s", contentType)
+}
@@ -0,0 +1,154 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"fmt"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&SerializerSuite{})
+
+type SerializerSuite struct {
+	registry *SerializerRegistry
+}
+
+func (s *SerializerSuite) SetUpTest(c *C) {
+	s.registry = NewSerializerRegistry()
+}
+
+func (s *SerializerSuite) TestJSONRoundTrip(c *C) {
+	ser := &JSONSerializer{}
+	ev := &SomeEvent{Item: "Some Item", Count: 42}
+
+	data, err := ser.Marshal(ev)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, `{"Item":"Some Item","Count":42}`)
+
+	got := &SomeEvent{}
+	c.Assert(ser.Unmarshal(data, got), IsNil)
+	c.Assert(got, DeepEquals, ev)
+}
+
+func (s *SerializerSuite) TestGobRoundTrip(c *C) {
+	ser := &GobSerializer{}
+	ev := &SomeEvent{Item: "Some Item", Count: 42}
+
+	data, err := ser.Marshal(ev)
+	c.Assert(err, IsNil)
+
+	got := &SomeEvent{}
+	c.Assert(ser.Unmarshal(data, got), IsNil)
+	c.Assert(got, DeepEquals, ev)
+}
+
+func (s *SerializerSuite) TestMsgpackUsesGeneratedMethods(c *C) {
+	ser := &MsgpackSerializer{}
+
+	data, err := ser.Marshal(&FakeMsgpackEvent{Item: "a"})
+	c.Assert(err, IsNil)
+
+	got := &FakeMsgpackEvent{}
+	c.Assert(ser.Unmarshal(data, got), IsNil)
+	c.Assert(got.Item, Equals, "a")
+}
+
+func (s *SerializerSuite) TestMsgpackReturnsAnErrorForUnsupportedTypes(c *C) {
+	ser := &MsgpackSerializer{}
+
+	_, err := ser.Marshal(&SomeEvent{})
+	c.Assert(err, DeepEquals, fmt.Errorf("Event of type SomeEvent can not be serialised as msgpack."))
+
+	err = ser.Unmarshal([]byte{}, &SomeEvent{})
+	c.Assert(err, DeepEquals, fmt.Errorf("Event of type SomeEvent can not be deserialised from msgpack."))
+}
+
+func (s *SerializerSuite) TestProtobufUsesGeneratedMethods(c *C) {
+	ser := &ProtobufSerializer{}
+
+	data, err := ser.Marshal(&FakeProtobufEvent{Item: "a"})
+	c.Assert(err, IsNil)
+
+	got := &FakeProtobufEvent{}
+	c.Assert(ser.Unmarshal(data, got), IsNil)
+	c.Assert(got.Item, Equals, "a")
+}
+
+func (s *SerializerSuite) TestProtobufReturnsAnErrorForUnsupportedTypes(c *C) {
+	ser := &ProtobufSerializer{}
+
+	_, err := ser.Marshal(&SomeEvent{})
+	c.Assert(err, DeepEquals, fmt.Errorf("Event of type SomeEvent can not be serialised as protobuf."))
+}
+
+func (s *SerializerSuite) TestEventsAreWrittenAsJSONByDefault(c *C) {
+	c.Assert(s.registry.SerializerFor("SomeEvent").ContentType(), Equals, ContentTypeJSON)
+}
+
+func (s *SerializerSuite) TestUseSelectsTheSerializerForAnEventType(c *C) {
+	err := s.registry.Use(&GobSerializer{}, &SomeEvent{})
+
+	c.Assert(err, IsNil)
+	c.Assert(s.registry.SerializerFor("SomeEvent").ContentType(), Equals, ContentTypeGob)
+	c.Assert(s.registry.SerializerFor("SomeOtherEvent").ContentType(), Equals, ContentTypeJSON)
+}
+
+func (s *SerializerSuite) TestUseReturnsAnErrorIfASerializerIsAlreadySelected(c *C) {
+	c.Assert(s.registry.Use(&GobSerializer{}, &SomeEvent{}), IsNil)
+
+	err := s.registry.Use(&JSONSerializer{}, &SomeEvent{})
+
+	c.Assert(err, DeepEquals, fmt.Errorf("Serializer already selected for type: \"SomeEvent\""))
+}
+
+func (s *SerializerSuite) TestSerializerByContentType(c *C) {
+	for _, ct := range []string{ContentTypeJSON, ContentTypeGob, ContentTypeMsgpack, ContentTypeProtobuf} {
+		ser, err := s.registry.SerializerByContentType(ct)
+		c.Assert(err, IsNil)
+		c.Assert(ser.ContentType(), Equals, ct)
+	}
+
+	ser, err := s.registry.SerializerByContentType("")
+	c.Assert(err, IsNil)
+	c.Assert(ser.ContentType(), Equals, ContentTypeJSON)
+}
+
+func (s *SerializerSuite) TestSerializerByContentTypeReturnsAnErrorForUnknownTypes(c *C) {
+	ser, err := s.registry.SerializerByContentType("text/csv")
+
+	c.Assert(ser, IsNil)
+	c.Assert(err, DeepEquals, fmt.Errorf("There is no serializer registered for content type: text/csv"))
+}
+
+// FakeMsgpackEvent implements the methods generated by msgp. The encoding is
+// simply the raw bytes of the item.
+type FakeMsgpackEvent struct {
+	Item string
+}
+
+func (e *FakeMsgpackEvent) MarshalMsg(b []byte) ([]byte, error) {
+	return append(b, e.Item...), nil
+}
+
+func (e *FakeMsgpackEvent) UnmarshalMsg(b []byte) ([]byte, error) {
+	e.Item = string(b)
+	return nil, nil
+}
+
+// FakeProtobufEvent implements the methods generated by gogo protobuf.
+type FakeProtobufEvent struct {
+	Item string
+}
+
+func (e *FakeProtobufEvent) Marshal() ([]byte, error) {
+	return []byte(e.Item), nil
+}
+
+func (e *FakeProtobufEvent) Unmarshal(b []byte) error {
+	e.Item = string(b)
+	return nil
+}
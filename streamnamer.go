@@ -31,7 +31,10 @@ func NewDelegateStreamNamer() *DelegateStreamNamer {
 // the aggregates specified in the variadic aggregates argument.
 func (r *DelegateStreamNamer) RegisterDelegate(delegate func(string, string) string, aggregates ...AggregateRoot) error {
 	for _, aggregate := range aggregates {
-		typeName := typeOf(aggregate)
+		typeName, err := TypeNameOf(aggregate)
+		if err != nil {
+			return err
+		}
 		if _, ok := r.delegates[typeName]; ok {
 			return fmt.Errorf("The stream name delegate for \"%s\" is already registered with the stream namer.",
 				typeName)
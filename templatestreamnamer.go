@@ -0,0 +1,264 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Placeholders that may be used in stream name templates.
+const (
+	ContextPlaceholder = "{context}"
+	TypePlaceholder    = "{type}"
+	IDPlaceholder      = "{id}"
+)
+
+// TemplateStreamNamer is a StreamNamer that builds stream names from templates
+// such as "{context}-{type}-{id}" and can parse a stream name back into the
+// aggregate type and id, for instance to find the aggregate of an event read
+// from a $ce- category stream.
+//
+// Templates may be registered for particular aggregate types. Other aggregate
+// types use the default template.
+//
+// So that every stream name can be parsed, the value of a placeholder may not
+// contain the text that follows the placeholder in the template. With the
+// template "{context}-{type}-{id}" the context and type may not contain a
+// hyphen, while the id, which ends the name, may contain anything. Names that
+// would be ambiguous are refused with an error.
+type TemplateStreamNamer struct {
+	context   string
+	fallback  *streamTemplate
+	templates []*streamTemplate
+	byType    map[string]*streamTemplate
+}
+
+// streamTemplate is a parsed stream name template, a sequence of literal text
+// and placeholders.
+type streamTemplate struct {
+	text          string
+	aggregateType string
+	segments      []string
+}
+
+// NewTemplateStreamNamer constructs a new TemplateStreamNamer for the bounded
+// context specified, with the default template used for aggregate types that
+// have no template registered. The default template must contain the {type}
+// and {id} placeholders.
+func NewTemplateStreamNamer(context, defaultTemplate string) (*TemplateStreamNamer, error) {
+	n := &TemplateStreamNamer{
+		context: context,
+		byType:  make(map[string]*streamTemplate),
+	}
+
+	t, err := n.parseTemplate(defaultTemplate, "")
+	if err != nil {
+		return nil, err
+	}
+	n.fallback = t
+	return n, nil
+}
+
+// RegisterTemplate registers the template used for the aggregates specified
+// by the variadic aggregates parameter. The template must contain the {id}
+// placeholder.
+func (n *TemplateStreamNamer) RegisterTemplate(template string, aggregates ...AggregateRoot) error {
+	for _, aggregate := range aggregates {
+		typeName, err := TypeNameOf(aggregate)
+		if err != nil {
+			return err
+		}
+		if _, ok := n.byType[typeName]; ok {
+			return fmt.Errorf("The stream name template for \"%s\" is already registered with the stream namer.",
+				typeName)
+		}
+		t, err := n.parseTemplate(template, typeName)
+		if err != nil {
+			return err
+		}
+		n.byType[typeName] = t
+		n.templates = append(n.templates, t)
+	}
+	return nil
+}
+
+// GetStreamName returns the stream name of the aggregate specified.
+func (n *TemplateStreamNamer) GetStreamName(aggregateTypeName string, id string) (string, error) {
+	t, ok := n.byType[aggregateTypeName]
+	if !ok {
+		t = n.fallback
+	}
+
+	values := map[string]string{
+		ContextPlaceholder: n.context,
+		TypePlaceholder:    aggregateTypeName,
+		IDPlaceholder:      id,
+	}
+
+	var name string
+	for k, seg := range t.segments {
+		value, ok := values[seg]
+		if !ok {
+			name += seg
+			continue
+		}
+		if value == "" {
+			return "", fmt.Errorf("The %s of a stream name can not be empty.", strings.Trim(seg, "{}"))
+		}
+		if k+1 < len(t.segments) && strings.Contains(value, t.segments[k+1]) {
+			return "", fmt.Errorf("The %s \"%s\" can not be used in stream name template \"%s\" because it contains \"%s\".",
+				strings.Trim(seg, "{}"), value, t.text, t.segments[k+1])
+		}
+		name += value
+	}
+	return name, nil
+}
+
+// Parse returns the aggregate type and id of a stream name built by the
+// namer.
+//
+// A stream name is parsed with every template, so that a name is never
+// silently taken for the stream of another aggregate type. If a name matches
+// more than one template, as a name built by the default template
+// "{type}-{id}" does a template "Order-{id}" when the type is Order, an
+// error is returned. Templates should be chosen so that the names they build
+// do not overlap.
+func (n *TemplateStreamNamer) Parse(streamName string) (aggregateType, id string, err error) {
+	templates := make([]*streamTemplate, 0, len(n.templates)+1)
+	templates = append(templates, n.templates...)
+	templates = append(templates, n.fallback)
+
+	var matched *streamTemplate
+	for _, t := range templates {
+		typ, value, ok := n.match(t, streamName)
+		if !ok {
+			continue
+		}
+		if t == n.fallback {
+			// The default template is not used for aggregate types that
+			// have a template of their own.
+			if _, registered := n.byType[typ]; registered {
+				continue
+			}
+		}
+		if matched != nil {
+			return "", "", fmt.Errorf("The stream name \"%s\" matches both stream name template \"%s\" and \"%s\".",
+				streamName, matched.text, t.text)
+		}
+		matched, aggregateType, id = t, typ, value
+	}
+	if matched == nil {
+		return "", "", fmt.Errorf("The stream name \"%s\" does not match any stream name template.", streamName)
+	}
+	return aggregateType, id, nil
+}
+
+// match parses a stream name with a template.
+func (n *TemplateStreamNamer) match(t *streamTemplate, streamName string) (aggregateType, id string, ok bool) {
+	aggregateType = t.aggregateType
+	rest := streamName
+	for k, seg := range t.segments {
+		if !isPlaceholder(seg) {
+			if !strings.HasPrefix(rest, seg) {
+				return "", "", false
+			}
+			rest = rest[len(seg):]
+			continue
+		}
+
+		value := rest
+		if k+1 < len(t.segments) {
+			i := strings.Index(rest, t.segments[k+1])
+			if i < 0 {
+				return "", "", false
+			}
+			value = rest[:i]
+		}
+		if value == "" {
+			return "", "", false
+		}
+		rest = rest[len(value):]
+
+		switch seg {
+		case ContextPlaceholder:
+			if value != n.context {
+				return "", "", false
+			}
+		case TypePlaceholder:
+			if t.aggregateType != "" && value != t.aggregateType {
+				return "", "", false
+			}
+			aggregateType = value
+		case IDPlaceholder:
+			id = value
+		}
+	}
+	return aggregateType, id, rest == ""
+}
+
+// parseTemplate splits a template into literal text and placeholders and
+// checks that names built from it can be parsed.
+func (n *TemplateStreamNamer) parseTemplate(template, aggregateType string) (*streamTemplate, error) {
+	t := &streamTemplate{
+		text:          template,
+		aggregateType: aggregateType,
+	}
+
+	rest := template
+	for rest != "" {
+		i := strings.Index(rest, "{")
+		if i < 0 {
+			t.segments = append(t.segments, rest)
+			break
+		}
+		if i > 0 {
+			t.segments = append(t.segments, rest[:i])
+		}
+		j := strings.Index(rest[i:], "}")
+		if j < 0 {
+			return nil, fmt.Errorf("The stream name template \"%s\" has an unclosed placeholder.", template)
+		}
+		seg := rest[i : i+j+1]
+		switch seg {
+		case ContextPlaceholder, TypePlaceholder, IDPlaceholder:
+		default:
+			return nil, fmt.Errorf("The stream name template \"%s\" has an unknown placeholder %s.", template, seg)
+		}
+		t.segments = append(t.segments, seg)
+		rest = rest[i+j+1:]
+	}
+
+	counts := make(map[string]int)
+	for k, seg := range t.segments {
+		if !isPlaceholder(seg) {
+			continue
+		}
+		counts[seg]++
+		if k > 0 && isPlaceholder(t.segments[k-1]) {
+			return nil, fmt.Errorf("The placeholders of stream name template \"%s\" must be separated.", template)
+		}
+	}
+	for seg, count := range counts {
+		if count > 1 {
+			return nil, fmt.Errorf("The stream name template \"%s\" has more than one %s placeholder.", template, seg)
+		}
+	}
+	if counts[IDPlaceholder] == 0 {
+		return nil, fmt.Errorf("The stream name template \"%s\" has no %s placeholder.", template, IDPlaceholder)
+	}
+	if aggregateType == "" && counts[TypePlaceholder] == 0 {
+		return nil, fmt.Errorf("The default stream name template \"%s\" has no %s placeholder.", template, TypePlaceholder)
+	}
+	if counts[ContextPlaceholder] > 0 && n.context == "" {
+		return nil, fmt.Errorf("The stream name template \"%s\" has a %s placeholder but there is no context.", template, ContextPlaceholder)
+	}
+	return t, nil
+}
+
+func isPlaceholder(seg string) bool {
+	return strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}")
+}
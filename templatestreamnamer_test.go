@@ -0,0 +1,119 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&TemplateStreamNamerSuite{})
+
+type TemplateStreamNamerSuite struct {
+	namer *TemplateStreamNamer
+}
+
+func (s *TemplateStreamNamerSuite) SetUpTest(c *C) {
+	s.namer, _ = NewTemplateStreamNamer("inventory", "{context}-{type}-{id}")
+}
+
+func (s *TemplateStreamNamerSuite) TestDefaultTemplate(c *C) {
+	name, err := s.namer.GetStreamName("SomeAggregate", "1e4f-22")
+
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, "inventory-SomeAggregate-1e4f-22")
+}
+
+func (s *TemplateStreamNamerSuite) TestRegisteredTemplate(c *C) {
+	err := s.namer.RegisterTemplate("{context}.other.{id}", &SomeOtherAggregate{})
+	c.Assert(err, IsNil)
+
+	name, err := s.namer.GetStreamName("SomeOtherAggregate", "1")
+
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, "inventory.other.1")
+}
+
+func (s *TemplateStreamNamerSuite) TestParseIsTheInverseOfGetStreamName(c *C) {
+	s.namer.RegisterTemplate("{context}.other.{id}", &SomeOtherAggregate{})
+	id := NewUUID()
+
+	for _, aggregateType := range []string{"SomeAggregate", "SomeOtherAggregate", "StubAggregate"} {
+		name, err := s.namer.GetStreamName(aggregateType, id)
+		c.Assert(err, IsNil)
+
+		gotType, gotID, err := s.namer.Parse(name)
+
+		c.Assert(err, IsNil)
+		c.Assert(gotType, Equals, aggregateType)
+		c.Assert(gotID, Equals, id)
+	}
+}
+
+func (s *TemplateStreamNamerSuite) TestParseUnknownStreamReturnsError(c *C) {
+	_, _, err := s.namer.Parse("billing-SomeAggregate-1")
+
+	c.Assert(err, ErrorMatches, "The stream name \"billing-SomeAggregate-1\" does not match any stream name template.")
+}
+
+func (s *TemplateStreamNamerSuite) TestParseOfNameMatchingMoreThanOneTemplateReturnsError(c *C) {
+	s.namer.RegisterTemplate("inventory-Order-{id}", &SomeOtherAggregate{})
+	name, _ := s.namer.GetStreamName("Order", "1")
+
+	_, _, err := s.namer.Parse(name)
+
+	c.Assert(err, ErrorMatches, "The stream name \"inventory-Order-1\" matches both stream name template \"inventory-Order-{id}\" and \"{context}-{type}-{id}\".")
+}
+
+func (s *TemplateStreamNamerSuite) TestTemplateCapturingAllNamesMakesParseFail(c *C) {
+	s.namer.RegisterTemplate("{id}", &SomeOtherAggregate{})
+	name, _ := s.namer.GetStreamName("SomeAggregate", "1")
+
+	_, _, err := s.namer.Parse(name)
+
+	c.Assert(err, ErrorMatches, "The stream name .* matches both .*")
+}
+
+func (s *TemplateStreamNamerSuite) TestAmbiguousValuesAreRefused(c *C) {
+	namer, _ := NewTemplateStreamNamer("inventory", "{type}-{id}-{context}")
+
+	_, err := namer.GetStreamName("SomeAggregate", "1-2")
+
+	c.Assert(err, ErrorMatches, "The id \"1-2\" can not be used in stream name template \"{type}-{id}-{context}\" because it contains \"-\".")
+}
+
+func (s *TemplateStreamNamerSuite) TestEmptyIDIsRefused(c *C) {
+	_, err := s.namer.GetStreamName("SomeAggregate", "")
+
+	c.Assert(err, ErrorMatches, "The id of a stream name can not be empty.")
+}
+
+func (s *TemplateStreamNamerSuite) TestInvalidTemplates(c *C) {
+	_, err := NewTemplateStreamNamer("inventory", "{context}-{id}")
+	c.Assert(err, ErrorMatches, "The default stream name template \"{context}-{id}\" has no {type} placeholder.")
+
+	_, err = NewTemplateStreamNamer("inventory", "{type}{id}")
+	c.Assert(err, ErrorMatches, "The placeholders of stream name template \"{type}{id}\" must be separated.")
+
+	_, err = NewTemplateStreamNamer("inventory", "{type}-{id}-{id}")
+	c.Assert(err, ErrorMatches, "The stream name template \"{type}-{id}-{id}\" has more than one {id} placeholder.")
+
+	_, err = NewTemplateStreamNamer("inventory", "{type}-{name}")
+	c.Assert(err, ErrorMatches, "The stream name template \"{type}-{name}\" has an unknown placeholder {name}.")
+
+	_, err = NewTemplateStreamNamer("", "{context}-{type}-{id}")
+	c.Assert(err, ErrorMatches, "The stream name template \"{context}-{type}-{id}\" has a {context} placeholder but there is no context.")
+
+	err = s.namer.RegisterTemplate("other", &SomeOtherAggregate{})
+	c.Assert(err, ErrorMatches, "The stream name template \"other\" has no {id} placeholder.")
+}
+
+func (s *TemplateStreamNamerSuite) TestDuplicateRegistrationReturnsError(c *C) {
+	s.namer.RegisterTemplate("other-{id}", &SomeOtherAggregate{})
+
+	err := s.namer.RegisterTemplate("other-{id}", &SomeOtherAggregate{})
+
+	c.Assert(err, ErrorMatches, "The stream name template for \"SomeOtherAggregate\" is already registered with the stream namer.")
+}
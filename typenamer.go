@@ -0,0 +1,178 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// TypeNamer is the interface that a strategy for naming event, command and
+// aggregate types must implement.
+//
+// Type names are used to route events and commands to their handlers, to
+// instantiate events and aggregates in factories and are persisted as the
+// type of events in the store. The same TypeNamer is used throughout the
+// package and is set with SetTypeNamer.
+type TypeNamer interface {
+
+	// TypeName returns the name of the type of the value specified. Values
+	// may be pointers or non pointers; both name the same type.
+	TypeName(v interface{}) (string, error)
+}
+
+// NamedType is the interface implemented by types that declare their own
+// type name. Declared names are used by the ExplicitTypeNamer.
+type NamedType interface {
+	TypeName() string
+}
+
+// ShortTypeNamer names types by their name without their package, for
+// instance Created for orders.Created.
+//
+// This is the default TypeNamer. Types of the same name in different packages
+// have the same name.
+type ShortTypeNamer struct{}
+
+// TypeName returns the name of the type without its package.
+func (ShortTypeNamer) TypeName(v interface{}) (string, error) {
+	t, err := namedTypeOf(v)
+	if err != nil {
+		return "", err
+	}
+	return t.Name(), nil
+}
+
+// QualifiedTypeNamer names types by their import path and name, for instance
+// github.com/acme/orders.Created for orders.Created.
+type QualifiedTypeNamer struct{}
+
+// TypeName returns the import path and name of the type.
+func (QualifiedTypeNamer) TypeName(v interface{}) (string, error) {
+	t, err := namedTypeOf(v)
+	if err != nil {
+		return "", err
+	}
+	if t.PkgPath() == "" {
+		return t.Name(), nil
+	}
+	return t.PkgPath() + "." + t.Name(), nil
+}
+
+// ExplicitTypeNamer names types that implement NamedType by the name they
+// declare. Other types are named by the Fallback namer or, if there is no
+// Fallback, an error is returned.
+type ExplicitTypeNamer struct {
+	Fallback TypeNamer
+}
+
+// TypeName returns the name declared by the type.
+func (n ExplicitTypeNamer) TypeName(v interface{}) (string, error) {
+	t, err := namedTypeOf(v)
+	if err != nil {
+		return "", err
+	}
+
+	// The declared name is read from a new value so that a nil pointer of
+	// the type can also be named.
+	if named, ok := reflect.New(t).Interface().(NamedType); ok {
+		if name := named.TypeName(); name != "" {
+			return name, nil
+		}
+	} else if named, ok := reflect.New(t).Elem().Interface().(NamedType); ok {
+		if name := named.TypeName(); name != "" {
+			return name, nil
+		}
+	}
+
+	if n.Fallback == nil {
+		return "", fmt.Errorf("Type %s does not declare a type name.", t)
+	}
+	return n.Fallback.TypeName(v)
+}
+
+// namedTypeOf returns the named type of a value, dereferencing pointers.
+func namedTypeOf(v interface{}) (reflect.Type, error) {
+	if v == nil {
+		return nil, fmt.Errorf("Can not name the type of a nil value.")
+	}
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Name() == "" {
+		return nil, fmt.Errorf("Can not name the unnamed type %s.", reflect.TypeOf(v))
+	}
+	return t, nil
+}
+
+var typeNames = struct {
+	sync.RWMutex
+	namer   TypeNamer
+	aliases map[string]string
+}{
+	namer:   ShortTypeNamer{},
+	aliases: make(map[string]string),
+}
+
+// SetTypeNamer sets the TypeNamer used throughout the package.
+//
+// The namer should be set once, before any handlers, delegates or serialisers
+// are registered, since these are registered by type name.
+func SetTypeNamer(namer TypeNamer) {
+	if namer == nil {
+		namer = ShortTypeNamer{}
+	}
+	typeNames.Lock()
+	typeNames.namer = namer
+	typeNames.Unlock()
+}
+
+// TypeNameOf returns the name of the type of the value specified as given by
+// the TypeNamer set with SetTypeNamer.
+func TypeNameOf(v interface{}) (string, error) {
+	typeNames.RLock()
+	namer := typeNames.namer
+	typeNames.RUnlock()
+	return namer.TypeName(v)
+}
+
+// RegisterTypeAlias registers a former name of a type, so that events
+// persisted under the former name are instantiated as the type of the
+// current name.
+func RegisterTypeAlias(alias, typeName string) error {
+	if alias == typeName {
+		return fmt.Errorf("Type alias \"%s\" is the name of the type.", alias)
+	}
+
+	typeNames.Lock()
+	defer typeNames.Unlock()
+
+	if _, ok := typeNames.aliases[alias]; ok {
+		return fmt.Errorf("Type alias already registered: \"%s\"", alias)
+	}
+	typeNames.aliases[alias] = typeName
+	return nil
+}
+
+// ResolveTypeName returns the current name of a type given any of its
+// registered aliases. Names that are not aliases are returned unchanged.
+func ResolveTypeName(typeName string) string {
+	typeNames.RLock()
+	defer typeNames.RUnlock()
+
+	// Aliases may refer to aliases where a type has been renamed more than
+	// once. The number of steps is bounded in case aliases form a cycle.
+	for i := 0; i <= len(typeNames.aliases); i++ {
+		name, ok := typeNames.aliases[typeName]
+		if !ok {
+			break
+		}
+		typeName = name
+	}
+	return typeName
+}
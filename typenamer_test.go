@@ -0,0 +1,148 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&TypeNamerSuite{})
+
+type TypeNamerSuite struct{}
+
+// SomeNamedEvent is an event that declares its own type name.
+type SomeNamedEvent struct {
+	Item string
+}
+
+func (SomeNamedEvent) TypeName() string { return "orders.SomeNamedEvent" }
+
+// SomeRenamedEvent is an event that was formerly persisted as SomeOldEvent.
+type SomeRenamedEvent struct {
+	Item string
+}
+
+func (s *TypeNamerSuite) TearDownTest(c *C) {
+	SetTypeNamer(nil)
+	typeNames.Lock()
+	typeNames.aliases = make(map[string]string)
+	typeNames.Unlock()
+}
+
+func (s *TypeNamerSuite) TestShortTypeNamerNamesPointersAndValues(c *C) {
+	namer := ShortTypeNamer{}
+
+	ptr, err := namer.TypeName(&SomeEvent{})
+	c.Assert(err, IsNil)
+	val, err := namer.TypeName(SomeEvent{})
+	c.Assert(err, IsNil)
+
+	c.Assert(ptr, Equals, "SomeEvent")
+	c.Assert(val, Equals, "SomeEvent")
+}
+
+func (s *TypeNamerSuite) TestQualifiedTypeNamerIncludesImportPath(c *C) {
+	name, err := QualifiedTypeNamer{}.TypeName(&SomeEvent{})
+
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, "github.com/jetbasrawi/go.cqrs.SomeEvent")
+}
+
+func (s *TypeNamerSuite) TestExplicitTypeNamerUsesDeclaredName(c *C) {
+	namer := ExplicitTypeNamer{}
+
+	name, err := namer.TypeName(&SomeNamedEvent{})
+
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, "orders.SomeNamedEvent")
+}
+
+func (s *TypeNamerSuite) TestExplicitTypeNamerFallsBack(c *C) {
+	namer := ExplicitTypeNamer{Fallback: ShortTypeNamer{}}
+
+	name, err := namer.TypeName(&SomeEvent{})
+
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, "SomeEvent")
+}
+
+func (s *TypeNamerSuite) TestExplicitTypeNamerWithoutFallbackReturnsError(c *C) {
+	_, err := ExplicitTypeNamer{}.TypeName(&SomeEvent{})
+
+	c.Assert(err, ErrorMatches, "Type ycq.SomeEvent does not declare a type name.")
+}
+
+func (s *TypeNamerSuite) TestNilAndUnnamedTypesReturnErrors(c *C) {
+	_, err := TypeNameOf(nil)
+	c.Assert(err, ErrorMatches, "Can not name the type of a nil value.")
+
+	_, err = TypeNameOf(map[string]string{})
+	c.Assert(err, ErrorMatches, "Can not name the unnamed type map\\[string\\]string.")
+}
+
+func (s *TypeNamerSuite) TestRegistrationReturnsErrorInsteadOfPanicking(c *C) {
+	dispatcher := NewInMemoryDispatcher()
+	bus := NewInternalEventBus()
+	factory := NewDelegateEventFactory()
+
+	c.Assert(dispatcher.RegisterHandler(&TestCommandHandler{}, nil), NotNil)
+	c.Assert(bus.RegisterHandler(NewMockEventHandler(), nil), NotNil)
+	c.Assert(factory.RegisterDelegate(nil, func() interface{} { return nil }), NotNil)
+}
+
+func (s *TypeNamerSuite) TestNonPointerRegistrationNamesTheSameType(c *C) {
+	factory := NewDelegateEventFactory()
+	factory.RegisterDelegate(SomeEvent{}, func() interface{} { return &SomeEvent{} })
+
+	c.Assert(factory.GetEvent("SomeEvent"), DeepEquals, &SomeEvent{})
+}
+
+func (s *TypeNamerSuite) TestQualifiedNamesDoNotCollide(c *C) {
+	SetTypeNamer(QualifiedTypeNamer{})
+	factory := NewDelegateEventFactory()
+	factory.RegisterDelegate(&SomeEvent{}, func() interface{} { return &SomeEvent{} })
+
+	em := NewEventMessage(NewUUID(), &SomeEvent{}, nil)
+
+	c.Assert(em.EventType(), Equals, "github.com/jetbasrawi/go.cqrs.SomeEvent")
+	c.Assert(factory.GetEvent(em.EventType()), DeepEquals, &SomeEvent{})
+	c.Assert(factory.GetEvent("SomeEvent"), IsNil)
+}
+
+func (s *TypeNamerSuite) TestTypeNamerIsUsedForRouting(c *C) {
+	SetTypeNamer(ExplicitTypeNamer{Fallback: ShortTypeNamer{}})
+	bus := NewInternalEventBus()
+	handler := NewMockEventHandler()
+	c.Assert(bus.RegisterHandler(handler, &SomeNamedEvent{}), IsNil)
+
+	bus.PublishEvent(NewEventMessage(NewUUID(), &SomeNamedEvent{"Some item"}, nil))
+
+	c.Assert(handler.events, HasLen, 1)
+}
+
+func (s *TypeNamerSuite) TestAliasResolvesToRenamedType(c *C) {
+	c.Assert(RegisterTypeAlias("SomeOldEvent", "SomeRenamedEvent"), IsNil)
+	factory := NewDelegateEventFactory()
+	factory.RegisterDelegate(&SomeRenamedEvent{}, func() interface{} { return &SomeRenamedEvent{} })
+
+	c.Assert(factory.GetEvent("SomeOldEvent"), DeepEquals, &SomeRenamedEvent{})
+}
+
+func (s *TypeNamerSuite) TestAliasesCanBeChained(c *C) {
+	RegisterTypeAlias("SomeOlderEvent", "SomeOldEvent")
+	RegisterTypeAlias("SomeOldEvent", "SomeRenamedEvent")
+
+	c.Assert(ResolveTypeName("SomeOlderEvent"), Equals, "SomeRenamedEvent")
+	c.Assert(ResolveTypeName("SomeEvent"), Equals, "SomeEvent")
+}
+
+func (s *TypeNamerSuite) TestDuplicateAliasReturnsError(c *C) {
+	RegisterTypeAlias("SomeOldEvent", "SomeRenamedEvent")
+
+	err := RegisterTypeAlias("SomeOldEvent", "SomeEvent")
+
+	c.Assert(err, ErrorMatches, "Type alias already registered: \"SomeOldEvent\"")
+}
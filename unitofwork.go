@@ -0,0 +1,142 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+// BatchSaver is implemented by repositories that can save the changes of
+// several aggregates in one call and publish the resulting events only after
+// all of the aggregates have been saved.
+//
+// Whether a batch is saved atomically depends on the backend. The
+// InMemoryRepository saves a batch atomically. GetEventStore does not support
+// transactions across streams and so the GetEventStoreCommonDomainRepo saves
+// each aggregate in turn, returning an *ErrPartialCommit if it fails part way.
+type BatchSaver interface {
+	SaveAll(aggregates []AggregateRoot, expectedVersions []*int) error
+}
+
+// UnitOfWork tracks the aggregates loaded or created while handling a command
+// and commits their changes together.
+//
+// Each aggregate is saved with its original version as the expected version
+// so that a concurrent change to any of the aggregates is detected.
+//
+// A UnitOfWork is intended to be used for a single command and is not safe
+// for concurrent use.
+type UnitOfWork struct {
+	repo       DomainRepository
+	aggregates []AggregateRoot
+	versions   []*int
+}
+
+// NewUnitOfWork constructs a new UnitOfWork that loads and saves aggregates
+// using the repository specified.
+func NewUnitOfWork(repo DomainRepository) *UnitOfWork {
+	return &UnitOfWork{
+		repo: repo,
+	}
+}
+
+// Load loads an aggregate from the repository and tracks it.
+//
+// If the aggregate is already tracked by the unit of work, the tracked
+// instance is returned so that all changes are made to the same instance.
+func (u *UnitOfWork) Load(aggregateType, id string) (AggregateRoot, error) {
+	for _, v := range u.aggregates {
+		if typeOf(v) == aggregateType && v.AggregateID() == id {
+			return v, nil
+		}
+	}
+
+	aggregate, err := u.repo.Load(aggregateType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Track(aggregate)
+	return aggregate, nil
+}
+
+// Track adds an aggregate to the unit of work.
+//
+// Track is used for new aggregates that have not been loaded from the
+// repository. Tracking an aggregate more than once has no effect.
+func (u *UnitOfWork) Track(aggregate AggregateRoot) {
+	for _, v := range u.aggregates {
+		if v == aggregate {
+			return
+		}
+	}
+	u.aggregates = append(u.aggregates, aggregate)
+	u.versions = append(u.versions, Int(aggregate.OriginalVersion()))
+}
+
+// Aggregates returns the aggregates tracked by the unit of work.
+func (u *UnitOfWork) Aggregates() []AggregateRoot {
+	return u.aggregates
+}
+
+// Commit saves the changes of all tracked aggregates.
+//
+// If the repository implements BatchSaver the aggregates are saved with a
+// single call to SaveAll and events are published once all of the aggregates
+// have been saved. Otherwise each aggregate is saved in turn with Save, in
+// which case events are published as each aggregate is saved and a failure
+// part way is returned as an *ErrPartialCommit.
+//
+// On success the unit of work is emptied and can be reused.
+func (u *UnitOfWork) Commit() error {
+	var aggregates []AggregateRoot
+	var versions []*int
+	for k, v := range u.aggregates {
+		if len(v.GetChanges()) > 0 {
+			aggregates = append(aggregates, v)
+			versions = append(versions, u.versions[k])
+		}
+	}
+
+	if len(aggregates) > 0 {
+		if err := saveAll(u.repo, aggregates, versions); err != nil {
+			return err
+		}
+	}
+
+	u.aggregates = nil
+	u.versions = nil
+	return nil
+}
+
+// Rollback discards the changes of all tracked aggregates and empties the
+// unit of work.
+func (u *UnitOfWork) Rollback() {
+	for _, v := range u.aggregates {
+		v.ClearChanges()
+	}
+	u.aggregates = nil
+	u.versions = nil
+}
+
+// saveAll saves several aggregates with SaveAll if the repository implements
+// BatchSaver, otherwise with a call to Save for each aggregate. In that case
+// the invariants of all of the aggregates are checked before any are saved.
+func saveAll(repo DomainRepository, aggregates []AggregateRoot, versions []*int) error {
+	if saver, ok := repo.(BatchSaver); ok {
+		return saver.SaveAll(aggregates, versions)
+	}
+
+	if err := checkAllInvariants(aggregates); err != nil {
+		return err
+	}
+
+	for k, v := range aggregates {
+		if err := repo.Save(v, versions[k]); err != nil {
+			if k == 0 {
+				return err
+			}
+			return &ErrPartialCommit{Committed: aggregates[:k], Failed: v, Err: err}
+		}
+	}
+	return nil
+}
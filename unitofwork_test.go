@@ -0,0 +1,160 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"fmt"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&UnitOfWorkSuite{})
+
+type UnitOfWorkSuite struct {
+	bus     *InternalEventBus
+	handler *MockEventHandler
+	repo    *InMemoryRepository
+}
+
+func (s *UnitOfWorkSuite) SetUpTest(c *C) {
+	s.bus = NewInternalEventBus()
+	s.handler = NewMockEventHandler()
+	s.bus.AddHandler(s.handler, &SomeEvent{}, &SomeOtherEvent{})
+
+	s.repo, _ = NewInMemoryRepository(s.bus)
+	aggregateFactory := NewDelegateAggregateFactory()
+	aggregateFactory.RegisterDelegate(&SomeAggregate{},
+		func(id string) AggregateRoot { return NewSomeAggregate(id) })
+	s.repo.SetAggregateFactory(aggregateFactory)
+}
+
+func (s *UnitOfWorkSuite) seed(c *C, id string) {
+	agg := NewSomeAggregate(id)
+	agg.TrackChange(NewTestEventMessage(id))
+	c.Assert(s.repo.Save(agg, nil), IsNil)
+	s.handler.events = nil
+}
+
+func (s *UnitOfWorkSuite) TestLoadReturnsTrackedInstance(c *C) {
+	id := NewUUID()
+	s.seed(c, id)
+	uow := NewUnitOfWork(s.repo)
+
+	first, err := uow.Load(typeOf(&SomeAggregate{}), id)
+	c.Assert(err, IsNil)
+	second, err := uow.Load(typeOf(&SomeAggregate{}), id)
+	c.Assert(err, IsNil)
+
+	c.Assert(second, Equals, first)
+	c.Assert(uow.Aggregates(), HasLen, 1)
+}
+
+func (s *UnitOfWorkSuite) TestCommitSavesAllAggregatesAndPublishesAfterwards(c *C) {
+	id := NewUUID()
+	s.seed(c, id)
+	uow := NewUnitOfWork(s.repo)
+
+	loaded, _ := uow.Load(typeOf(&SomeAggregate{}), id)
+	loaded.TrackChange(NewTestEventMessage(id))
+	created := NewSomeAggregate(NewUUID())
+	created.TrackChange(NewTestEventMessage(created.AggregateID()))
+	uow.Track(created)
+
+	err := uow.Commit()
+
+	c.Assert(err, IsNil)
+	c.Assert(s.handler.events, HasLen, 2)
+	c.Assert(*s.handler.events[0].Version(), Equals, 1)
+	c.Assert(*s.handler.events[1].Version(), Equals, 0)
+	c.Assert(uow.Aggregates(), HasLen, 0)
+}
+
+func (s *UnitOfWorkSuite) TestCommitIsAtomicWithBatchSaver(c *C) {
+	id := NewUUID()
+	s.seed(c, id)
+	uow := NewUnitOfWork(s.repo)
+
+	created := NewSomeAggregate(NewUUID())
+	created.TrackChange(NewTestEventMessage(created.AggregateID()))
+	uow.Track(created)
+	loaded, _ := uow.Load(typeOf(&SomeAggregate{}), id)
+	loaded.TrackChange(NewTestEventMessage(id))
+
+	// A concurrent change to the loaded aggregate.
+	other, _ := s.repo.Load(typeOf(&SomeAggregate{}), id)
+	other.TrackChange(NewTestEventMessage(id))
+	c.Assert(s.repo.Save(other, Int(other.OriginalVersion())), IsNil)
+	s.handler.events = nil
+
+	err := uow.Commit()
+
+	c.Assert(err, FitsTypeOf, &ErrConcurrencyViolation{})
+	c.Assert(s.handler.events, HasLen, 0)
+	_, err = s.repo.Load(typeOf(created), created.AggregateID())
+	c.Assert(err, FitsTypeOf, &ErrAggregateNotFound{})
+}
+
+func (s *UnitOfWorkSuite) TestCommitWithoutBatchSaverReturnsErrPartialCommit(c *C) {
+	repo := &FakeSingleSaveRepository{fail: 1}
+	uow := NewUnitOfWork(repo)
+	first := NewSomeAggregate(NewUUID())
+	first.TrackChange(NewTestEventMessage(first.AggregateID()))
+	second := NewSomeAggregate(NewUUID())
+	second.TrackChange(NewTestEventMessage(second.AggregateID()))
+	uow.Track(first)
+	uow.Track(second)
+
+	err := uow.Commit()
+
+	c.Assert(err, DeepEquals, &ErrPartialCommit{
+		Committed: []AggregateRoot{first},
+		Failed:    second,
+		Err:       fmt.Errorf("save failed"),
+	})
+	c.Assert(repo.saved, DeepEquals, []AggregateRoot{first})
+}
+
+func (s *UnitOfWorkSuite) TestCommitSkipsAggregatesWithoutChanges(c *C) {
+	repo := &FakeSingleSaveRepository{fail: -1}
+	uow := NewUnitOfWork(repo)
+	uow.Track(NewSomeAggregate(NewUUID()))
+
+	err := uow.Commit()
+
+	c.Assert(err, IsNil)
+	c.Assert(repo.saved, HasLen, 0)
+}
+
+func (s *UnitOfWorkSuite) TestRollbackDiscardsChanges(c *C) {
+	uow := NewUnitOfWork(s.repo)
+	agg := NewSomeAggregate(NewUUID())
+	agg.TrackChange(NewTestEventMessage(agg.AggregateID()))
+	uow.Track(agg)
+
+	uow.Rollback()
+
+	c.Assert(agg.GetChanges(), HasLen, 0)
+	c.Assert(uow.Aggregates(), HasLen, 0)
+}
+
+// Fakes
+
+type FakeSingleSaveRepository struct {
+	fail  int
+	saved []AggregateRoot
+}
+
+func (r *FakeSingleSaveRepository) Load(aggregateType string, id string) (AggregateRoot, error) {
+	return nil, &ErrAggregateNotFound{AggregateType: aggregateType, AggregateID: id}
+}
+
+func (r *FakeSingleSaveRepository) Save(aggregate AggregateRoot, expectedVersion *int) error {
+	if len(r.saved) == r.fail {
+		return fmt.Errorf("save failed")
+	}
+	r.saved = append(r.saved, aggregate)
+	return nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+// UnknownEventPolicy determines what a repository does when it reads an event
+// whose type has no delegate in its event factory, such as an event written
+// by a newer version of a service.
+type UnknownEventPolicy int
+
+const (
+	// FailOnUnknownEvent fails the read with an *ErrUnknownEvent naming the
+	// stream and position of the event. This is the default.
+	FailOnUnknownEvent UnknownEventPolicy = iota
+
+	// SkipUnknownEvent skips the event. The version of the aggregate still
+	// counts the event so that it can be saved without a concurrency
+	// violation.
+	SkipUnknownEvent
+
+	// DeliverUnknownEvent delivers the event with an *UnknownEvent payload
+	// that preserves the event as it was written.
+	DeliverUnknownEvent
+)
+
+// UnknownEvent is the payload of an event whose type has no delegate in the
+// event factory, delivered under the DeliverUnknownEvent policy.
+//
+// Data is the body of the event as serialised, after it has been read from
+// the blob store and decompressed. For JSON events it is the JSON of the
+// event. Personal data fields are not decrypted.
+type UnknownEvent struct {
+	EventType     string
+	SchemaVersion int
+	ContentType   string
+	Data          []byte
+}
+
+// newUnknownEvent returns the UnknownEvent payload of a raw event.
+func newUnknownEvent(raw *RawEvent) *UnknownEvent {
+	return &UnknownEvent{
+		EventType:     raw.EventType,
+		SchemaVersion: raw.SchemaVersion,
+		ContentType:   raw.ContentType,
+		Data:          raw.Data,
+	}
+}
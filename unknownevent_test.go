@@ -0,0 +1,43 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&UnknownEventSuite{})
+
+type UnknownEventSuite struct{}
+
+func (s *UnknownEventSuite) TestNewUnknownEventPreservesTheRawEvent(c *C) {
+	raw, _ := NewRawEvent("SomeNewEvent", 2, map[string]string{"Item": "New Item"})
+
+	got := newUnknownEvent(raw)
+
+	c.Assert(got, DeepEquals, &UnknownEvent{
+		EventType:     "SomeNewEvent",
+		SchemaVersion: 2,
+		ContentType:   raw.ContentType,
+		Data:          []byte(`{"Item":"New Item"}`),
+	})
+}
+
+func (s *UnknownEventSuite) TestErrUnknownEventNamesStreamAndPosition(c *C) {
+	err := &ErrUnknownEvent{StreamName: "astream", EventNumber: 3, EventType: "SomeNewEvent"}
+
+	c.Assert(err, ErrorMatches, "There is no event factory delegate for event type SomeNewEvent at position 3 of stream astream")
+}
+
+func (s *UnknownEventSuite) TestUnknownEventsCanBeHandled(c *C) {
+	bus := NewInternalEventBus()
+	handler := NewMockEventHandler()
+	bus.AddHandler(handler, &UnknownEvent{})
+
+	bus.PublishEvent(NewEventMessage(NewUUID(), &UnknownEvent{EventType: "SomeNewEvent"}, nil))
+
+	c.Assert(handler.events, HasLen, 1)
+}
@@ -0,0 +1,244 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/jetbasrawi/go.cqrs/internal/uuid"
+)
+
+// SchemaVersionHeader is the key of the header that holds the schema version
+// of an event.
+//
+// Events persisted without this header are at schema version 1.
+const SchemaVersionHeader = "SchemaVersion"
+
+// RawEvent is an event in its serialised form as it is read from the store,
+// before it is instantiated by the EventFactory.
+//
+// Data is in the format given by ContentType. An empty content type is JSON.
+type RawEvent struct {
+	EventType     string
+	SchemaVersion int
+	ContentType   string
+	Data          []byte
+}
+
+// NewRawEvent constructs a RawEvent serialising the data provided as JSON.
+//
+// This is useful for upcasters that split one event into several.
+func NewRawEvent(eventType string, schemaVersion int, data interface{}) (*RawEvent, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &RawEvent{
+		EventType:     eventType,
+		SchemaVersion: schemaVersion,
+		Data:          b,
+	}, nil
+}
+
+// Upcaster is the interface that an upcaster must implement.
+//
+// An upcaster transforms events persisted with an older type or schema into
+// their current form as they are read from the store.
+type Upcaster interface {
+
+	// Upcast returns the current form of the raw event. An event may be
+	// transformed into any number of events. Returning no events drops the
+	// event.
+	Upcast(*RawEvent) ([]*RawEvent, error)
+
+	// SchemaVersion returns the current schema version of the event type.
+	SchemaVersion(string) int
+}
+
+// UpcasterFunc transforms a single version of an event.
+type UpcasterFunc func(*RawEvent) ([]*RawEvent, error)
+
+// Rename returns an UpcasterFunc that changes the type of an event without
+// changing its data or schema version.
+func Rename(eventType string) UpcasterFunc {
+	return func(event *RawEvent) ([]*RawEvent, error) {
+		return []*RawEvent{{
+			EventType:     eventType,
+			SchemaVersion: event.SchemaVersion,
+			ContentType:   event.ContentType,
+			Data:          event.Data,
+		}}, nil
+	}
+}
+
+// Drop returns an UpcasterFunc that removes an event.
+func Drop() UpcasterFunc {
+	return func(event *RawEvent) ([]*RawEvent, error) {
+		return nil, nil
+	}
+}
+
+// TransformJSON returns an UpcasterFunc that passes the event data as a map to
+// the function provided and increments the schema version of the event.
+//
+// The event must have been written as JSON.
+func TransformJSON(transform func(map[string]interface{}) error) UpcasterFunc {
+	return func(event *RawEvent) ([]*RawEvent, error) {
+		if event.ContentType != "" && event.ContentType != ContentTypeJSON {
+			return nil, fmt.Errorf("Can not transform event of type %s with content type %s as JSON.",
+				event.EventType, event.ContentType)
+		}
+		data := make(map[string]interface{})
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			return nil, err
+		}
+		if err := transform(data); err != nil {
+			return nil, err
+		}
+		ev, err := NewRawEvent(event.EventType, event.SchemaVersion+1, data)
+		if err != nil {
+			return nil, err
+		}
+		return []*RawEvent{ev}, nil
+	}
+}
+
+// UpcasterRegistry is an implementation of the Upcaster interface that holds
+// an UpcasterFunc for each event type and schema version.
+//
+// Upcasters are applied in chains. The events returned by an UpcasterFunc are
+// themselves upcast until no further UpcasterFunc is registered for them. An
+// UpcasterFunc that returns an event of the same type must return it at a
+// higher schema version.
+type UpcasterRegistry struct {
+	upcasters map[string]map[int]UpcasterFunc
+}
+
+// NewUpcasterRegistry constructs a new UpcasterRegistry.
+func NewUpcasterRegistry() *UpcasterRegistry {
+	return &UpcasterRegistry{
+		upcasters: make(map[string]map[int]UpcasterFunc),
+	}
+}
+
+// Register registers an UpcasterFunc for events of the type and schema version
+// specified.
+//
+// The event type is a string rather than an event instance because the type
+// of an old event may no longer exist.
+func (r *UpcasterRegistry) Register(eventType string, schemaVersion int, upcaster UpcasterFunc) error {
+	if _, ok := r.upcasters[eventType]; !ok {
+		r.upcasters[eventType] = make(map[int]UpcasterFunc)
+	}
+	if _, ok := r.upcasters[eventType][schemaVersion]; ok {
+		return fmt.Errorf("Upcaster already registered for type: \"%s\" version: %d", eventType, schemaVersion)
+	}
+	r.upcasters[eventType][schemaVersion] = upcaster
+	return nil
+}
+
+// SchemaVersion returns the current schema version of an event type, which is
+// one more than the highest version for which an upcaster is registered.
+func (r *UpcasterRegistry) SchemaVersion(eventType string) int {
+	version := 1
+	for v := range r.upcasters[eventType] {
+		if v >= version {
+			version = v + 1
+		}
+	}
+	return version
+}
+
+// Upcast applies the chain of registered upcasters to a raw event.
+func (r *UpcasterRegistry) Upcast(event *RawEvent) ([]*RawEvent, error) {
+	return r.upcast(event, map[string]bool{})
+}
+
+func (r *UpcasterRegistry) upcast(event *RawEvent, seen map[string]bool) ([]*RawEvent, error) {
+	upcaster, ok := r.upcasters[event.EventType][event.SchemaVersion]
+	if !ok {
+		return []*RawEvent{event}, nil
+	}
+
+	key := fmt.Sprintf("%s/%d", event.EventType, event.SchemaVersion)
+	if seen[key] {
+		return nil, fmt.Errorf("Upcaster cycle detected at type: \"%s\" version: %d", event.EventType, event.SchemaVersion)
+	}
+	seen[key] = true
+	defer delete(seen, key)
+
+	results, err := upcaster(event)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*RawEvent
+	for _, v := range results {
+		if v.EventType == event.EventType && v.SchemaVersion <= event.SchemaVersion {
+			return nil, fmt.Errorf("Upcaster for type: \"%s\" version: %d did not increase the schema version",
+				event.EventType, event.SchemaVersion)
+		}
+		upcasted, err := r.upcast(v, seen)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, upcasted...)
+	}
+	return ret, nil
+}
+
+// VerifyUpcast upcasts a raw event, instantiates the results with the event
+// factory and compares them with the expected events.
+//
+// VerifyUpcast is intended for use in tests. It returns nil if the results
+// match the expected events and otherwise an error describing the difference.
+//
+//	err := ycq.VerifyUpcast(registry, factory,
+//		&ycq.RawEvent{EventType: "ItemRenamed", SchemaVersion: 1, Data: []byte(`{"Name":"a"}`)},
+//		&ItemRenamed{Name: "a", Reason: "unknown"})
+func VerifyUpcast(upcaster Upcaster, factory EventFactory, event *RawEvent, expected ...interface{}) error {
+	serializers := NewSerializerRegistry()
+	results, err := upcaster.Upcast(event)
+	if err != nil {
+		return err
+	}
+
+	if len(results) != len(expected) {
+		return fmt.Errorf("Expected %d events but the upcaster returned %d", len(expected), len(results))
+	}
+
+	for k, v := range results {
+		got := factory.GetEvent(v.EventType)
+		if got == nil {
+			return fmt.Errorf("Event %d: no event factory delegate for event type: %s", k, v.EventType)
+		}
+		serializer, err := serializers.SerializerByContentType(v.ContentType)
+		if err != nil {
+			return fmt.Errorf("Event %d: %s", k, err)
+		}
+		if err := serializer.Unmarshal(v.Data, got); err != nil {
+			return fmt.Errorf("Event %d: %s", k, err)
+		}
+		if !reflect.DeepEqual(got, expected[k]) {
+			return fmt.Errorf("Event %d: expected %#v but got %#v", k, expected[k], got)
+		}
+	}
+	return nil
+}
+
+// splitEventID returns the id of the event at the index specified among the
+// events into which an upcaster split a persisted event. The first event keeps
+// the id of the persisted event and the others are given ids derived from it,
+// so that every event has its own id and the ids are the same each time the
+// event is read.
+func splitEventID(id string, index int) string {
+	if index == 0 {
+		return id
+	}
+	return uuid.NewV5(uuid.FromStringOrNil(id), fmt.Sprintf("%s/%d", id, index)).String()
+}
@@ -0,0 +1,142 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycq
+
+import (
+	"encoding/json"
+	"fmt"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&UpcasterRegistrySuite{})
+
+type UpcasterRegistrySuite struct {
+	registry *UpcasterRegistry
+	factory  *DelegateEventFactory
+}
+
+func (s *UpcasterRegistrySuite) SetUpTest(c *C) {
+	s.registry = NewUpcasterRegistry()
+	s.factory = NewDelegateEventFactory()
+	s.factory.RegisterDelegate(&SomeEvent{},
+		func() interface{} { return &SomeEvent{} })
+	s.factory.RegisterDelegate(&SomeOtherEvent{},
+		func() interface{} { return &SomeOtherEvent{} })
+}
+
+func (s *UpcasterRegistrySuite) TestEventsWithoutUpcasterAreUnchanged(c *C) {
+	ev := &RawEvent{EventType: "SomeEvent", SchemaVersion: 1, Data: []byte(`{"Item":"a","Count":1}`)}
+
+	got, err := s.registry.Upcast(ev)
+
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, []*RawEvent{ev})
+}
+
+func (s *UpcasterRegistrySuite) TestDuplicateRegistrationReturnsAnError(c *C) {
+	c.Assert(s.registry.Register("SomeEvent", 1, Drop()), IsNil)
+
+	err := s.registry.Register("SomeEvent", 1, Drop())
+
+	c.Assert(err, DeepEquals, fmt.Errorf("Upcaster already registered for type: \"SomeEvent\" version: 1"))
+}
+
+func (s *UpcasterRegistrySuite) TestSchemaVersion(c *C) {
+	c.Assert(s.registry.SchemaVersion("SomeEvent"), Equals, 1)
+
+	s.registry.Register("SomeEvent", 1, Drop())
+	s.registry.Register("SomeEvent", 2, Drop())
+
+	c.Assert(s.registry.SchemaVersion("SomeEvent"), Equals, 3)
+}
+
+func (s *UpcasterRegistrySuite) TestRenameAndTransformAreChained(c *C) {
+	s.registry.Register("ItemAdded", 1, Rename("SomeEvent"))
+	s.registry.Register("SomeEvent", 1, TransformJSON(func(data map[string]interface{}) error {
+		data["Count"] = 1
+		return nil
+	}))
+
+	err := VerifyUpcast(s.registry, s.factory,
+		&RawEvent{EventType: "ItemAdded", SchemaVersion: 1, Data: []byte(`{"Item":"a"}`)},
+		&SomeEvent{Item: "a", Count: 1})
+
+	c.Assert(err, IsNil)
+}
+
+func (s *UpcasterRegistrySuite) TestSplitEvent(c *C) {
+	s.registry.Register("ItemOrdered", 1, func(ev *RawEvent) ([]*RawEvent, error) {
+		data := struct{ Item, OrderID string }{}
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return nil, err
+		}
+		first, _ := NewRawEvent("SomeEvent", 1, &SomeEvent{Item: data.Item})
+		second, _ := NewRawEvent("SomeOtherEvent", 1, &SomeOtherEvent{OrderID: data.OrderID})
+		return []*RawEvent{first, second}, nil
+	})
+
+	err := VerifyUpcast(s.registry, s.factory,
+		&RawEvent{EventType: "ItemOrdered", SchemaVersion: 1, Data: []byte(`{"Item":"a","OrderID":"b"}`)},
+		&SomeEvent{Item: "a"}, &SomeOtherEvent{OrderID: "b"})
+
+	c.Assert(err, IsNil)
+}
+
+func (s *UpcasterRegistrySuite) TestSplitEventsHaveTheirOwnIDs(c *C) {
+	id := NewUUID()
+
+	first := splitEventID(id, 0)
+	second := splitEventID(id, 1)
+	third := splitEventID(id, 2)
+
+	c.Assert(first, Equals, id)
+	c.Assert(second, Not(Equals), id)
+	c.Assert(third, Not(Equals), second)
+	c.Assert(splitEventID(id, 1), Equals, second)
+}
+
+func (s *UpcasterRegistrySuite) TestDropEvent(c *C) {
+	s.registry.Register("Obsolete", 1, Drop())
+
+	got, err := s.registry.Upcast(&RawEvent{EventType: "Obsolete", SchemaVersion: 1})
+
+	c.Assert(err, IsNil)
+	c.Assert(got, HasLen, 0)
+}
+
+func (s *UpcasterRegistrySuite) TestUpcasterMustIncreaseSchemaVersion(c *C) {
+	s.registry.Register("SomeEvent", 1, func(ev *RawEvent) ([]*RawEvent, error) {
+		return []*RawEvent{ev}, nil
+	})
+
+	_, err := s.registry.Upcast(&RawEvent{EventType: "SomeEvent", SchemaVersion: 1})
+
+	c.Assert(err, ErrorMatches, "Upcaster for type: \"SomeEvent\" version: 1 did not increase the schema version")
+}
+
+func (s *UpcasterRegistrySuite) TestRenameCycleReturnsAnError(c *C) {
+	s.registry.Register("A", 1, Rename("B"))
+	s.registry.Register("B", 1, Rename("A"))
+
+	_, err := s.registry.Upcast(&RawEvent{EventType: "A", SchemaVersion: 1})
+
+	c.Assert(err, ErrorMatches, "Upcaster cycle detected at type: \"A\" version: 1")
+}
+
+func (s *UpcasterRegistrySuite) TestVerifyUpcastDescribesMismatch(c *C) {
+	s.registry.Register("SomeEvent", 1, TransformJSON(func(data map[string]interface{}) error {
+		data["Count"] = 2
+		return nil
+	}))
+	ev := &RawEvent{EventType: "SomeEvent", SchemaVersion: 1, Data: []byte(`{"Item":"a"}`)}
+
+	err := VerifyUpcast(s.registry, s.factory, ev, &SomeEvent{Item: "a", Count: 1})
+	c.Assert(err, ErrorMatches, "Event 0: expected .* but got .*")
+
+	err = VerifyUpcast(s.registry, s.factory, ev)
+	c.Assert(err, ErrorMatches, "Expected 0 events but the upcaster returned 1")
+}
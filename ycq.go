@@ -15,7 +15,7 @@
 package ycq
 
 import (
-	"reflect"
+	"fmt"
 
 	"github.com/jetbasrawi/go.cqrs/internal/uuid"
 )
@@ -23,10 +23,15 @@ import (
 // typeOf is a convenience function that returns the name of a type
 //
 // This is used so commonly throughout the code that it is better to
-// have this convenience function and also allows for changing the scheme
-// used for the type name more easily if desired.
+// have this convenience function. Names are given by the TypeNamer set with
+// SetTypeNamer. Values that can not be named, such as nil, are described by
+// their Go type rather than causing a panic.
 func typeOf(i interface{}) string {
-	return reflect.TypeOf(i).Elem().Name()
+	name, err := TypeNameOf(i)
+	if err != nil {
+		return fmt.Sprintf("%T", i)
+	}
+	return name
 }
 
 // NewUUID returns a new v4 uuid as a string
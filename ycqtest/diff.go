@@ -0,0 +1,157 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycqtest
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// eventsEqual reports whether two sequences of event payloads are equal.
+func eventsEqual(expected, actual []interface{}) bool {
+	if len(expected) != len(actual) {
+		return false
+	}
+	for k := range expected {
+		if !reflect.DeepEqual(expected[k], actual[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+// diffEvents describes the differences between the events expected and the
+// events saved. Events that match are listed unmarked, expected events that
+// were not saved are marked with a minus and saved events that were not
+// expected with a plus. Where an event of the expected type was saved with
+// different values, the fields that differ are listed.
+func diffEvents(expected, actual []interface{}) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "The events saved do not match the events expected. Expected %d events, got %d.\n",
+		len(expected), len(actual))
+
+	n := len(expected)
+	if len(actual) > n {
+		n = len(actual)
+	}
+	for k := 0; k < n; k++ {
+		switch {
+		case k >= len(actual):
+			fmt.Fprintf(&b, "- %d: %s\n", k, formatEvent(expected[k]))
+		case k >= len(expected):
+			fmt.Fprintf(&b, "+ %d: %s\n", k, formatEvent(actual[k]))
+		case reflect.DeepEqual(expected[k], actual[k]):
+			fmt.Fprintf(&b, "  %d: %s\n", k, formatEvent(actual[k]))
+		default:
+			fmt.Fprintf(&b, "- %d: %s\n", k, formatEvent(expected[k]))
+			fmt.Fprintf(&b, "+ %d: %s\n", k, formatEvent(actual[k]))
+			for _, d := range diffFields(expected[k], actual[k]) {
+				fmt.Fprintf(&b, "      %s\n", d)
+			}
+		}
+	}
+	return b.String()
+}
+
+// formatEvent formats an event payload, or other value, with its type and
+// field names.
+func formatEvent(event interface{}) string {
+	if event == nil {
+		return "<nil>"
+	}
+	v := reflect.Indirect(reflect.ValueOf(event))
+	if !v.IsValid() {
+		return fmt.Sprintf("%T(nil)", event)
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Sprintf("%#v", event)
+	}
+	return fmt.Sprintf("%T%+v", event, v.Interface())
+}
+
+// diffFields lists the fields that differ between two structs of the same
+// type. Nothing is listed for values of different types or that are not
+// structs.
+func diffFields(expected, actual interface{}) []string {
+	if reflect.TypeOf(expected) != reflect.TypeOf(actual) {
+		return nil
+	}
+	ev := reflect.Indirect(reflect.ValueOf(expected))
+	av := reflect.Indirect(reflect.ValueOf(actual))
+	if !ev.IsValid() || !av.IsValid() || ev.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var diffs []string
+	for i := 0; i < ev.NumField(); i++ {
+		field := ev.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		e, a := ev.Field(i).Interface(), av.Field(i).Interface()
+		if !reflect.DeepEqual(e, a) {
+			diffs = append(diffs, fmt.Sprintf("%s: expected %#v, got %#v", field.Name, e, a))
+		}
+	}
+	return diffs
+}
+
+// diffState describes the differences between two states of a read model,
+// or returns an empty string if they are equal. The fields of structs and the
+// keys of maps that differ are listed.
+func diffState(before, after interface{}) string {
+	if reflect.DeepEqual(before, after) {
+		return ""
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "- %s\n", formatEvent(before))
+	fmt.Fprintf(&b, "+ %s\n", formatEvent(after))
+
+	diffs := diffFields(before, after)
+	bv, av := reflect.ValueOf(before), reflect.ValueOf(after)
+	if bv.IsValid() && av.IsValid() && bv.Type() == av.Type() && bv.Kind() == reflect.Map {
+		diffs = diffKeys(bv, av)
+	}
+	for _, d := range diffs {
+		fmt.Fprintf(&b, "      %s\n", d)
+	}
+	return b.String()
+}
+
+// diffKeys lists the keys whose values differ between two maps of the same
+// type, in the order of their formatted keys.
+func diffKeys(before, after reflect.Value) []string {
+	keys := make(map[string]reflect.Value)
+	for _, k := range before.MapKeys() {
+		keys[fmt.Sprintf("%#v", k.Interface())] = k
+	}
+	for _, k := range after.MapKeys() {
+		keys[fmt.Sprintf("%#v", k.Interface())] = k
+	}
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var diffs []string
+	for _, name := range names {
+		b, a := before.MapIndex(keys[name]), after.MapIndex(keys[name])
+		switch {
+		case !a.IsValid():
+			diffs = append(diffs, fmt.Sprintf("%s: removed", name))
+		case !b.IsValid():
+			diffs = append(diffs, fmt.Sprintf("%s: added %s", name, formatEvent(a.Interface())))
+		case !reflect.DeepEqual(b.Interface(), a.Interface()):
+			diffs = append(diffs, fmt.Sprintf("%s: expected %s, got %s", name,
+				formatEvent(b.Interface()), formatEvent(a.Interface())))
+		}
+	}
+	return diffs
+}
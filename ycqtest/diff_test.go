@@ -0,0 +1,52 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycqtest
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&DiffSuite{})
+
+type DiffSuite struct{}
+
+func (s *DiffSuite) TestEventsEqualComparesValues(c *C) {
+	c.Assert(eventsEqual(
+		[]interface{}{&SomeAccountOpened{ID: "1"}},
+		[]interface{}{&SomeAccountOpened{ID: "1"}}), Equals, true)
+	c.Assert(eventsEqual(
+		[]interface{}{&SomeAccountOpened{ID: "1"}},
+		[]interface{}{&SomeAccountOpened{ID: "2"}}), Equals, false)
+	c.Assert(eventsEqual(
+		[]interface{}{&SomeAccountOpened{ID: "1"}},
+		[]interface{}{SomeAccountOpened{ID: "1"}}), Equals, false)
+	c.Assert(eventsEqual(nil, []interface{}{}), Equals, true)
+}
+
+func (s *DiffSuite) TestDiffMarksMissingAndUnexpectedEvents(c *C) {
+	diff := diffEvents(
+		[]interface{}{&SomeAccountOpened{ID: "1"}, &SomeAccountClosed{ID: "1"}},
+		[]interface{}{&SomeAccountOpened{ID: "1"}, &SomeAmountDeposited{ID: "1", Amount: 2}, &SomeAccountClosed{ID: "1"}})
+
+	c.Assert(diff, Equals,
+		"The events saved do not match the events expected. Expected 2 events, got 3.\n"+
+			"  0: *ycqtest.SomeAccountOpened{ID:1 Owner:}\n"+
+			"- 1: *ycqtest.SomeAccountClosed{ID:1}\n"+
+			"+ 1: *ycqtest.SomeAmountDeposited{ID:1 Amount:2}\n"+
+			"+ 2: *ycqtest.SomeAccountClosed{ID:1}\n")
+}
+
+func (s *DiffSuite) TestDiffListsTheFieldsThatDiffer(c *C) {
+	c.Assert(diffFields(
+		&SomeAccountOpened{ID: "1", Owner: "Jo"},
+		&SomeAccountOpened{ID: "2", Owner: "Jo"}), DeepEquals, []string{`ID: expected "1", got "2"`})
+	c.Assert(diffFields(&SomeAccountOpened{}, &SomeAccountClosed{}), IsNil)
+}
+
+func (s *DiffSuite) TestFormatEventHandlesNil(c *C) {
+	c.Assert(formatEvent(nil), Equals, "<nil>")
+	c.Assert(formatEvent((*SomeAccountOpened)(nil)), Equals, "*ycqtest.SomeAccountOpened(nil)")
+}
@@ -0,0 +1,130 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycqtest
+
+import (
+	"time"
+
+	"github.com/jetbasrawi/go.cqrs"
+)
+
+// ProjectionScenario feeds events through an EventHandler, such as a read
+// model, so that the state of the read model can be checked.
+//
+// Events are given as payloads and are wrapped in EventMessages with an
+// aggregate id, the next version of that aggregate, an event id and the time
+// at which they occurred. The events of Given belong to an aggregate whose id
+// is generated for the scenario; GivenFor names the aggregate.
+//
+//	view := NewInventoryItemDetailView()
+//	p := ycqtest.NewProjectionScenario(t, view)
+//	p.Given(&InventoryItemCreated{ID: p.AggregateID(), Name: "Widget"},
+//		&ItemsCheckedIntoInventory{ID: p.AggregateID(), Count: 5})
+//
+//	// Check the state of the view, then check that handling the events
+//	// again does not change it.
+//	p.ThenIdempotent(func() interface{} { return *view.Details(p.AggregateID()) })
+type ProjectionScenario struct {
+	t           T
+	handler     ycq.EventHandler
+	aggregateID string
+	versions    map[string]int
+	events      []ycq.EventMessage
+}
+
+// NewProjectionScenario constructs a new ProjectionScenario that feeds events
+// through the handler specified and reports failures to t.
+func NewProjectionScenario(t T, handler ycq.EventHandler) *ProjectionScenario {
+	if h, ok := t.(tHelper); ok {
+		h.Helper()
+	}
+	p := &ProjectionScenario{
+		t:           t,
+		handler:     handler,
+		aggregateID: ycq.NewUUID(),
+		versions:    make(map[string]int),
+	}
+	if handler == nil {
+		p.t.Errorf("Nil event handler injected into projection scenario.")
+		p.t.FailNow()
+	}
+	return p
+}
+
+// AggregateID returns the id of the aggregate of the events of Given.
+func (p *ProjectionScenario) AggregateID() string {
+	return p.aggregateID
+}
+
+// Given feeds events of the aggregate of the scenario through the handler.
+//
+// Events may be payloads or EventMessages. EventMessages are handled as they
+// are, so that events with particular versions or headers can be given.
+func (p *ProjectionScenario) Given(events ...interface{}) *ProjectionScenario {
+	return p.GivenFor(p.aggregateID, events...)
+}
+
+// GivenFor feeds events of the aggregate specified through the handler.
+// Versions are assigned per aggregate, starting at 0.
+func (p *ProjectionScenario) GivenFor(aggregateID string, events ...interface{}) *ProjectionScenario {
+	for _, event := range events {
+		em, ok := event.(ycq.EventMessage)
+		if !ok {
+			em = p.newEvent(aggregateID, event)
+		} else if v := em.Version(); v != nil && *v >= p.versions[em.AggregateID()] {
+			p.versions[em.AggregateID()] = *v + 1
+		}
+		p.events = append(p.events, em)
+		p.handler.Handle(em)
+	}
+	return p
+}
+
+// Events returns the messages fed through the handler, in order.
+func (p *ProjectionScenario) Events() []ycq.EventMessage {
+	return append([]ycq.EventMessage(nil), p.events...)
+}
+
+// Replay feeds all of the events given so far through the handler again, in
+// the same order and with the same messages, as happens when a subscription
+// delivers events more than once.
+func (p *ProjectionScenario) Replay() *ProjectionScenario {
+	for _, em := range p.Events() {
+		p.handler.Handle(em)
+	}
+	return p
+}
+
+// ThenIdempotent checks that replaying the events does not change the state
+// of the read model.
+//
+// The state function is called before and after the replay and its results
+// are compared. It must return a copy of the state, such as a struct value or
+// a copied map, rather than a reference to state that the replay changes.
+func (p *ProjectionScenario) ThenIdempotent(state func() interface{}) {
+	if h, ok := p.t.(tHelper); ok {
+		h.Helper()
+	}
+	before := state()
+	p.Replay()
+	after := state()
+
+	if diff := diffState(before, after); diff != "" {
+		p.t.Errorf("The read model is not idempotent. Replaying %d events changed its state.\n%s",
+			len(p.events), diff)
+	}
+}
+
+// newEvent returns a new event message of an aggregate at its next version.
+func (p *ProjectionScenario) newEvent(aggregateID string, event interface{}) ycq.EventMessage {
+	version := p.versions[aggregateID]
+	p.versions[aggregateID] = version + 1
+
+	em := ycq.NewEventMessage(aggregateID, event, ycq.Int(version))
+	em.SetHeader(ycq.EventIDHeader, ycq.NewUUID())
+	em.SetHeader(ycq.OccurredAtHeader, time.Now().UTC())
+	return em
+}
@@ -0,0 +1,143 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycqtest
+
+import (
+	"github.com/jetbasrawi/go.cqrs"
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&ProjectionSuite{})
+
+type ProjectionSuite struct{}
+
+// SomeBalanceView is a read model of account balances that ignores events it
+// has already handled by their version.
+type SomeBalanceView struct {
+	balances map[string]int
+	versions map[string]int
+}
+
+func NewSomeBalanceView() *SomeBalanceView {
+	return &SomeBalanceView{
+		balances: make(map[string]int),
+		versions: make(map[string]int),
+	}
+}
+
+func (v *SomeBalanceView) Handle(em ycq.EventMessage) {
+	id := em.AggregateID()
+	if last, ok := v.versions[id]; ok && *em.Version() <= last {
+		return
+	}
+	v.versions[id] = *em.Version()
+	if ev, ok := em.Event().(*SomeAmountDeposited); ok {
+		v.balances[id] += ev.Amount
+	}
+}
+
+func (v *SomeBalanceView) state() interface{} {
+	balances := make(map[string]int)
+	for k, b := range v.balances {
+		balances[k] = b
+	}
+	return balances
+}
+
+// SomeNaiveBalanceView is a read model of account balances that is not
+// idempotent.
+type SomeNaiveBalanceView struct {
+	balances map[string]int
+}
+
+func (v *SomeNaiveBalanceView) Handle(em ycq.EventMessage) {
+	if ev, ok := em.Event().(*SomeAmountDeposited); ok {
+		v.balances[em.AggregateID()] += ev.Amount
+	}
+}
+
+func (s *ProjectionSuite) TestEventsAreGivenVersionsPerAggregate(c *C) {
+	p := NewProjectionScenario(c, NewSomeBalanceView())
+
+	p.Given(&SomeAccountOpened{}, &SomeAmountDeposited{Amount: 1}).
+		GivenFor("other", &SomeAccountOpened{}).
+		Given(&SomeAmountDeposited{Amount: 2})
+
+	events := p.Events()
+	c.Assert(events, HasLen, 4)
+	c.Assert(events[0].AggregateID(), Equals, p.AggregateID())
+	c.Assert(*events[0].Version(), Equals, 0)
+	c.Assert(*events[1].Version(), Equals, 1)
+	c.Assert(events[2].AggregateID(), Equals, "other")
+	c.Assert(*events[2].Version(), Equals, 0)
+	c.Assert(*events[3].Version(), Equals, 2)
+	c.Assert(ycq.EventIDOf(events[0]), Not(Equals), "")
+	c.Assert(ycq.OccurredAtOf(events[0]).IsZero(), Equals, false)
+}
+
+func (s *ProjectionSuite) TestEventsAreFedThroughTheHandler(c *C) {
+	view := NewSomeBalanceView()
+	p := NewProjectionScenario(c, view)
+
+	p.Given(&SomeAmountDeposited{Amount: 1}, &SomeAmountDeposited{Amount: 2})
+
+	c.Assert(view.balances[p.AggregateID()], Equals, 3)
+}
+
+func (s *ProjectionSuite) TestEventMessagesAreHandledAsGiven(c *C) {
+	p := NewProjectionScenario(c, NewSomeBalanceView())
+	em := ycq.NewEventMessage("some-id", &SomeAmountDeposited{Amount: 1}, ycq.Int(4))
+
+	p.GivenFor("some-id", em, &SomeAmountDeposited{Amount: 1})
+
+	c.Assert(p.Events()[0], Equals, em)
+	c.Assert(*p.Events()[1].Version(), Equals, 5)
+}
+
+func (s *ProjectionSuite) TestIdempotentReadModelsPass(c *C) {
+	view := NewSomeBalanceView()
+	p := NewProjectionScenario(c, view)
+
+	p.Given(&SomeAmountDeposited{Amount: 1}, &SomeAmountDeposited{Amount: 2})
+
+	p.ThenIdempotent(view.state)
+	c.Assert(view.balances[p.AggregateID()], Equals, 3)
+}
+
+func (s *ProjectionSuite) TestReadModelsThatAreNotIdempotentFail(c *C) {
+	t := &FakeT{}
+	view := &SomeNaiveBalanceView{balances: make(map[string]int)}
+	p := NewProjectionScenario(t, view)
+	p.GivenFor("some-id", &SomeAmountDeposited{Amount: 1}, &SomeAmountDeposited{Amount: 2})
+
+	p.ThenIdempotent(func() interface{} { return view.balances["some-id"] })
+
+	c.Assert(t.errors, DeepEquals, []string{
+		"The read model is not idempotent. Replaying 2 events changed its state.\n" +
+			"- 3\n" +
+			"+ 6\n",
+	})
+}
+
+func (s *ProjectionSuite) TestChangedMapKeysAreListed(c *C) {
+	diff := diffState(
+		map[string]int{"a": 1, "b": 2},
+		map[string]int{"a": 1, "b": 3, "c": 4})
+
+	c.Assert(diff, Equals,
+		"- map[string]int{\"a\":1, \"b\":2}\n"+
+			"+ map[string]int{\"a\":1, \"b\":3, \"c\":4}\n"+
+			"      \"b\": expected 2, got 3\n"+
+			"      \"c\": added 4\n")
+}
+
+func (s *ProjectionSuite) TestNilHandlerFails(c *C) {
+	t := &FakeT{}
+
+	NewProjectionScenario(t, nil)
+
+	c.Assert(t.failed, Equals, true)
+}
@@ -0,0 +1,282 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package ycqtest provides a Given/When/Then kit for testing aggregates and
+// command handlers.
+//
+// A Scenario is given the prior events of aggregates, a command is dispatched
+// through an InMemoryDispatcher to command handlers that use an
+// InMemoryRepository, and the events saved, or the error returned, are
+// compared with those expected.
+//
+//	func TestDeactivate(t *testing.T) {
+//		s := ycqtest.NewScenario(t, factory)
+//		s.RegisterHandler(NewInventoryCommandHandlers(s.Repository()), &DeactivateInventoryItem{})
+//
+//		s.Given(NewInventoryItem(id), &InventoryItemCreated{ID: id, Name: "Widget"}).
+//			When(ycq.NewCommandMessage(id, &DeactivateInventoryItem{})).
+//			Then(&InventoryItemDeactivated{ID: id})
+//	}
+//
+// Failures are reported to a T, which is implemented by *testing.T and
+// by the *check.C of gocheck.
+package ycqtest
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/jetbasrawi/go.cqrs"
+)
+
+// T is the interface through which a Scenario reports failures. It is
+// implemented by *testing.T, *testing.B and *check.C.
+type T interface {
+	Errorf(format string, args ...interface{})
+	FailNow()
+}
+
+// Scenario is a Given/When/Then specification of the behaviour of aggregates
+// and command handlers.
+type Scenario struct {
+	t          T
+	bus        *recordingEventBus
+	repo       *ycq.InMemoryRepository
+	dispatcher *ycq.InMemoryDispatcher
+	dispatched bool
+	err        error
+}
+
+// NewScenario constructs a new Scenario that reports failures to t. The
+// in memory repository of the scenario loads aggregates with the factory
+// specified.
+func NewScenario(t T, factory ycq.AggregateFactory) *Scenario {
+	if h, ok := t.(tHelper); ok {
+		h.Helper()
+	}
+	s := &Scenario{
+		t:          t,
+		bus:        newRecordingEventBus(),
+		dispatcher: ycq.NewInMemoryDispatcher(),
+	}
+
+	repo, err := ycq.NewInMemoryRepository(s.bus)
+	if err != nil {
+		s.fatalf("The scenario could not create a repository. %s", err)
+		return s
+	}
+	if factory == nil {
+		s.fatalf("Nil aggregate factory injected into scenario.")
+		return s
+	}
+	repo.SetAggregateFactory(factory)
+	s.repo = repo
+	return s
+}
+
+// Repository returns the repository of the scenario, for constructing the
+// command handlers under test.
+func (s *Scenario) Repository() *ycq.InMemoryRepository {
+	return s.repo
+}
+
+// Dispatcher returns the dispatcher through which commands are dispatched.
+func (s *Scenario) Dispatcher() *ycq.InMemoryDispatcher {
+	return s.dispatcher
+}
+
+// EventBus returns the event bus on which saved events are published. Event
+// handlers added to the bus receive the events of Given and When.
+func (s *Scenario) EventBus() ycq.EventBus {
+	return s.bus
+}
+
+// RegisterHandler registers a command handler with the dispatcher for the
+// command types specified by the variadic commands parameter.
+func (s *Scenario) RegisterHandler(handler ycq.CommandHandler, commands ...interface{}) *Scenario {
+	if h, ok := s.t.(tHelper); ok {
+		h.Helper()
+	}
+	if err := s.dispatcher.RegisterHandler(handler, commands...); err != nil {
+		s.fatalf("The scenario could not register the command handler. %s", err)
+	}
+	return s
+}
+
+// Given saves events as the history of an aggregate. The id and type of the
+// aggregate give the stream of the events, which are saved without being
+// applied to it.
+//
+// Given may be called more than once, for different aggregates or with the
+// same instance of an aggregate to continue its history.
+func (s *Scenario) Given(aggregate ycq.AggregateRoot, events ...interface{}) *Scenario {
+	if h, ok := s.t.(tHelper); ok {
+		h.Helper()
+	}
+	if s.dispatched {
+		s.fatalf("Given was called after When.")
+		return s
+	}
+
+	for _, event := range events {
+		em := ycq.NewEventMessage(aggregate.AggregateID(), event, ycq.Int(aggregate.CurrentVersion()+1))
+		aggregate.TrackChange(em)
+	}
+	if err := s.repo.Save(aggregate, nil); err != nil {
+		s.fatalf("The scenario could not save the given events. %s", err)
+	}
+	aggregate.ClearChanges()
+	for range events {
+		aggregate.IncrementVersion()
+	}
+	s.bus.reset()
+	return s
+}
+
+// When dispatches a command. The events saved while the command is handled
+// and the error returned by the dispatcher are recorded for Then.
+func (s *Scenario) When(command ycq.CommandMessage) *Scenario {
+	if h, ok := s.t.(tHelper); ok {
+		h.Helper()
+	}
+	if s.dispatched {
+		s.fatalf("When was called more than once.")
+		return s
+	}
+	s.dispatched = true
+	s.bus.reset()
+	s.err = s.dispatcher.Dispatch(command)
+	return s
+}
+
+// Then checks that the command was handled without error and that exactly the
+// events specified were saved, in order. Events are compared by their
+// payloads.
+func (s *Scenario) Then(events ...interface{}) {
+	if h, ok := s.t.(tHelper); ok {
+		h.Helper()
+	}
+	if !s.whenCalled() {
+		return
+	}
+	if s.err != nil {
+		s.t.Errorf("Expected the command to succeed but it returned an error of type %T: %s", s.err, s.err)
+		if got := s.payloads(); len(got) > 0 {
+			s.t.Errorf("%s", diffEvents(events, got))
+		}
+		return
+	}
+	if got := s.payloads(); !eventsEqual(events, got) {
+		s.t.Errorf("%s", diffEvents(events, got))
+	}
+}
+
+// ThenNoEvents checks that the command was handled without error and that no
+// events were saved.
+func (s *Scenario) ThenNoEvents() {
+	if h, ok := s.t.(tHelper); ok {
+		h.Helper()
+	}
+	s.Then()
+}
+
+// ThenError checks that the command returned an error of the type of target,
+// such as &ycq.ErrCommandExecution{}, and that no events were saved.
+func (s *Scenario) ThenError(target error) {
+	if h, ok := s.t.(tHelper); ok {
+		h.Helper()
+	}
+	if !s.whenCalled() {
+		return
+	}
+	if s.err == nil {
+		s.t.Errorf("Expected the command to return an error of type %T but it succeeded.", target)
+	} else if reflect.TypeOf(s.err) != reflect.TypeOf(target) {
+		s.t.Errorf("Expected the command to return an error of type %T but it returned an error of type %T: %s",
+			target, s.err, s.err)
+	}
+	if got := s.payloads(); len(got) > 0 {
+		s.t.Errorf("%s", diffEvents(nil, got))
+	}
+}
+
+// Events returns the messages of the events saved while the command was
+// handled.
+func (s *Scenario) Events() []ycq.EventMessage {
+	return s.bus.recorded()
+}
+
+// Err returns the error returned by the dispatcher.
+func (s *Scenario) Err() error {
+	return s.err
+}
+
+func (s *Scenario) whenCalled() bool {
+	if h, ok := s.t.(tHelper); ok {
+		h.Helper()
+	}
+	if !s.dispatched {
+		s.t.Errorf("Then was called before When.")
+		return false
+	}
+	return true
+}
+
+func (s *Scenario) payloads() []interface{} {
+	var payloads []interface{}
+	for _, em := range s.bus.recorded() {
+		payloads = append(payloads, em.Event())
+	}
+	return payloads
+}
+
+func (s *Scenario) fatalf(format string, args ...interface{}) {
+	if h, ok := s.t.(tHelper); ok {
+		h.Helper()
+	}
+	s.t.Errorf(format, args...)
+	s.t.FailNow()
+}
+
+// tHelper is implemented by T implementations that can mark functions
+// as test helpers, so that failures are reported at the line of the test.
+type tHelper interface {
+	Helper()
+}
+
+// recordingEventBus is an event bus that records the events published to it
+// before passing them to an InternalEventBus.
+type recordingEventBus struct {
+	*ycq.InternalEventBus
+	mu     sync.Mutex
+	events []ycq.EventMessage
+}
+
+func newRecordingEventBus() *recordingEventBus {
+	return &recordingEventBus{
+		InternalEventBus: ycq.NewInternalEventBus(),
+	}
+}
+
+// PublishEvent records the event and publishes it to the handlers added to
+// the bus.
+func (b *recordingEventBus) PublishEvent(event ycq.EventMessage) {
+	b.mu.Lock()
+	b.events = append(b.events, event)
+	b.mu.Unlock()
+	b.InternalEventBus.PublishEvent(event)
+}
+
+func (b *recordingEventBus) recorded() []ycq.EventMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]ycq.EventMessage(nil), b.events...)
+}
+
+func (b *recordingEventBus) reset() {
+	b.mu.Lock()
+	b.events = nil
+	b.mu.Unlock()
+}
@@ -0,0 +1,176 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycqtest
+
+import (
+	"github.com/jetbasrawi/go.cqrs"
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&ScenarioSuite{})
+
+type ScenarioSuite struct{}
+
+func (s *ScenarioSuite) TestThenPassesWithGocheck(c *C) {
+	id := ycq.NewUUID()
+
+	newSomeAccountScenario(c).
+		Given(NewSomeAccount(id), &SomeAccountOpened{ID: id, Owner: "Jo"}).
+		When(ycq.NewCommandMessage(id, &CloseSomeAccount{})).
+		Then(&SomeAccountClosed{ID: id})
+}
+
+func (s *ScenarioSuite) TestCommandsWithoutHistory(c *C) {
+	id := ycq.NewUUID()
+
+	newSomeAccountScenario(c).
+		When(ycq.NewCommandMessage(id, &OpenSomeAccount{Owner: "Jo"})).
+		Then(&SomeAccountOpened{ID: id, Owner: "Jo"})
+}
+
+func (s *ScenarioSuite) TestGivenEventsAreLoadedByTheHandler(c *C) {
+	id := ycq.NewUUID()
+	account := NewSomeAccount(id)
+
+	newSomeAccountScenario(c).
+		Given(account, &SomeAccountOpened{ID: id, Owner: "Jo"}).
+		Given(account, &SomeAccountClosed{ID: id}).
+		When(ycq.NewCommandMessage(id, &DepositSomeAmount{Amount: 5})).
+		ThenError(&ycq.ErrCommandExecution{})
+}
+
+func (s *ScenarioSuite) TestSavedEventsHaveTheNextVersion(c *C) {
+	id := ycq.NewUUID()
+
+	scenario := newSomeAccountScenario(c).
+		Given(NewSomeAccount(id), &SomeAccountOpened{ID: id}, &SomeAmountDeposited{ID: id, Amount: 1}).
+		When(ycq.NewCommandMessage(id, &DepositSomeAmount{Amount: 2}))
+
+	c.Assert(scenario.Events(), HasLen, 1)
+	c.Assert(*scenario.Events()[0].Version(), Equals, 2)
+}
+
+func (s *ScenarioSuite) TestEventHandlersOnTheBusReceiveEvents(c *C) {
+	id := ycq.NewUUID()
+	handler := &SomeRecordingHandler{}
+	scenario := newSomeAccountScenario(c)
+	scenario.EventBus().AddHandler(handler, &SomeAccountOpened{}, &SomeAmountDeposited{})
+
+	scenario.Given(NewSomeAccount(id), &SomeAccountOpened{ID: id}).
+		When(ycq.NewCommandMessage(id, &DepositSomeAmount{Amount: 2}))
+
+	c.Assert(handler.events, HasLen, 2)
+}
+
+func (s *ScenarioSuite) TestThenReportsUnexpectedEvents(c *C) {
+	t := &FakeT{}
+	id := ycq.NewUUID()
+
+	newSomeAccountScenario(t).
+		Given(NewSomeAccount(id), &SomeAccountOpened{ID: id, Owner: "Jo"}).
+		When(ycq.NewCommandMessage(id, &DepositSomeAmount{Amount: 5})).
+		Then(&SomeAmountDeposited{ID: id, Amount: 6})
+
+	c.Assert(t.errors, HasLen, 1)
+	c.Assert(t.errors[0], Equals,
+		"The events saved do not match the events expected. Expected 1 events, got 1.\n"+
+			"- 0: *ycqtest.SomeAmountDeposited{ID:"+id+" Amount:6}\n"+
+			"+ 0: *ycqtest.SomeAmountDeposited{ID:"+id+" Amount:5}\n"+
+			"      Amount: expected 6, got 5\n")
+}
+
+func (s *ScenarioSuite) TestThenReportsAnUnexpectedError(c *C) {
+	t := &FakeT{}
+	id := ycq.NewUUID()
+
+	newSomeAccountScenario(t).
+		Given(NewSomeAccount(id), &SomeAccountOpened{ID: id, Owner: "Jo"}).
+		When(ycq.NewCommandMessage(id, &DepositSomeAmount{Amount: -1})).
+		Then(&SomeAmountDeposited{ID: id, Amount: -1})
+
+	c.Assert(t.errors, DeepEquals, []string{
+		"Expected the command to succeed but it returned an error of type *errors.errorString: the amount must be positive",
+	})
+}
+
+func (s *ScenarioSuite) TestThenNoEventsReportsEvents(c *C) {
+	t := &FakeT{}
+	id := ycq.NewUUID()
+
+	newSomeAccountScenario(t).
+		When(ycq.NewCommandMessage(id, &OpenSomeAccount{Owner: "Jo"})).
+		ThenNoEvents()
+
+	c.Assert(t.errors, DeepEquals, []string{
+		"The events saved do not match the events expected. Expected 0 events, got 1.\n" +
+			"+ 0: *ycqtest.SomeAccountOpened{ID:" + id + " Owner:Jo}\n",
+	})
+}
+
+func (s *ScenarioSuite) TestThenErrorReportsAnErrorOfAnotherType(c *C) {
+	t := &FakeT{}
+	id := ycq.NewUUID()
+
+	newSomeAccountScenario(t).
+		Given(NewSomeAccount(id), &SomeAccountOpened{ID: id, Owner: "Jo"}).
+		When(ycq.NewCommandMessage(id, &DepositSomeAmount{Amount: -1})).
+		ThenError(&ycq.ErrCommandExecution{})
+
+	c.Assert(t.errors, DeepEquals, []string{
+		"Expected the command to return an error of type *ycq.ErrCommandExecution but it returned an error of type *errors.errorString: the amount must be positive",
+	})
+}
+
+func (s *ScenarioSuite) TestThenErrorReportsSuccess(c *C) {
+	t := &FakeT{}
+	id := ycq.NewUUID()
+
+	newSomeAccountScenario(t).
+		Given(NewSomeAccount(id), &SomeAccountOpened{ID: id, Owner: "Jo"}).
+		When(ycq.NewCommandMessage(id, &DepositSomeAmount{Amount: 1})).
+		ThenError(&ycq.ErrCommandExecution{})
+
+	c.Assert(t.errors, HasLen, 2)
+	c.Assert(t.errors[0], Equals, "Expected the command to return an error of type *ycq.ErrCommandExecution but it succeeded.")
+}
+
+func (s *ScenarioSuite) TestThenBeforeWhenFails(c *C) {
+	t := &FakeT{}
+
+	newSomeAccountScenario(t).Then()
+
+	c.Assert(t.errors, DeepEquals, []string{"Then was called before When."})
+}
+
+func (s *ScenarioSuite) TestUnregisteredCommandsFail(c *C) {
+	t := &FakeT{}
+	factory := ycq.NewDelegateAggregateFactory()
+
+	NewScenario(t, factory).
+		When(ycq.NewCommandMessage(ycq.NewUUID(), &OpenSomeAccount{})).
+		ThenNoEvents()
+
+	c.Assert(t.errors, HasLen, 1)
+	c.Assert(t.errors[0], Matches, "Expected the command to succeed .* does not have a handler .*")
+}
+
+func (s *ScenarioSuite) TestNilFactoryFails(c *C) {
+	t := &FakeT{}
+
+	NewScenario(t, nil)
+
+	c.Assert(t.failed, Equals, true)
+	c.Assert(t.errors, DeepEquals, []string{"Nil aggregate factory injected into scenario."})
+}
+
+// SomeRecordingHandler records the events it handles.
+type SomeRecordingHandler struct {
+	events []ycq.EventMessage
+}
+
+func (h *SomeRecordingHandler) Handle(em ycq.EventMessage) {
+	h.events = append(h.events, em)
+}
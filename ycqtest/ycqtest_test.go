@@ -0,0 +1,131 @@
+// Copyright 2016 Jet Basrawi. All rights reserved.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package ycqtest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jetbasrawi/go.cqrs"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type SomeAccountOpened struct {
+	ID    string
+	Owner string
+}
+
+type SomeAmountDeposited struct {
+	ID     string
+	Amount int
+}
+
+type SomeAccountClosed struct {
+	ID string
+}
+
+type OpenSomeAccount struct {
+	Owner string
+}
+
+type DepositSomeAmount struct {
+	Amount int
+}
+
+type CloseSomeAccount struct{}
+
+// SomeAccount is an aggregate used to test scenarios.
+type SomeAccount struct {
+	*ycq.AggregateBase
+	balance int
+	closed  bool
+}
+
+func NewSomeAccount(id string) *SomeAccount {
+	return &SomeAccount{AggregateBase: ycq.NewAggregateBase(id)}
+}
+
+func (a *SomeAccount) Apply(em ycq.EventMessage, isNew bool) {
+	if isNew {
+		a.TrackChange(em)
+	}
+	switch ev := em.Event().(type) {
+	case *SomeAmountDeposited:
+		a.balance += ev.Amount
+	case *SomeAccountClosed:
+		a.closed = true
+	}
+}
+
+// SomeAccountHandler handles the commands of SomeAccount.
+type SomeAccountHandler struct {
+	repo ycq.DomainRepository
+}
+
+func (h *SomeAccountHandler) Handle(message ycq.CommandMessage) error {
+	id := message.AggregateID()
+	if cmd, ok := message.Command().(*OpenSomeAccount); ok {
+		a := NewSomeAccount(id)
+		a.RaiseEvent(a, &SomeAccountOpened{ID: id, Owner: cmd.Owner})
+		return h.repo.Save(a, nil)
+	}
+
+	agg, err := h.repo.Load("SomeAccount", id)
+	if err != nil {
+		return err
+	}
+	a := agg.(*SomeAccount)
+	if a.closed {
+		return &ycq.ErrCommandExecution{Command: message, Reason: "the account is closed"}
+	}
+
+	switch cmd := message.Command().(type) {
+	case *DepositSomeAmount:
+		if cmd.Amount <= 0 {
+			return errors.New("the amount must be positive")
+		}
+		a.RaiseEvent(a, &SomeAmountDeposited{ID: id, Amount: cmd.Amount})
+	case *CloseSomeAccount:
+		a.RaiseEvent(a, &SomeAccountClosed{ID: id})
+	}
+	return h.repo.Save(a, ycq.Int(a.OriginalVersion()))
+}
+
+func newSomeAccountScenario(t T) *Scenario {
+	factory := ycq.NewDelegateAggregateFactory()
+	factory.RegisterConstructors(NewSomeAccount)
+	s := NewScenario(t, factory)
+	return s.RegisterHandler(&SomeAccountHandler{repo: s.Repository()},
+		&OpenSomeAccount{}, &DepositSomeAmount{}, &CloseSomeAccount{})
+}
+
+// FakeT records the failures reported to it.
+type FakeT struct {
+	errors []string
+	failed bool
+}
+
+func (t *FakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *FakeT) FailNow() {
+	t.failed = true
+}
+
+// TestScenarioWithTestingT shows a scenario used with the testing package
+// directly.
+func TestScenarioWithTestingT(t *testing.T) {
+	id := ycq.NewUUID()
+
+	newSomeAccountScenario(t).
+		Given(NewSomeAccount(id), &SomeAccountOpened{ID: id, Owner: "Jo"}).
+		When(ycq.NewCommandMessage(id, &DepositSomeAmount{Amount: 5})).
+		Then(&SomeAmountDeposited{ID: id, Amount: 5})
+}